@@ -12,10 +12,49 @@ func init() {
 
 const (
 	TypeKubeconfigProvisioned     api.ConditionType = "KubeconfigProvisioned"
+	TypeSpecValid                 api.ConditionType = "SpecValid"
+	TypeOwnershipVerified         api.ConditionType = "OwnershipVerified"
 	TypeServiceAccountProvisioned api.ConditionType = "ServiceAccountProvisioned"
 	TypeStalePermissionsRemoved   api.ConditionType = "StalePermissionsRemoved"
+	TypeRevocationProcessed       api.ConditionType = "RevocationProcessed"
+	TypePermissionsReviewed       api.ConditionType = "PermissionsReviewed"
+	TypeTokenReviewed             api.ConditionType = "TokenReviewed"
+	TypeTokenLifetimeCapped       api.ConditionType = "TokenLifetimeCapped"
+	TypeUnknownResources          api.ConditionType = "UnknownResources"
+	TypeNamespacesMerged          api.ConditionType = "NamespacesMerged"
 )
 
+// CredentialType is the kind of credential published in the kubeconfig.
+// +kubebuilder:validation:Enum=ServiceAccountToken;ClientCertificate
+type CredentialType string
+
+const (
+	// CredentialTypeServiceAccountToken publishes a token of the service account requested via the TokenRequest API.
+	CredentialTypeServiceAccountToken CredentialType = "ServiceAccountToken"
+	// CredentialTypeClientCertificate publishes a client certificate issued by the kubernetes.io/kube-apiserver-client
+	// signer via the CertificateSigningRequest API. No service account is created, permissions are bound to the user
+	// of the certificate instead.
+	CredentialTypeClientCertificate CredentialType = "ClientCertificate"
+)
+
+// PresetName is the name of a built-in set of permissions.
+// +kubebuilder:validation:Enum=readOnlyCluster;namespaceEditor;namespaceAdmin;secretsDenied
+type PresetName string
+
+const (
+	// PresetReadOnlyCluster grants read access to the built-in resources of all namespaces and the cluster.
+	PresetReadOnlyCluster PresetName = "readOnlyCluster"
+	// PresetNamespaceEditor grants write access to workloads, configuration and networking resources of a namespace.
+	PresetNamespaceEditor PresetName = "namespaceEditor"
+	// PresetNamespaceAdmin grants the namespaceEditor permissions and manages Roles and RoleBindings of a namespace.
+	PresetNamespaceAdmin PresetName = "namespaceAdmin"
+	// PresetSecretsDenied removes access to secrets from all permissions of the kubeconfig except role references.
+	PresetSecretsDenied PresetName = "secretsDenied"
+)
+
+// AnnotationRotateRequestedAt requests an immediate token rotation when set to a new value e.g. the current timestamp.
+const AnnotationRotateRequestedAt = "klaud.works/rotate-requested-at"
+
 // Kubeconfig is the Schema for the Kubeconfig API
 // +kubebuilder:object:root=true
 // +kubebuilder:resource:scope=Namespaced
@@ -23,6 +62,7 @@ const (
 // +kubebuilder:printcolumn:name="Issued",type="string",JSONPath=".status.serviceAccountTokenIssuedAt",description="Kubeconfig issued timestamp"
 // +kubebuilder:printcolumn:name="Expires",type="string",JSONPath=".status.serviceAccountTokenExpiresAt",description="Kubeconfig expiration timestamp"
 // +kubebuilder:printcolumn:name="Refreshes",type="string",JSONPath=".status.serviceAccountTokenRefreshesAt",description="Kubeconfig refresh timestamp"
+// +kubebuilder:printcolumn:name="Rotated",type="string",JSONPath=".status.serviceAccountTokenRotatedAt",description="Kubeconfig last rotation timestamp"
 type Kubeconfig struct {
 	metav1.TypeMeta   `json:",inline"`
 	metav1.ObjectMeta `json:"metadata,omitempty"`
@@ -54,32 +94,266 @@ type KubeconfigSpec struct {
 	// +kubebuilder:default="kubernetes"
 	ClusterName string `json:"clusterName,omitempty"`
 
+	// CredentialType is the kind of credential published in the kubeconfig.
+	// "ServiceAccountToken" publishes a service account token, "ClientCertificate" publishes a client certificate
+	// for the user reported in status.user. The token status fields describe the certificate for client certificates.
+	// Optional
+	// +kubebuilder:default="ServiceAccountToken"
+	CredentialType CredentialType `json:"credentialType,omitempty"`
+
 	// ExpirationTTL is the time to live for the service account token.
-	// Specified in days e.g. "365d". Default is 365 days.
+	// Specified as one or more integers with a unit of weeks, days, hours, minutes or seconds e.g. "365d", "1w" or
+	// "1d12h", or as an ISO 8601 duration without years and months e.g. "P30D". Must be at least 10m.
+	// Default is 365 days.
 	// Optional
 	// +kubebuilder:default="365d"
 	ExpirationTTL string `json:"expirationTTL,omitempty"`
 
-	// NamespacedPermissions defines a list of namespaced scoped permissions. Optional
+	// RefreshPolicy defines when the service account token is refreshed.
+	// By default, the token is refreshed after 80% of its lifetime.
+	// Optional
+	RefreshPolicy *RefreshPolicy `json:"refreshPolicy,omitempty"`
+
+	// Audiences are the intended audiences of the service account token e.g. "vault".
+	// Defaults to the audiences of the API server. Changing the audiences issues a new token.
+	// Optional
+	Audiences []string `json:"audiences,omitempty"`
+
+	// BindTokenToSecret binds the issued token to the kubeconfig secret.
+	// Deleting the secret invalidates the token on the API server and a new token is issued.
+	// Each rotation recreates the secret which immediately invalidates the previous token.
+	// Optional
+	BindTokenToSecret bool `json:"bindTokenToSecret,omitempty"`
+
+	// RotationGracePeriod keeps publishing the previous token for the given duration after a rotation e.g. "1h".
+	// The previous token is stored under "token.previous" and as a second user and context in the kubeconfig so
+	// consumers that haven't reloaded the secret yet keep working. Tokens that were rotated because they became invalid
+	// are not kept. Has no effect if BindTokenToSecret is set. Disabled by default.
+	// Optional
+	RotationGracePeriod string `json:"rotationGracePeriod,omitempty"`
+
+	// RevocationGeneration revokes all previously issued tokens when it is changed e.g. incremented.
+	// The service account is recreated with a new UID which invalidates its outstanding tokens.
+	// Role bindings refer to the service account by name and therefore apply to the new service account.
+	// Client certificates can't be revoked, instead the generation is part of the certificate user so role bindings
+	// no longer apply to previously issued certificates.
+	// Optional
+	RevocationGeneration int64 `json:"revocationGeneration,omitempty"`
+
+	// NamespacedPermissions defines a list of namespaced scoped permissions.
+	// Entries applying to the same namespace are merged into a single Role. Entries limited by notBefore or
+	// validUntil are only merged while they are active. Optional
 	NamespacedPermissions []NamespacedPermissions `json:"namespacedPermissions,omitempty"`
 
 	// ClusterPermissions defines cluster scoped permissions. Optional
 	ClusterPermissions *ClusterPermissions `json:"clusterPermissions,omitempty"`
+
+	// RoleRefs binds existing Roles and ClusterRoles e.g. the "view" ClusterRole. Only the bindings are managed,
+	// the referenced roles are neither created nor modified. Optional
+	RoleRefs []RoleRef `json:"roleRefs,omitempty"`
+
+	// Presets grant built-in sets of permissions in addition to the permissions above.
+	// The expanded rules are listed in status.presets. Optional
+	Presets []Preset `json:"presets,omitempty"`
+
+	// Impersonate lets the credential act as another user and groups e.g. to reuse the RBAC rules of a team. Only
+	// the permission to impersonate exactly this identity is granted and the kubeconfig impersonates it for every
+	// request. Optional
+	Impersonate *Impersonation `json:"impersonate,omitempty"`
+
+	// AdoptExisting takes over existing roles and bindings with the names chosen by the operator that weren't created
+	// by it. By default, such objects are left untouched and reported by the OwnershipVerified condition. Adopted
+	// objects are overwritten and deleted with the Kubeconfig. Objects owned by another Kubeconfig and service accounts
+	// are never adopted. Optional
+	AdoptExisting bool `json:"adoptExisting,omitempty"`
 }
 
+// Impersonation is the identity the credential of a Kubeconfig acts as.
+type Impersonation struct {
+	// User to act as e.g. "jane@example.com" or "system:serviceaccount:ci:deployer". Required
+	// +kubebuilder:validation:MinLength=1
+	User string `json:"user"`
+
+	// Groups to act as. Optional
+	Groups []string `json:"groups,omitempty"`
+
+	// Extra attributes of the user to act with e.g. "scopes". Optional
+	Extra map[string][]string `json:"extra,omitempty"`
+}
+
+type RefreshPolicy struct {
+	// LifetimePercentage refreshes the token after the given percentage of its lifetime passed.
+	// Ignored if RefreshBefore is set. Default is 80.
+	// Optional
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=99
+	LifetimePercentage *int32 `json:"lifetimePercentage,omitempty"`
+
+	// RefreshBefore refreshes the token the given duration before it expires e.g. "7d".
+	// Uses the same format as ExpirationTTL and must be shorter than it.
+	// Optional
+	RefreshBefore string `json:"refreshBefore,omitempty"`
+
+	// Jitter moves the refresh time up to the given duration earlier e.g. "1h".
+	// This spreads out the rotation of many Kubeconfigs created at the same time.
+	// Uses the same format as ExpirationTTL and must be shorter than the time until the token is refreshed.
+	// Optional
+	Jitter string `json:"jitter,omitempty"`
+}
+
+// +kubebuilder:validation:XValidation:rule="has(self.__namespace__) != has(self.namespaceSelector)",message="exactly one of namespace or namespaceSelector is required"
+// +kubebuilder:validation:XValidation:rule="!has(self.notBefore) || !has(self.validUntil) || self.notBefore < self.validUntil",message="validUntil must be after notBefore"
 type NamespacedPermissions struct {
-	// Namespace the role applies to. Required
-	Namespace string `json:"namespace"`
+	// Namespace the role applies to. Either Namespace or NamespaceSelector is required.
+	Namespace string `json:"namespace,omitempty"`
+
+	// NamespaceSelector applies the role to all namespaces matching the selector. Roles are created and removed as
+	// namespaces start or stop matching. Either Namespace or NamespaceSelector is required.
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
 
 	// Rules for the role. Required
 	Rules []rbacv1.PolicyRule `json:"rules"`
+
+	// ExcludeResources removes resources from the rules e.g. "secrets", "deployments.apps" or "pods/exec". Rules
+	// granting all resources ("*") are expanded into the resources the API server serves for their API groups, so
+	// "everything but secrets" can be granted. Resources served later e.g. by new CustomResourceDefinitions aren't
+	// granted unless IncludeNewResources is set.
+	// Optional
+	ExcludeResources []string `json:"excludeResources,omitempty"`
+
+	// IncludeNewResources expands the rules excluding resources into resources served later as well, the expansion is
+	// recomputed when CustomResourceDefinitions change. Creating the Kubeconfig requires holding the rules without the
+	// exclusions then. Otherwise, the rules are expanded into the resources served when the permissions last changed.
+	// Optional
+	IncludeNewResources bool `json:"includeNewResources,omitempty"`
+
+	// NotBefore grants the permissions from the given time on e.g. "2025-01-01T08:00:00Z". Optional
+	NotBefore *metav1.Time `json:"notBefore,omitempty"`
+
+	// ValidUntil removes the permissions at the given time e.g. to grant them temporarily. Optional
+	ValidUntil *metav1.Time `json:"validUntil,omitempty"`
 }
 
+// +kubebuilder:validation:XValidation:rule="!has(self.notBefore) || !has(self.validUntil) || self.notBefore < self.validUntil",message="validUntil must be after notBefore"
 type ClusterPermissions struct {
 	// Rules for the role. Required
 	Rules []rbacv1.PolicyRule `json:"rules"`
+
+	// ExcludeResources removes resources from the rules e.g. "secrets", "deployments.apps" or "pods/exec". Rules
+	// granting all resources ("*") are expanded into the resources the API server serves for their API groups, so
+	// "everything but secrets" can be granted. Resources served later e.g. by new CustomResourceDefinitions aren't
+	// granted unless IncludeNewResources is set.
+	// Optional
+	ExcludeResources []string `json:"excludeResources,omitempty"`
+
+	// IncludeNewResources expands the rules excluding resources into resources served later as well, the expansion is
+	// recomputed when CustomResourceDefinitions change. Creating the Kubeconfig requires holding the rules without the
+	// exclusions then. Otherwise, the rules are expanded into the resources served when the permissions last changed.
+	// Optional
+	IncludeNewResources bool `json:"includeNewResources,omitempty"`
+
+	// NotBefore grants the permissions from the given time on e.g. "2025-01-01T08:00:00Z". Optional
+	NotBefore *metav1.Time `json:"notBefore,omitempty"`
+
+	// ValidUntil removes the permissions at the given time e.g. to grant them temporarily. Optional
+	ValidUntil *metav1.Time `json:"validUntil,omitempty"`
 }
 
+// RoleRef references an existing Role or ClusterRole to bind.
+// +kubebuilder:validation:XValidation:rule="self.kind == 'ClusterRole' || (has(self.__namespace__) && size(self.__namespace__) > 0)",message="namespace is required for Roles"
+type RoleRef struct {
+	// Kind of the referenced role. Either "Role" or "ClusterRole". Required
+	// +kubebuilder:validation:Enum=Role;ClusterRole
+	Kind string `json:"kind"`
+
+	// Name of the referenced role. Required
+	// +kubebuilder:validation:MinLength=1
+	Name string `json:"name"`
+
+	// Namespace the role is bound in. Required for Roles.
+	// ClusterRoles are bound within the namespace via a RoleBinding if set, otherwise cluster-wide via a
+	// ClusterRoleBinding. Optional
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// Preset selects a built-in set of permissions.
+// +kubebuilder:validation:XValidation:rule="!(self.name in ['namespaceEditor', 'namespaceAdmin']) || (has(self.__namespace__) && size(self.__namespace__) > 0)",message="namespace is required for namespaceEditor and namespaceAdmin"
+// +kubebuilder:validation:XValidation:rule="self.name in ['namespaceEditor', 'namespaceAdmin'] || !has(self.__namespace__)",message="namespace is only supported for namespaceEditor and namespaceAdmin"
+type Preset struct {
+	// Name of the preset. One of "readOnlyCluster", "namespaceEditor", "namespaceAdmin" or "secretsDenied".
+	// Required
+	Name PresetName `json:"name"`
+
+	// Namespace the preset applies to. Required for "namespaceEditor" and "namespaceAdmin".
+	Namespace string `json:"namespace,omitempty"`
+
+	// Version of the preset definition. New versions are added when a preset changes, so existing Kubeconfigs keep
+	// their permissions until the version is increased. Default is 1.
+	// Optional
+	// +kubebuilder:default=1
+	// +kubebuilder:validation:Minimum=1
+	Version int32 `json:"version,omitempty"`
+}
+
+// ExpandedPreset lists the rules a preset expanded to.
+type ExpandedPreset struct {
+	// Name of the preset.
+	Name PresetName `json:"name"`
+
+	// Namespace the preset applies to. Empty for cluster-wide presets.
+	Namespace string `json:"namespace,omitempty"`
+
+	// Version of the preset definition.
+	Version int32 `json:"version"`
+
+	// Rules the preset granted after applying "secretsDenied".
+	Rules []rbacv1.PolicyRule `json:"rules,omitempty"`
+}
+
+// EffectivePermissions lists the rules the credential of the Kubeconfig is allowed in a namespace, including rules
+// granted by bindings that weren't created by the operator.
+type EffectivePermissions struct {
+	// Namespace the rules apply to. Empty for the rules that apply cluster-wide.
+	Namespace string `json:"namespace,omitempty"`
+
+	// Rules the credential is allowed. Rules of a namespace don't repeat the rules that apply cluster-wide.
+	Rules []rbacv1.PolicyRule `json:"rules,omitempty"`
+
+	// Incomplete is set if the API server couldn't list all rules e.g. because an authorizer besides RBAC is used.
+	Incomplete bool `json:"incomplete,omitempty"`
+}
+
+// ScheduledPermissions describes permissions limited in time by notBefore or validUntil.
+type ScheduledPermissions struct {
+	// Permissions is the path of the permissions in the spec e.g. "namespacedPermissions[1]" or "clusterPermissions".
+	Permissions string `json:"permissions"`
+
+	// Namespace of the namespaced permissions. Empty for cluster permissions and namespace selectors.
+	Namespace string `json:"namespace,omitempty"`
+
+	// NotBefore is when the permissions are granted.
+	NotBefore *metav1.Time `json:"notBefore,omitempty"`
+
+	// ValidUntil is when the permissions are removed.
+	ValidUntil *metav1.Time `json:"validUntil,omitempty"`
+
+	// Active is set while the permissions are granted, upcoming permissions aren't active yet.
+	Active bool `json:"active,omitempty"`
+}
+
+// ExpandedResources records the API resources rules excluding resources are expanded into.
+type ExpandedResources struct {
+	// PermissionsHash identifies the namespaced and cluster permissions the resources were recorded for.
+	PermissionsHash string `json:"permissionsHash"`
+
+	// Resources served when the permissions last changed, qualified by their API group e.g. "pods", "pods/exec" or
+	// "deployments.apps".
+	Resources []string `json:"resources,omitempty"`
+}
+
+// NOTE: the status is written via a JSON merge patch which leaves fields missing from the patch untouched. Fields the
+// reconciler clears again are therefore not omitempty but +optional, so the empty value removes them from the status.
+
 // KubeconfigStatus defines the observed state of Kubeconfig
 type KubeconfigStatus struct {
 	api.ConditionedStatus `json:",inline"`
@@ -90,8 +364,37 @@ type KubeconfigStatus struct {
 	// KubeconfigSecretRef is a reference to the Secret containing the kubeconfig.
 	KubeconfigSecretRef *string `json:"kubeconfigSecretRef,omitempty"`
 
+	// CoveredNamespaces lists the namespaces the namespaced permissions currently apply to.
+	// +optional
+	CoveredNamespaces []string `json:"coveredNamespaces"`
+
+	// Presets lists the rules the presets of the spec expanded to.
+	// +optional
+	Presets []ExpandedPreset `json:"presets"`
+
+	// EffectivePermissions lists what the credential of the Kubeconfig is allowed to do cluster-wide and in each
+	// namespace the Kubeconfig grants permissions in, as reported by the API server.
+	// +optional
+	EffectivePermissions []EffectivePermissions `json:"effectivePermissions"`
+
+	// ScheduledPermissions lists the permissions limited in time that are active or upcoming. Expired permissions
+	// aren't listed.
+	// +optional
+	ScheduledPermissions []ScheduledPermissions `json:"scheduledPermissions"`
+
+	// ExpandedResources lists the resources rules excluding resources without includeNewResources are expanded into.
+	// They are recorded when the permissions change, so resources served later aren't granted.
+	// +optional
+	ExpandedResources *ExpandedResources `json:"expandedResources"`
+
 	// ServiceAccountRef is a reference to the ServiceAccount that will be used to provision the kubeconfig.
-	ServiceAccountRef *string `json:"serviceAccountRef,omitempty"`
+	// Empty for client certificates.
+	// +optional
+	ServiceAccountRef *string `json:"serviceAccountRef"`
+
+	// User is the name the API server authenticates the published credential as. Permissions are bound to this user
+	// for client certificates.
+	User string `json:"user,omitempty"`
 
 	// ServiceAccountTokenExpiresAt specifies when the service account token will expire.
 	ServiceAccountTokenExpiresAt *metav1.Time `json:"serviceAccountTokenExpiresAt,omitempty"`
@@ -99,8 +402,60 @@ type KubeconfigStatus struct {
 	// ServiceAccountTokenRefreshesAt specifies when the service account token will be refreshed.
 	ServiceAccountTokenRefreshesAt *metav1.Time `json:"serviceAccountTokenRefreshesAt,omitempty"`
 
+	// NOTE: not a pointer like the other timestamps as the unstructured converter panics on nil timestamps without
+	// omitempty. The zero value is written as null instead.
+
+	// PreviousTokenPublishedUntil specifies when the previous token is removed from the kubeconfig secret.
+	// Empty if no previous token is published.
+	// +optional
+	PreviousTokenPublishedUntil metav1.Time `json:"previousTokenPublishedUntil"`
+
 	// ServiceAccountTokenIssuedAt specifies when the service account token was issued.
 	ServiceAccountTokenIssuedAt *metav1.Time `json:"serviceAccountTokenIssuedAt,omitempty"`
+
+	// ServiceAccountTokenRequestedTTL specifies the lifetime the service account token was requested with.
+	ServiceAccountTokenRequestedTTL *metav1.Duration `json:"serviceAccountTokenRequestedTTL,omitempty"`
+
+	// ServiceAccountTokenEffectiveTTL specifies the lifetime the API server granted to the service account token.
+	// Shorter than the requested TTL if the API server caps the token lifetime e.g. via
+	// --service-account-max-token-expiration.
+	ServiceAccountTokenEffectiveTTL *metav1.Duration `json:"serviceAccountTokenEffectiveTTL,omitempty"`
+
+	// ServiceAccountTokenRequestedAudiences specifies the audiences the service account token was requested with.
+	// +optional
+	ServiceAccountTokenRequestedAudiences []string `json:"serviceAccountTokenRequestedAudiences"`
+
+	// ServiceAccountTokenAudiences specifies the audiences granted to the service account token.
+	// +optional
+	ServiceAccountTokenAudiences []string `json:"serviceAccountTokenAudiences"`
+
+	// ServiceAccountTokenKeyID specifies the ID of the API server key that signed the service account token.
+	// The token is reissued once the API server stops publishing this key.
+	// +optional
+	ServiceAccountTokenKeyID string `json:"serviceAccountTokenKeyID"`
+
+	// ServiceAccountTokenRotatedAt specifies when an existing service account token was last replaced by a new one.
+	ServiceAccountTokenRotatedAt *metav1.Time `json:"serviceAccountTokenRotatedAt,omitempty"`
+
+	// ServiceAccountTokenRotationReason specifies why the current service account token was issued.
+	// One of "NoToken", "RefreshDue", "ExpirationTTLChanged", "RotationRequested", "ServiceAccountChanged",
+	// "BindingChanged", "AudiencesChanged", "TokenRejected", "SigningKeyRotated" or "SubjectChanged".
+	ServiceAccountTokenRotationReason string `json:"serviceAccountTokenRotationReason,omitempty"`
+
+	// ServiceAccountTokenPendingRotationReason specifies why the service account token is rotated while the kubeconfig
+	// secret is recreated to bind the new token to it. It is reported as the rotation reason once the token is issued.
+	// +optional
+	ServiceAccountTokenPendingRotationReason string `json:"serviceAccountTokenPendingRotationReason"`
+
+	// RevocationGeneration is the last handled spec.revocationGeneration.
+	// +optional
+	RevocationGeneration int64 `json:"revocationGeneration"`
+
+	// RevokedAt specifies when all previously issued tokens were last revoked.
+	RevokedAt *metav1.Time `json:"revokedAt,omitempty"`
+
+	// RotationRequestHandled is the value of the klaud.works/rotate-requested-at annotation that was last handled.
+	RotationRequestHandled string `json:"rotationRequestHandled,omitempty"`
 }
 
 func (c *Kubeconfig) GetConditions() []api.Condition {
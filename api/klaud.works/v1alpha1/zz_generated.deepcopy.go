@@ -7,7 +7,8 @@ package v1alpha1
 
 import (
 	"github.com/reddit/achilles-sdk-api/api"
-	v1 "k8s.io/api/rbac/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
@@ -16,11 +17,24 @@ func (in *ClusterPermissions) DeepCopyInto(out *ClusterPermissions) {
 	*out = *in
 	if in.Rules != nil {
 		in, out := &in.Rules, &out.Rules
-		*out = make([]v1.PolicyRule, len(*in))
+		*out = make([]rbacv1.PolicyRule, len(*in))
 		for i := range *in {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.ExcludeResources != nil {
+		in, out := &in.ExcludeResources, &out.ExcludeResources
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.NotBefore != nil {
+		in, out := &in.NotBefore, &out.NotBefore
+		*out = (*in).DeepCopy()
+	}
+	if in.ValidUntil != nil {
+		in, out := &in.ValidUntil, &out.ValidUntil
+		*out = (*in).DeepCopy()
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ClusterPermissions.
@@ -33,6 +47,105 @@ func (in *ClusterPermissions) DeepCopy() *ClusterPermissions {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *EffectivePermissions) DeepCopyInto(out *EffectivePermissions) {
+	*out = *in
+	if in.Rules != nil {
+		in, out := &in.Rules, &out.Rules
+		*out = make([]rbacv1.PolicyRule, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EffectivePermissions.
+func (in *EffectivePermissions) DeepCopy() *EffectivePermissions {
+	if in == nil {
+		return nil
+	}
+	out := new(EffectivePermissions)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ExpandedPreset) DeepCopyInto(out *ExpandedPreset) {
+	*out = *in
+	if in.Rules != nil {
+		in, out := &in.Rules, &out.Rules
+		*out = make([]rbacv1.PolicyRule, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ExpandedPreset.
+func (in *ExpandedPreset) DeepCopy() *ExpandedPreset {
+	if in == nil {
+		return nil
+	}
+	out := new(ExpandedPreset)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ExpandedResources) DeepCopyInto(out *ExpandedResources) {
+	*out = *in
+	if in.Resources != nil {
+		in, out := &in.Resources, &out.Resources
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ExpandedResources.
+func (in *ExpandedResources) DeepCopy() *ExpandedResources {
+	if in == nil {
+		return nil
+	}
+	out := new(ExpandedResources)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Impersonation) DeepCopyInto(out *Impersonation) {
+	*out = *in
+	if in.Groups != nil {
+		in, out := &in.Groups, &out.Groups
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Extra != nil {
+		in, out := &in.Extra, &out.Extra
+		*out = make(map[string][]string, len(*in))
+		for key, val := range *in {
+			var outVal []string
+			if val == nil {
+				(*out)[key] = nil
+			} else {
+				in, out := &val, &outVal
+				*out = make([]string, len(*in))
+				copy(*out, *in)
+			}
+			(*out)[key] = outVal
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Impersonation.
+func (in *Impersonation) DeepCopy() *Impersonation {
+	if in == nil {
+		return nil
+	}
+	out := new(Impersonation)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Kubeconfig) DeepCopyInto(out *Kubeconfig) {
 	*out = *in
@@ -95,6 +208,16 @@ func (in *KubeconfigList) DeepCopyObject() runtime.Object {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *KubeconfigSpec) DeepCopyInto(out *KubeconfigSpec) {
 	*out = *in
+	if in.RefreshPolicy != nil {
+		in, out := &in.RefreshPolicy, &out.RefreshPolicy
+		*out = new(RefreshPolicy)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Audiences != nil {
+		in, out := &in.Audiences, &out.Audiences
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
 	if in.NamespacedPermissions != nil {
 		in, out := &in.NamespacedPermissions, &out.NamespacedPermissions
 		*out = make([]NamespacedPermissions, len(*in))
@@ -107,6 +230,21 @@ func (in *KubeconfigSpec) DeepCopyInto(out *KubeconfigSpec) {
 		*out = new(ClusterPermissions)
 		(*in).DeepCopyInto(*out)
 	}
+	if in.RoleRefs != nil {
+		in, out := &in.RoleRefs, &out.RoleRefs
+		*out = make([]RoleRef, len(*in))
+		copy(*out, *in)
+	}
+	if in.Presets != nil {
+		in, out := &in.Presets, &out.Presets
+		*out = make([]Preset, len(*in))
+		copy(*out, *in)
+	}
+	if in.Impersonate != nil {
+		in, out := &in.Impersonate, &out.Impersonate
+		*out = new(Impersonation)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KubeconfigSpec.
@@ -133,6 +271,37 @@ func (in *KubeconfigStatus) DeepCopyInto(out *KubeconfigStatus) {
 		*out = new(string)
 		**out = **in
 	}
+	if in.CoveredNamespaces != nil {
+		in, out := &in.CoveredNamespaces, &out.CoveredNamespaces
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Presets != nil {
+		in, out := &in.Presets, &out.Presets
+		*out = make([]ExpandedPreset, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.EffectivePermissions != nil {
+		in, out := &in.EffectivePermissions, &out.EffectivePermissions
+		*out = make([]EffectivePermissions, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.ScheduledPermissions != nil {
+		in, out := &in.ScheduledPermissions, &out.ScheduledPermissions
+		*out = make([]ScheduledPermissions, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.ExpandedResources != nil {
+		in, out := &in.ExpandedResources, &out.ExpandedResources
+		*out = new(ExpandedResources)
+		(*in).DeepCopyInto(*out)
+	}
 	if in.ServiceAccountRef != nil {
 		in, out := &in.ServiceAccountRef, &out.ServiceAccountRef
 		*out = new(string)
@@ -146,10 +315,39 @@ func (in *KubeconfigStatus) DeepCopyInto(out *KubeconfigStatus) {
 		in, out := &in.ServiceAccountTokenRefreshesAt, &out.ServiceAccountTokenRefreshesAt
 		*out = (*in).DeepCopy()
 	}
+	in.PreviousTokenPublishedUntil.DeepCopyInto(&out.PreviousTokenPublishedUntil)
 	if in.ServiceAccountTokenIssuedAt != nil {
 		in, out := &in.ServiceAccountTokenIssuedAt, &out.ServiceAccountTokenIssuedAt
 		*out = (*in).DeepCopy()
 	}
+	if in.ServiceAccountTokenRequestedTTL != nil {
+		in, out := &in.ServiceAccountTokenRequestedTTL, &out.ServiceAccountTokenRequestedTTL
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.ServiceAccountTokenEffectiveTTL != nil {
+		in, out := &in.ServiceAccountTokenEffectiveTTL, &out.ServiceAccountTokenEffectiveTTL
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.ServiceAccountTokenRequestedAudiences != nil {
+		in, out := &in.ServiceAccountTokenRequestedAudiences, &out.ServiceAccountTokenRequestedAudiences
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.ServiceAccountTokenAudiences != nil {
+		in, out := &in.ServiceAccountTokenAudiences, &out.ServiceAccountTokenAudiences
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.ServiceAccountTokenRotatedAt != nil {
+		in, out := &in.ServiceAccountTokenRotatedAt, &out.ServiceAccountTokenRotatedAt
+		*out = (*in).DeepCopy()
+	}
+	if in.RevokedAt != nil {
+		in, out := &in.RevokedAt, &out.RevokedAt
+		*out = (*in).DeepCopy()
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KubeconfigStatus.
@@ -165,13 +363,31 @@ func (in *KubeconfigStatus) DeepCopy() *KubeconfigStatus {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *NamespacedPermissions) DeepCopyInto(out *NamespacedPermissions) {
 	*out = *in
+	if in.NamespaceSelector != nil {
+		in, out := &in.NamespaceSelector, &out.NamespaceSelector
+		*out = new(v1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
 	if in.Rules != nil {
 		in, out := &in.Rules, &out.Rules
-		*out = make([]v1.PolicyRule, len(*in))
+		*out = make([]rbacv1.PolicyRule, len(*in))
 		for i := range *in {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.ExcludeResources != nil {
+		in, out := &in.ExcludeResources, &out.ExcludeResources
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.NotBefore != nil {
+		in, out := &in.NotBefore, &out.NotBefore
+		*out = (*in).DeepCopy()
+	}
+	if in.ValidUntil != nil {
+		in, out := &in.ValidUntil, &out.ValidUntil
+		*out = (*in).DeepCopy()
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NamespacedPermissions.
@@ -183,3 +399,76 @@ func (in *NamespacedPermissions) DeepCopy() *NamespacedPermissions {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Preset) DeepCopyInto(out *Preset) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Preset.
+func (in *Preset) DeepCopy() *Preset {
+	if in == nil {
+		return nil
+	}
+	out := new(Preset)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RefreshPolicy) DeepCopyInto(out *RefreshPolicy) {
+	*out = *in
+	if in.LifetimePercentage != nil {
+		in, out := &in.LifetimePercentage, &out.LifetimePercentage
+		*out = new(int32)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RefreshPolicy.
+func (in *RefreshPolicy) DeepCopy() *RefreshPolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(RefreshPolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RoleRef) DeepCopyInto(out *RoleRef) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RoleRef.
+func (in *RoleRef) DeepCopy() *RoleRef {
+	if in == nil {
+		return nil
+	}
+	out := new(RoleRef)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ScheduledPermissions) DeepCopyInto(out *ScheduledPermissions) {
+	*out = *in
+	if in.NotBefore != nil {
+		in, out := &in.NotBefore, &out.NotBefore
+		*out = (*in).DeepCopy()
+	}
+	if in.ValidUntil != nil {
+		in, out := &in.ValidUntil, &out.ValidUntil
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ScheduledPermissions.
+func (in *ScheduledPermissions) DeepCopy() *ScheduledPermissions {
+	if in == nil {
+		return nil
+	}
+	out := new(ScheduledPermissions)
+	in.DeepCopyInto(out)
+	return out
+}
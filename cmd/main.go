@@ -19,14 +19,16 @@ import (
 	kubeconfig "github.com/klaudworks/kubeconfig-operator/internal/controllers/kubeconfig"
 	"github.com/klaudworks/kubeconfig-operator/internal/controlplane"
 	intscheme "github.com/klaudworks/kubeconfig-operator/internal/scheme"
+	kubeconfigwebhook "github.com/klaudworks/kubeconfig-operator/internal/webhooks/kubeconfig"
 )
 
 // opts store any optional settings that instruct how the manager and
 // controllers should run. Typically these are fed values from CLI flags or
 // environment variables.
 type opts struct {
-	bootstrap   bootstrap.Options
-	disableSync bool
+	bootstrap       bootstrap.Options
+	disableSync     bool
+	disableWebhooks bool
 }
 
 const (
@@ -68,6 +70,7 @@ func (o *opts) addToFlags(flags *pflag.FlagSet) {
 	o.bootstrap.AddToFlags(flags)
 
 	flags.BoolVar(&o.disableSync, "disable-sync", false, "run controllers in a dry-run mode (default: false)")
+	flags.BoolVar(&o.disableWebhooks, "disable-webhooks", false, "don't serve admission webhooks e.g. when running locally without serving certificates (default: false)")
 }
 
 // initStartFunc accepts options that are typically set from CLI flags or
@@ -101,6 +104,13 @@ func initStartFunc(o *opts) bootstrap.StartFunc {
 		if err := kubeconfig.SetupController(ctx, cpCtx, mgr, rl, client); err != nil {
 			return fmt.Errorf("setting up Kubeconfig controller: %w", err)
 		}
+
+		if !o.disableWebhooks {
+			log.Info("starting webhooks...")
+			if err := kubeconfigwebhook.SetupWebhook(mgr); err != nil {
+				return fmt.Errorf("setting up Kubeconfig webhook: %w", err)
+			}
+		}
 		return nil
 	}
 }
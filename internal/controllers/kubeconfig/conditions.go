@@ -1,12 +1,29 @@
 package kubeconfig
 
 import (
+	"fmt"
+	"strings"
+	"time"
+
 	"github.com/reddit/achilles-sdk-api/api"
 	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
 	"github.com/klaudworks/kubeconfig-operator/api/klaud.works/v1alpha1"
 )
 
+var conditionSpecValid = api.Condition{
+	Type:    v1alpha1.TypeSpecValid,
+	Status:  corev1.ConditionTrue,
+	Message: "Kubeconfig spec is valid.",
+}
+
+var conditionOwnershipVerified = api.Condition{
+	Type:    v1alpha1.TypeOwnershipVerified,
+	Status:  corev1.ConditionTrue,
+	Message: "Permissions of the Kubeconfig aren't owned by another Kubeconfig.",
+}
+
 var conditionServiceAccountProvisioned = api.Condition{
 	Type:    v1alpha1.TypeServiceAccountProvisioned,
 	Status:  corev1.ConditionTrue,
@@ -19,8 +36,122 @@ var conditionStalePermissionsRemoved = api.Condition{
 	Message: "Stale permissions have been removed",
 }
 
+var conditionRevocationProcessed = api.Condition{
+	Type:    v1alpha1.TypeRevocationProcessed,
+	Status:  corev1.ConditionTrue,
+	Message: "Token revocation requests have been processed.",
+}
+
 var conditionKubeconfigProvisioned = api.Condition{
 	Type:    v1alpha1.TypeKubeconfigProvisioned,
 	Status:  corev1.ConditionTrue,
 	Message: "Kubeconfig secret has been provisioned.",
 }
+
+func conditionTokenReviewed(kubeconfig *v1alpha1.Kubeconfig) api.Condition {
+	return api.Condition{
+		Type:               v1alpha1.TypeTokenReviewed,
+		Status:             corev1.ConditionTrue,
+		ObservedGeneration: kubeconfig.GetGeneration(),
+		LastTransitionTime: metav1.Now(),
+		Message:            "Existing token has been accepted by the API server.",
+	}
+}
+
+func conditionTokenLifetimeCapped(kubeconfig *v1alpha1.Kubeconfig, requested, effective time.Duration) api.Condition {
+	return api.Condition{
+		Type:               v1alpha1.TypeTokenLifetimeCapped,
+		Status:             corev1.ConditionTrue,
+		ObservedGeneration: kubeconfig.GetGeneration(),
+		LastTransitionTime: metav1.Now(),
+		Reason:             "LifetimeCapped",
+		Message:            fmt.Sprintf("The API server issued the token with a lifetime of %s instead of the requested %s.", effective, requested),
+	}
+}
+
+func conditionTokenLifetimeGranted(kubeconfig *v1alpha1.Kubeconfig) api.Condition {
+	return api.Condition{
+		Type:               v1alpha1.TypeTokenLifetimeCapped,
+		Status:             corev1.ConditionFalse,
+		ObservedGeneration: kubeconfig.GetGeneration(),
+		LastTransitionTime: metav1.Now(),
+		Reason:             "LifetimeGranted",
+		Message:            "The API server issued the token with the requested lifetime.",
+	}
+}
+
+func conditionUnknownResources(kubeconfig *v1alpha1.Kubeconfig, unknown []string) api.Condition {
+	return api.Condition{
+		Type:               v1alpha1.TypeUnknownResources,
+		Status:             corev1.ConditionTrue,
+		ObservedGeneration: kubeconfig.GetGeneration(),
+		LastTransitionTime: metav1.Now(),
+		Reason:             "UnknownResources",
+		Message:            fmt.Sprintf("Rules refer to resources the API server doesn't serve: %s.", strings.Join(unknown, ", ")),
+	}
+}
+
+func conditionResourcesKnown(kubeconfig *v1alpha1.Kubeconfig) api.Condition {
+	return api.Condition{
+		Type:               v1alpha1.TypeUnknownResources,
+		Status:             corev1.ConditionFalse,
+		ObservedGeneration: kubeconfig.GetGeneration(),
+		LastTransitionTime: metav1.Now(),
+		Reason:             "ResourcesKnown",
+		Message:            "All rules refer to resources served by the API server.",
+	}
+}
+
+func conditionNamespacesMerged(kubeconfig *v1alpha1.Kubeconfig, merged []string) api.Condition {
+	return api.Condition{
+		Type:               v1alpha1.TypeNamespacesMerged,
+		Status:             corev1.ConditionTrue,
+		ObservedGeneration: kubeconfig.GetGeneration(),
+		LastTransitionTime: metav1.Now(),
+		Reason:             "NamespacesMerged",
+		Message:            fmt.Sprintf("Multiple namespacedPermissions entries apply to namespaces %s, their rules are merged into a single Role per namespace.", strings.Join(merged, ", ")),
+	}
+}
+
+func conditionNamespacesDistinct(kubeconfig *v1alpha1.Kubeconfig) api.Condition {
+	return api.Condition{
+		Type:               v1alpha1.TypeNamespacesMerged,
+		Status:             corev1.ConditionFalse,
+		ObservedGeneration: kubeconfig.GetGeneration(),
+		LastTransitionTime: metav1.Now(),
+		Reason:             "NamespacesDistinct",
+		Message:            "Each namespace is covered by a single namespacedPermissions entry.",
+	}
+}
+
+func conditionTokenRejected(kubeconfig *v1alpha1.Kubeconfig, rejectionMessage string) api.Condition {
+	return api.Condition{
+		Type:               v1alpha1.TypeTokenReviewed,
+		Status:             corev1.ConditionFalse,
+		ObservedGeneration: kubeconfig.GetGeneration(),
+		LastTransitionTime: metav1.Now(),
+		Reason:             "TokenRejected",
+		Message:            fmt.Sprintf("Existing token was rejected by the API server and has been reissued: %s", rejectionMessage),
+	}
+}
+
+func conditionPermissionsReviewed(kubeconfig *v1alpha1.Kubeconfig) api.Condition {
+	return api.Condition{
+		Type:               v1alpha1.TypePermissionsReviewed,
+		Status:             corev1.ConditionTrue,
+		ObservedGeneration: kubeconfig.GetGeneration(),
+		LastTransitionTime: metav1.Now(),
+		Message:            "Effective permissions have been reviewed.",
+	}
+}
+
+func conditionPermissionsReviewFailed(kubeconfig *v1alpha1.Kubeconfig, err error) api.Condition {
+	return api.Condition{
+		Type:               v1alpha1.TypePermissionsReviewed,
+		Status:             corev1.ConditionFalse,
+		ObservedGeneration: kubeconfig.GetGeneration(),
+		LastTransitionTime: metav1.Now(),
+		Reason:             "ReviewFailed",
+		Message:            fmt.Sprintf("Effective permissions couldn't be reviewed and may be outdated: %s", err),
+	}
+}
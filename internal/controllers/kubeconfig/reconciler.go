@@ -2,8 +2,15 @@ package kubeconfig
 
 import (
 	"context"
+	goerrors "errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
 
+	"github.com/reddit/achilles-sdk-api/api"
 	"github.com/reddit/achilles-sdk/pkg/fsm"
+	fsmhandler "github.com/reddit/achilles-sdk/pkg/fsm/handler"
 	"github.com/reddit/achilles-sdk/pkg/fsm/types"
 	"github.com/reddit/achilles-sdk/pkg/io"
 	"github.com/reddit/achilles-sdk/pkg/logging"
@@ -15,15 +22,28 @@ import (
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached/memory"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/workqueue"
 	"k8s.io/utils/ptr"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	"github.com/klaudworks/kubeconfig-operator/api/klaud.works/v1alpha1"
 	"github.com/klaudworks/kubeconfig-operator/internal/controlplane"
 	kubeconfigbuilder "github.com/klaudworks/kubeconfig-operator/internal/kubeconfig"
+	"github.com/klaudworks/kubeconfig-operator/internal/permissions"
 	"github.com/klaudworks/kubeconfig-operator/internal/serviceaccount"
 	"github.com/klaudworks/kubeconfig-operator/internal/token"
 	"github.com/klaudworks/kubeconfig-operator/internal/util"
@@ -39,22 +59,195 @@ import (
 // +kubebuilder:rbac:groups=klaud.works,resources=kubeconfigs;kubeconfigs/status,verbs=*
 // +kubebuilder:rbac:groups="",resources=secrets,verbs=*
 // +kubebuilder:rbac:groups="",resources=serviceaccounts/token,verbs=create
+// +kubebuilder:rbac:groups=authentication.k8s.io,resources=tokenreviews,verbs=create
+// +kubebuilder:rbac:groups=certificates.k8s.io,resources=certificatesigningrequests,verbs=create;get;delete
+// +kubebuilder:rbac:groups=certificates.k8s.io,resources=certificatesigningrequests/approval,verbs=update
+// +kubebuilder:rbac:groups=certificates.k8s.io,resources=signers,resourceNames=kubernetes.io/kube-apiserver-client,verbs=approve
+// +kubebuilder:rbac:urls=/openid/v1/jwks,verbs=get
 // +kubebuilder:rbac:groups=rbac.authorization.k8s.io,resources=roles;rolebindings,verbs=*
 // +kubebuilder:rbac:groups=rbac.authorization.k8s.io,resources=clusterroles;clusterrolebindings,verbs=*
 // +kubebuilder:rbac:groups=core,resources=serviceaccounts,verbs=*
+// +kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
+// +kubebuilder:rbac:groups=core,resources=namespaces,verbs=get;list;watch
+// +kubebuilder:rbac:groups=core,resources=users;groups,verbs=impersonate
+// +kubebuilder:rbac:groups=authentication.k8s.io,resources=userextras/*,verbs=impersonate
+// +kubebuilder:rbac:groups=apiextensions.k8s.io,resources=customresourcedefinitions,verbs=get;list;watch
 
 const (
 	controllerName = "Kubeconfig"
+
+	// signingKeysPollInterval is the interval in which the signing keys of the API server are checked for rotations.
+	signingKeysPollInterval = 5 * time.Minute
+
+	// permissionsReviewRetryInterval is the interval in which a failed review of the effective permissions is retried.
+	permissionsReviewRetryInterval = time.Minute
 )
 
 type state = types.State[*v1alpha1.Kubeconfig]
 
 type reconciler struct {
-	c          *io.ClientApplicator
-	scheme     *runtime.Scheme
-	log        *zap.SugaredLogger
-	kubeClient *kubernetes.Clientset
-	caCrtData  []byte
+	c         *io.ClientApplicator
+	scheme    *runtime.Scheme
+	log       *zap.SugaredLogger
+	recorder  record.EventRecorder
+	keySet    *token.KeySet
+	providers map[v1alpha1.CredentialType]token.Provider
+	reviewer  PermissionsReviewer
+	discovery discovery.CachedDiscoveryInterface
+	caCrtData []byte
+}
+
+// provider returns the credential provider for the credential type of the kubeconfig.
+func (r *reconciler) provider(kubeconfig *v1alpha1.Kubeconfig) (token.Provider, error) {
+	credentialType := kubeconfig.Spec.CredentialType
+	if credentialType == "" {
+		credentialType = v1alpha1.CredentialTypeServiceAccountToken
+	}
+
+	provider, ok := r.providers[credentialType]
+	if !ok {
+		return nil, fmt.Errorf("unsupported credential type %q", credentialType)
+	}
+	return provider, nil
+}
+
+// validateSpec validates the durations, selectors and presets of the spec before anything is provisioned. Invalid
+// values fail the state with a reason naming the invalid field instead of failing later states with a less obvious
+// error. Rules referring to API groups or resources the API server doesn't serve e.g. due to typos are only reported by
+// the UnknownResources condition, as they may refer to CustomResourceDefinitions that are installed later.
+func (r *reconciler) validateSpec() *state {
+	return &state{
+		Name:      "validate-spec",
+		Condition: conditionSpecValid,
+		Transition: func(
+			ctx context.Context,
+			kubeconfig *v1alpha1.Kubeconfig,
+			out *types.OutputSet,
+		) (*state, types.Result) {
+			expirationSeconds, err := util.ParseExpirationTTL(kubeconfig.Spec.ExpirationTTL)
+			if err != nil {
+				return nil, types.ErrorResultWithReason(fmt.Errorf("invalid expirationTTL: %w", err), "InvalidExpirationTTL")
+			}
+			if _, err := parseRefreshPolicy(kubeconfig.Spec.RefreshPolicy, expirationSeconds); err != nil {
+				return nil, types.ErrorResultWithReason(fmt.Errorf("invalid refreshPolicy: %w", err), "InvalidRefreshPolicy")
+			}
+			if _, err := parseRotationGracePeriod(kubeconfig.Spec.RotationGracePeriod); err != nil {
+				return nil, types.ErrorResultWithReason(fmt.Errorf("invalid rotationGracePeriod: %w", err), "InvalidRotationGracePeriod")
+			}
+			for _, namespacedRole := range kubeconfig.Spec.NamespacedPermissions {
+				if namespacedRole.NamespaceSelector == nil {
+					continue
+				}
+				if _, err := metav1.LabelSelectorAsSelector(namespacedRole.NamespaceSelector); err != nil {
+					return nil, types.ErrorResultWithReason(fmt.Errorf("invalid namespaceSelector: %w", err), "InvalidNamespaceSelector")
+				}
+			}
+			if err := serviceaccount.NewBuilder(kubeconfig).ValidatePresets(); err != nil {
+				return nil, types.ErrorResultWithReason(fmt.Errorf("invalid presets: %w", err), "InvalidPresets")
+			}
+
+			resources, err := permissions.Discover(r.discovery)
+			if err != nil {
+				return nil, types.ErrorResultf("%s", err)
+			}
+			unknown := serviceaccount.NewBuilder(kubeconfig).WithAPIResources(resources).UnknownResources()
+			if len(unknown) > 0 {
+				kubeconfig.SetConditions(conditionUnknownResources(kubeconfig, unknown))
+			} else {
+				kubeconfig.SetConditions(conditionResourcesKnown(kubeconfig))
+			}
+
+			return r.verifyOwnership(), types.DoneResult()
+		},
+	}
+}
+
+// verifyOwnership checks that all existing desired permission objects are owned by the kubeconfig before anything is
+// applied. Objects are owned by the Kubeconfig referenced by their owner labels. Objects without owner labels are
+// only taken over if they were applied by a previous version of the operator or spec.adoptExisting is set. Taking over
+// any other object would silently change permissions granted by someone else, so the conflict is reported instead
+// until it's resolved.
+func (r *reconciler) verifyOwnership() *state {
+	return &state{
+		Name:      "verify-ownership",
+		Condition: conditionOwnershipVerified,
+		Transition: func(
+			ctx context.Context,
+			kubeconfig *v1alpha1.Kubeconfig,
+			out *types.OutputSet,
+		) (*state, types.Result) {
+			namespaces, err := r.selectableNamespaces(ctx, kubeconfig)
+			if err != nil {
+				return nil, types.ErrorResultf("%s", err)
+			}
+			builder := serviceaccount.NewBuilder(kubeconfig).WithNamespaces(namespaces)
+
+			var conflicts []string
+			for _, desired := range builder.Build() {
+				actual := desired.DeepCopyObject().(client.Object)
+				if err := r.c.Get(ctx, client.ObjectKeyFromObject(desired), actual); err != nil {
+					if errors.IsNotFound(err) {
+						continue
+					}
+					return nil, types.ErrorResultf("getting %T %s: %s", desired, client.ObjectKeyFromObject(desired), err)
+				}
+
+				if builder.Owns(actual) {
+					continue
+				}
+				gvk, err := apiutil.GVKForObject(actual, r.scheme)
+				if err != nil {
+					return nil, types.ErrorResultf("getting kind of %T: %s", actual, err)
+				}
+
+				ownerNamespace := actual.GetLabels()[serviceaccount.LabelOwnerNamespace]
+				ownerName := actual.GetLabels()[serviceaccount.LabelOwnerName]
+				switch {
+				case ownerNamespace != "" || ownerName != "":
+					conflicts = append(conflicts, fmt.Sprintf("%s %s is owned by Kubeconfig %s/%s",
+						gvk.Kind, client.ObjectKeyFromObject(actual), ownerNamespace, ownerName))
+				case managedResource(kubeconfig, gvk, actual):
+					// the owner labels are added when the object is applied
+				case kubeconfig.Spec.AdoptExisting && gvk.Kind != rbacv1.ServiceAccountKind:
+					// service accounts are never adopted, otherwise the Kubeconfig would issue tokens for a service
+					// account used elsewhere and delete it on revocation
+				default:
+					conflicts = append(conflicts, fmt.Sprintf("%s %s wasn't created by the operator",
+						gvk.Kind, client.ObjectKeyFromObject(actual)))
+				}
+			}
+
+			if len(conflicts) > 0 {
+				return nil, types.ErrorResultWithReason(
+					fmt.Errorf("refusing to take over permissions: %s", strings.Join(conflicts, ", ")),
+					"OwnershipConflict",
+				)
+			}
+			return r.provisionServiceAccount(), types.DoneResult()
+		},
+	}
+}
+
+// managedResource reports whether the object is listed in the resource references of the kubeconfig. Objects applied
+// by previous versions of the operator don't carry owner labels and are only known this way.
+func managedResource(kubeconfig *v1alpha1.Kubeconfig, gvk schema.GroupVersionKind, obj client.Object) bool {
+	return slices.ContainsFunc(kubeconfig.Status.ResourceRefs, func(ref api.TypedObjectRef) bool {
+		return ref.GroupVersionKind() == gvk && ref.ObjectKey() == client.ObjectKeyFromObject(obj)
+	})
+}
+
+// selectableNamespaces returns the namespaces the namespace selectors of the kubeconfig are matched against. No
+// namespaces are listed if the kubeconfig doesn't use selectors.
+func (r *reconciler) selectableNamespaces(ctx context.Context, kubeconfig *v1alpha1.Kubeconfig) ([]corev1.Namespace, error) {
+	if !serviceaccount.NewBuilder(kubeconfig).UsesNamespaceSelectors() {
+		return nil, nil
+	}
+
+	namespaces := &corev1.NamespaceList{}
+	if err := r.c.List(ctx, namespaces); err != nil {
+		return nil, fmt.Errorf("failed to list namespaces: %w", err)
+	}
+	return namespaces.Items, nil
 }
 
 func (r *reconciler) provisionServiceAccount() *state {
@@ -66,7 +259,18 @@ func (r *reconciler) provisionServiceAccount() *state {
 			kubeconfig *v1alpha1.Kubeconfig,
 			out *types.OutputSet,
 		) (*state, types.Result) {
-			builder := serviceaccount.NewBuilder(kubeconfig)
+			namespaces, err := r.selectableNamespaces(ctx, kubeconfig)
+			if err != nil {
+				return nil, types.ErrorResultf("%s", err)
+			}
+			resources, err := permissions.Discover(r.discovery)
+			if err != nil {
+				return nil, types.ErrorResultf("%s", err)
+			}
+			builder := serviceaccount.NewBuilder(kubeconfig).WithNamespaces(namespaces).WithAPIResources(resources)
+			if err := builder.ValidateExclusions(); err != nil {
+				return nil, types.ErrorResultf("failed to expand excludeResources: %s", err)
+			}
 
 			outputs := builder.Build()
 			for _, o := range outputs {
@@ -87,7 +291,19 @@ func (r *reconciler) provisionServiceAccount() *state {
 				out.Apply(o, applyOpts...)
 			}
 
-			kubeconfig.Status.ServiceAccountRef = ptr.To(builder.ServiceAccount().Name)
+			kubeconfig.Status.ServiceAccountRef = nil
+			if builder.ServiceAccountRequired() {
+				kubeconfig.Status.ServiceAccountRef = ptr.To(builder.ServiceAccount().Name)
+			}
+			kubeconfig.Status.CoveredNamespaces = builder.CoveredNamespaces()
+			if merged := builder.MergedNamespaces(); len(merged) > 0 {
+				kubeconfig.SetConditions(conditionNamespacesMerged(kubeconfig, merged))
+			} else {
+				kubeconfig.SetConditions(conditionNamespacesDistinct(kubeconfig))
+			}
+			kubeconfig.Status.Presets = builder.ExpandedPresets()
+			kubeconfig.Status.ExpandedResources = builder.ExpandedResources()
+			kubeconfig.Status.ScheduledPermissions = builder.ScheduledPermissions()
 			return r.deleteStalePermissions(outputs), types.DoneResult()
 		},
 	}
@@ -102,6 +318,7 @@ func (r *reconciler) deleteStalePermissions(desiredObjs []client.Object) *state
 			kubeconfig *v1alpha1.Kubeconfig,
 			out *types.OutputSet,
 		) (*state, types.Result) {
+			builder := serviceaccount.NewBuilder(kubeconfig)
 			desired := sets.NewObjectSet(r.scheme, desiredObjs...)
 			actual := sets.NewObjectSet(r.scheme)
 
@@ -129,6 +346,11 @@ func (r *reconciler) deleteStalePermissions(desiredObjs []client.Object) *state
 				if obj.GetLabels()["kubeconfig-operator/type"] != "permission" {
 					continue
 				}
+				// never delete objects owned by another kubeconfig
+				if _, labelled := obj.GetLabels()[serviceaccount.LabelOwnerName]; labelled && !builder.Owns(obj) {
+					r.log.Warnf("skipping deletion of %T %s owned by another kubeconfig", obj, client.ObjectKeyFromObject(obj))
+					continue
+				}
 
 				actual.Insert(obj)
 			}
@@ -141,6 +363,108 @@ func (r *reconciler) deleteStalePermissions(desiredObjs []client.Object) *state
 			if kubeconfig.DeletionTimestamp != nil {
 				return nil, types.DoneResult()
 			}
+			return r.reviewPermissions(), types.DoneResult()
+		},
+	}
+}
+
+// reviewPermissions lists what the credential of the kubeconfig is allowed to do, including permissions granted by
+// other bindings e.g. to the system:serviceaccounts group. Kubeconfigs impersonating another identity act as that
+// identity for every request, so its permissions are reviewed instead. The review runs after the permissions have been
+// applied.
+// Changes to the status trigger another reconcile, so a review racing the authorizer cache is corrected right away.
+// A failed review is reported by the PermissionsReviewed condition and retried later without blocking the credential.
+func (r *reconciler) reviewPermissions() *state {
+	return &state{
+		Name: "review-permissions",
+		Transition: func(
+			ctx context.Context,
+			kubeconfig *v1alpha1.Kubeconfig,
+			out *types.OutputSet,
+		) (*state, types.Result) {
+			namespaces, err := r.selectableNamespaces(ctx, kubeconfig)
+			if err != nil {
+				return nil, types.ErrorResultf("%s", err)
+			}
+			builder := serviceaccount.NewBuilder(kubeconfig).WithNamespaces(namespaces)
+
+			identity := rest.ImpersonationConfig{
+				UserName: builder.User(),
+				Groups:   builder.UserGroups(),
+			}
+			if impersonate := kubeconfig.Spec.Impersonate; impersonate != nil {
+				identity = rest.ImpersonationConfig{
+					UserName: impersonate.User,
+					Groups:   impersonate.Groups,
+					Extra:    impersonate.Extra,
+				}
+			}
+
+			effectivePermissions, err := r.reviewer.Review(ctx, identity, builder.GrantedNamespaces())
+			if err != nil {
+				// the review is informational, so it mustn't block revoking and issuing credentials
+				err = fmt.Errorf("failed to review permissions of %s: %w", identity.UserName, err)
+				r.log.Warnf("%s", err)
+				kubeconfig.SetConditions(conditionPermissionsReviewFailed(kubeconfig, err))
+				return r.revokeTokens(), types.DoneResult()
+			}
+			kubeconfig.Status.EffectivePermissions = effectivePermissions
+			kubeconfig.SetConditions(conditionPermissionsReviewed(kubeconfig))
+			return r.revokeTokens(), types.DoneResult()
+		},
+	}
+}
+
+func (r *reconciler) revokeTokens() *state {
+	return &state{
+		Name:      "revoke-tokens",
+		Condition: conditionRevocationProcessed,
+		Transition: func(
+			ctx context.Context,
+			kubeconfig *v1alpha1.Kubeconfig,
+			out *types.OutputSet,
+		) (*state, types.Result) {
+			if kubeconfig.Spec.RevocationGeneration == kubeconfig.Status.RevocationGeneration {
+				return r.provisionKubeconfig(), types.DoneResult()
+			}
+
+			// nothing to revoke if no kubeconfig has been issued yet
+			if kubeconfig.Status.KubeconfigSecretRef == nil {
+				kubeconfig.Status.RevocationGeneration = kubeconfig.Spec.RevocationGeneration
+				return r.provisionKubeconfig(), types.DoneResult()
+			}
+
+			provider, err := r.provider(kubeconfig)
+			if err != nil {
+				return nil, types.ErrorResultf("%s", err)
+			}
+
+			// Recreating the service account assigns a new UID. The API server rejects all tokens issued for the
+			// previous UID and the kubeconfig is reissued because the UID of the existing token doesn't match anymore.
+			// Client certificates can't be revoked but the role bindings already refer to a new user and the
+			// kubeconfig is reissued because the subject of the existing certificate doesn't match anymore.
+			builder := serviceaccount.NewBuilder(kubeconfig)
+			revokeConfig := token.EnsureConfig{User: builder.User()}
+			if builder.ServiceAccountRequired() {
+				sa := &corev1.ServiceAccount{}
+				if err := r.c.Get(ctx, client.ObjectKeyFromObject(builder.ServiceAccount()), sa); err != nil {
+					return nil, types.ErrorResultf("failed to get service account %s: %v", client.ObjectKeyFromObject(builder.ServiceAccount()), err)
+				}
+				revokeConfig.ServiceAccount = sa
+			}
+			if err := provider.Revoke(ctx, revokeConfig); err != nil {
+				return nil, types.ErrorResultf("failed to revoke tokens: %v", err)
+			}
+			for _, o := range builder.Build() {
+				if _, ok := o.(*corev1.ServiceAccount); ok {
+					out.Apply(o)
+				}
+			}
+
+			r.log.Infof("revoked credentials of kubeconfig %s", client.ObjectKeyFromObject(kubeconfig))
+			kubeconfig.Status.RevocationGeneration = kubeconfig.Spec.RevocationGeneration
+			kubeconfig.Status.RevokedAt = ptr.To(metav1.Now())
+
 			return r.provisionKubeconfig(), types.DoneResult()
 		},
 	}
@@ -157,16 +481,22 @@ func (r *reconciler) provisionKubeconfig() *state {
 		) (*state, types.Result) {
 
 			namespace := kubeconfig.GetNamespace()
+			builder := serviceaccount.NewBuilder(kubeconfig)
 
-			if kubeconfig.Status.ServiceAccountRef == nil {
-				return nil, types.ErrorResultf("missing service account reference in status")
-			}
-			saName := *kubeconfig.Status.ServiceAccountRef
+			// client certificates are issued for a user, the user in the kubeconfig is named after the kubeconfig
+			saName := kubeconfig.GetName()
+			var sa *corev1.ServiceAccount
+			if builder.ServiceAccountRequired() {
+				if kubeconfig.Status.ServiceAccountRef == nil {
+					return nil, types.ErrorResultf("missing service account reference in status")
+				}
+				saName = *kubeconfig.Status.ServiceAccountRef
 
-			// Retrieve the ServiceAccount.
-			sa := &corev1.ServiceAccount{}
-			if err := r.c.Get(ctx, client.ObjectKey{Namespace: namespace, Name: saName}, sa); err != nil {
-				return nil, types.ErrorResultf("failed to get service account %s/%s: %v", namespace, saName, err)
+				// Retrieve the ServiceAccount.
+				sa = &corev1.ServiceAccount{}
+				if err := r.c.Get(ctx, client.ObjectKey{Namespace: namespace, Name: saName}, sa); err != nil {
+					return nil, types.ErrorResultf("failed to get service account %s/%s: %v", namespace, saName, err)
+				}
 			}
 
 			var existingSecret *corev1.Secret
@@ -181,45 +511,444 @@ func (r *reconciler) provisionKubeconfig() *state {
 				}
 			}
 
+			provider, err := r.provider(kubeconfig)
+			if err != nil {
+				return nil, types.ErrorResultf("%s", err)
+			}
+
+			// NOTE: the spec has been validated by the validate-spec state
 			expirationSeconds, err := util.ParseExpirationTTL(kubeconfig.Spec.ExpirationTTL)
+			if err != nil {
+				return nil, types.ErrorResultf("invalid expirationTTL: %v", err)
+			}
+			refreshPolicy, err := parseRefreshPolicy(kubeconfig.Spec.RefreshPolicy, expirationSeconds)
+			if err != nil {
+				return nil, types.ErrorResultf("invalid refreshPolicy: %v", err)
+			}
+			var gracePeriod time.Duration
+			if !kubeconfig.Spec.BindTokenToSecret && builder.ServiceAccountRequired() {
+				if gracePeriod, err = parseRotationGracePeriod(kubeconfig.Spec.RotationGracePeriod); err != nil {
+					return nil, types.ErrorResultf("invalid rotationGracePeriod: %v", err)
+				}
+			}
 			existingToken := ""
 			if existingSecret != nil {
-				existingToken = string(existingSecret.Data["token"])
+				existingToken = kubeconfigbuilder.Credential(existingSecret, kubeconfig.Spec.CredentialType)
+			}
+			var existingExpirationSeconds int64
+			if requestedTTL := kubeconfig.Status.ServiceAccountTokenRequestedTTL; requestedTTL != nil {
+				existingExpirationSeconds = int64(requestedTTL.Seconds())
+			}
+			rotationRequest := kubeconfig.GetAnnotations()[v1alpha1.AnnotationRotateRequestedAt]
+			rotationRequested := rotationRequest != "" && rotationRequest != kubeconfig.Status.RotationRequestHandled
+			tokenConfig := token.EnsureConfig{
+				ServiceAccount:            sa,
+				User:                      builder.User(),
+				Groups:                    builder.Groups(),
+				ExpirationSeconds:         expirationSeconds,
+				ExistingExpirationSeconds: existingExpirationSeconds,
+				RefreshPolicy:             refreshPolicy,
+				ForceRotation:             rotationRequested,
+				Audiences:                 kubeconfig.Spec.Audiences,
+				ExistingTokenAudiences:    kubeconfig.Status.ServiceAccountTokenRequestedAudiences,
+				BindToSecret:              kubeconfig.Spec.BindTokenToSecret,
+				BoundSecret:               existingSecret,
+			}
+
+			tokenInfo, err := provider.Ensure(ctx, existingToken, tokenConfig)
+			if tokenInfo != nil && builder.ServiceAccountRequired() {
+				if previous := tokenInfo.Previous; previous != nil && previous.RejectionMessage != "" {
+					r.log.Warnf("existing token of service account %s/%s was rejected: %s", namespace, saName, previous.RejectionMessage)
+					kubeconfig.SetConditions(conditionTokenRejected(kubeconfig, previous.RejectionMessage))
+				} else if tokenInfo.RotationReason == "" {
+					kubeconfig.SetConditions(conditionTokenReviewed(kubeconfig))
+				}
+			}
+			if goerrors.Is(err, token.ErrBoundSecretRequired) {
+				// the existing token is gone once the secret was recreated, so remember why it's replaced
+				if reason := tokenInfo.RotationReason; reason != "" && reason != token.RotationReasonNoToken {
+					kubeconfig.Status.ServiceAccountTokenPendingRotationReason = string(reason)
+				}
+				return nil, r.prepareBoundSecret(ctx, kubeconfig, existingSecret)
+			}
+			if goerrors.Is(err, token.ErrCredentialPending) {
+				return nil, types.RequeueResultWithBackoff("waiting for the requested credential to be issued")
 			}
-			tokenInfo, err := token.EnsureToken(ctx, r.kubeClient, existingToken, expirationSeconds, saName, namespace)
 			if err != nil {
-				return nil, types.ErrorResultf("failed to parse expirationTTL: %v", err)
+				return nil, types.ErrorResultf("failed to ensure token: %v", err)
 			}
 
-			kubeconfigSecret, err := kubeconfigbuilder.Build(kubeconfigbuilder.BuildConfig{
+			previousToken, previousTokenPublishedUntil := previousTokenToPublish(ctx, provider, existingSecret, tokenInfo, gracePeriod)
+
+			buildConfig := kubeconfigbuilder.BuildConfig{
 				Kubeconfig:         kubeconfig,
 				Namespace:          namespace,
 				ServiceAccountName: saName,
 				Token:              tokenInfo.Token,
+				PreviousToken:      previousToken,
 				CACrtData:          r.caCrtData,
-			})
+			}
+			if len(tokenInfo.ClientCertificate) > 0 {
+				buildConfig.Token = ""
+				buildConfig.ClientCertificate = tokenInfo.ClientCertificate
+				buildConfig.ClientKey = tokenInfo.ClientKey
+			}
+			kubeconfigSecret, err := kubeconfigbuilder.Build(buildConfig)
 			if err != nil {
 				return nil, types.ErrorResultf("failed to build kubeconfig secret: %v", err)
 			}
+			if existingSecret != nil {
+				// remove keys that are no longer published e.g. the previous token after the grace period or the token
+				// after switching to client certificates
+				for key := range existingSecret.Data {
+					if _, ok := kubeconfigSecret.Data[key]; !ok {
+						// must explicitly signal deletion when using JSON merge semantics
+						kubeconfigSecret.Data[key] = nil
+					}
+				}
+			}
 
 			out.Apply(kubeconfigSecret)
 
+			// NOTE: the refresh time is based on the lifetime of the issued token which may be shorter than requested
+			refreshesAt := tokenInfo.RefreshTime(refreshPolicy)
+
 			kubeconfig.Status.KubeconfigSecretRef = ptr.To(kubeconfigSecret.GetName())
+			kubeconfig.Status.User = builder.User()
 			kubeconfig.Status.ServiceAccountTokenIssuedAt = ptr.To(metav1.NewTime(tokenInfo.IssuedAt))
 			kubeconfig.Status.ServiceAccountTokenExpiresAt = ptr.To(metav1.NewTime(tokenInfo.ExpiresAt))
-			kubeconfig.Status.ServiceAccountTokenRefreshesAt = ptr.To(metav1.NewTime(tokenInfo.RefreshTime()))
+			kubeconfig.Status.ServiceAccountTokenRefreshesAt = ptr.To(metav1.NewTime(refreshesAt))
+			kubeconfig.Status.PreviousTokenPublishedUntil = metav1.NewTime(previousTokenPublishedUntil)
+			kubeconfig.Status.ServiceAccountTokenAudiences = tokenInfo.Audiences
+			kubeconfig.Status.ServiceAccountTokenKeyID = tokenInfo.KeyID
+			if rotationReason := tokenInfo.RotationReason; rotationReason != "" {
+				if pending := kubeconfig.Status.ServiceAccountTokenPendingRotationReason; pending != "" && rotationReason == token.RotationReasonNoToken {
+					// the operator deleted the secret holding the replaced token to bind the new token to its next version
+					rotationReason = token.RotationReason(pending)
+				}
+				r.log.Infof("issued new credential for %s: %s", builder.User(), rotationReason)
+				kubeconfig.Status.ServiceAccountTokenRotationReason = string(rotationReason)
+				kubeconfig.Status.ServiceAccountTokenPendingRotationReason = ""
+				kubeconfig.Status.ServiceAccountTokenRequestedAudiences = kubeconfig.Spec.Audiences
+				kubeconfig.Status.ServiceAccountTokenRequestedTTL = &metav1.Duration{Duration: time.Duration(expirationSeconds) * time.Second}
+				if rotationReason != token.RotationReasonNoToken {
+					kubeconfig.Status.ServiceAccountTokenRotatedAt = ptr.To(metav1.NewTime(tokenInfo.IssuedAt))
+				}
+			}
+			if rotationRequested {
+				kubeconfig.Status.RotationRequestHandled = rotationRequest
+			}
+			r.reportTokenLifetime(kubeconfig, tokenInfo)
 
-			return nil, types.DoneResult()
+			// NOTE: requeue exactly at the refresh time instead of relying on periodic resyncs. After an operator restart
+			// every Kubeconfig is reconciled once which reschedules the refresh based on the token stored in the secret.
+			// Scheduled permissions are granted and removed by the reconcile at their boundary, the token stays the same.
+			requeueAt, requeueReason := refreshesAt, "waiting for token refresh"
+			if !previousTokenPublishedUntil.IsZero() && previousTokenPublishedUntil.Before(requeueAt) {
+				requeueAt, requeueReason = previousTokenPublishedUntil, "waiting for rotation grace period"
+			}
+			if change := builder.NextPermissionsChange(); !change.IsZero() && change.Before(requeueAt) {
+				requeueAt, requeueReason = change, "waiting for scheduled permissions"
+			}
+			if reviewed := kubeconfig.GetCondition(v1alpha1.TypePermissionsReviewed); reviewed.Status == corev1.ConditionFalse {
+				if retryAt := time.Now().Add(permissionsReviewRetryInterval); retryAt.Before(requeueAt) {
+					requeueAt, requeueReason = retryAt, "retrying the review of the effective permissions"
+				}
+			}
+			return nil, types.DoneAndRequeueResult(requeueReason, requeueDelay(requeueAt))
 		},
 	}
 }
 
+// reportTokenLifetime records the requested and effective lifetime of the token in the status and reports via a
+// condition whether the API server capped the lifetime. An event is emitted when a capped token is issued.
+func (r *reconciler) reportTokenLifetime(kubeconfig *v1alpha1.Kubeconfig, tokenInfo *token.TokenInfo) {
+	effective := tokenInfo.Lifetime()
+	kubeconfig.Status.ServiceAccountTokenEffectiveTTL = &metav1.Duration{Duration: effective}
+
+	requestedTTL := kubeconfig.Status.ServiceAccountTokenRequestedTTL
+	if requestedTTL == nil || effective >= requestedTTL.Duration {
+		kubeconfig.SetConditions(conditionTokenLifetimeGranted(kubeconfig))
+		return
+	}
+
+	kubeconfig.SetConditions(conditionTokenLifetimeCapped(kubeconfig, requestedTTL.Duration, effective))
+	if tokenInfo.RotationReason != "" {
+		r.recorder.Eventf(kubeconfig, corev1.EventTypeWarning, "TokenLifetimeCapped",
+			"The API server issued the token with a lifetime of %s instead of the requested %s", effective, requestedTTL.Duration)
+	}
+}
+
+// previousTokenToPublish returns the token that is published next to the current token and until when it is published.
+// On rotation, the replaced token is kept unless it was rotated because it became invalid. Otherwise, the previous token
+// stored in the secret is kept. It is published until the grace period after the current token was issued ends or the
+// previous token expires. The deadline is derived from the tokens so it doesn't depend on an up-to-date status.
+// It returns an empty token and a zero time if no previous token should be published.
+func previousTokenToPublish(
+	ctx context.Context,
+	provider token.Provider,
+	existingSecret *corev1.Secret,
+	tokenInfo *token.TokenInfo,
+	gracePeriod time.Duration,
+) (string, time.Time) {
+	if gracePeriod <= 0 {
+		return "", time.Time{}
+	}
+
+	var previous *token.TokenInfo
+	switch tokenInfo.RotationReason {
+	case "":
+		previousToken := string(existingSecret.Data[kubeconfigbuilder.PreviousTokenKey])
+		if previousToken == "" {
+			return "", time.Time{}
+		}
+		parsed, err := provider.Parse(ctx, previousToken)
+		if err != nil {
+			// the previous token can't be verified anymore e.g. because its signing key was rotated
+			return "", time.Time{}
+		}
+		previous = parsed
+	case token.RotationReasonRefreshDue,
+		token.RotationReasonExpirationTTLChanged,
+		token.RotationReasonAudiencesChanged,
+		token.RotationReasonRotationRequested:
+		previous = tokenInfo.Previous
+	default:
+		// the existing token is missing or rejected by the API server
+		return "", time.Time{}
+	}
+
+	until := tokenInfo.IssuedAt.Add(gracePeriod)
+	if previous.ExpiresAt.Before(until) {
+		until = previous.ExpiresAt
+	}
+	if !time.Now().Before(until) {
+		return "", time.Time{}
+	}
+	return previous.Token, until
+}
+
+// prepareBoundSecret ensures that a new version of the kubeconfig secret without a token exists before a token bound
+// to the secret is requested. The API server requires the UID of the secret, so an existing secret holding a token is
+// deleted first which also invalidates the token bound to it. The secret is written directly instead of via the output
+// set because outputs aren't applied while waiting.
+func (r *reconciler) prepareBoundSecret(
+	ctx context.Context,
+	kubeconfig *v1alpha1.Kubeconfig,
+	existingSecret *corev1.Secret,
+) types.Result {
+	if existingSecret == nil {
+		secret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      kubeconfigbuilder.SecretName(kubeconfig),
+				Namespace: kubeconfig.GetNamespace(),
+			},
+			Data: map[string][]byte{
+				"ca.crt": r.caCrtData,
+			},
+			Type: corev1.SecretTypeOpaque,
+		}
+		if err := controllerutil.SetControllerReference(kubeconfig, secret, r.scheme); err != nil {
+			return types.ErrorResultf("failed to set owner reference on kubeconfig secret: %v", err)
+		}
+		if err := r.c.Create(ctx, secret); client.IgnoreAlreadyExists(err) != nil {
+			return types.ErrorResultf("failed to create kubeconfig secret: %v", err)
+		}
+		kubeconfig.Status.KubeconfigSecretRef = ptr.To(secret.GetName())
+		return types.RequeueResultWithBackoff("waiting for kubeconfig secret to be created")
+	}
+
+	if err := r.c.Delete(ctx, existingSecret, client.Preconditions{UID: &existingSecret.UID}); client.IgnoreNotFound(err) != nil {
+		return types.ErrorResultf("failed to delete kubeconfig secret %s: %v", client.ObjectKeyFromObject(existingSecret), err)
+	}
+	return types.RequeueResultWithBackoff("waiting for kubeconfig secret to be recreated")
+}
+
+// parseRefreshPolicy converts the refresh policy of the spec into a token.RefreshPolicy.
+func parseRefreshPolicy(spec *v1alpha1.RefreshPolicy, expirationSeconds int64) (token.RefreshPolicy, error) {
+	policy := token.DefaultRefreshPolicy
+	if spec == nil {
+		return policy, nil
+	}
+
+	if spec.LifetimePercentage != nil {
+		policy.LifetimeFraction = float64(*spec.LifetimePercentage) / 100
+	}
+	if spec.RefreshBefore != "" {
+		refreshBefore, err := util.ParseDuration(spec.RefreshBefore)
+		if err != nil {
+			return policy, fmt.Errorf("invalid refreshBefore: %w", err)
+		}
+		if refreshBefore <= 0 || refreshBefore >= time.Duration(expirationSeconds)*time.Second {
+			return policy, fmt.Errorf("refreshBefore %q must be positive and shorter than the expirationTTL", spec.RefreshBefore)
+		}
+		policy.RefreshBefore = refreshBefore
+	}
+	if spec.Jitter != "" {
+		jitter, err := util.ParseDuration(spec.Jitter)
+		if err != nil {
+			return policy, fmt.Errorf("invalid jitter: %w", err)
+		}
+		// a jitter exceeding the time until the refresh would schedule the refresh before the token was issued
+		ttl := time.Duration(expirationSeconds) * time.Second
+		refreshAfter := time.Duration(float64(ttl) * policy.LifetimeFraction)
+		if policy.RefreshBefore > 0 {
+			refreshAfter = ttl - policy.RefreshBefore
+		}
+		if jitter < 0 || jitter >= refreshAfter {
+			return policy, fmt.Errorf("jitter %q must not be negative and shorter than the time until the token is refreshed", spec.Jitter)
+		}
+		policy.Jitter = jitter
+	}
+
+	return policy, nil
+}
+
+// parseRotationGracePeriod parses the rotation grace period of the spec. An empty value disables the grace period.
+func parseRotationGracePeriod(gracePeriod string) (time.Duration, error) {
+	if gracePeriod == "" {
+		return 0, nil
+	}
+	return util.ParseDuration(gracePeriod)
+}
+
+// requeueDelay returns the duration until t. A minimum delay of one second is enforced because the FSM treats a zero
+// delay as "no requeue".
+func requeueDelay(t time.Time) time.Duration {
+	delay := time.Until(t)
+	if delay < time.Second {
+		return time.Second
+	}
+	return delay
+}
+
+// kubeconfigsForNamespace enqueues the Kubeconfigs that select namespaces by labels whenever a namespace changes so
+// their permissions follow namespaces that start or stop matching.
+func (r *reconciler) kubeconfigsForNamespace(c client.Client) handler.MapFunc {
+	return func(ctx context.Context, _ client.Object) []reconcile.Request {
+		kubeconfigs := &v1alpha1.KubeconfigList{}
+		if err := c.List(ctx, kubeconfigs); err != nil {
+			r.log.Warnf("failed to list kubeconfigs for namespace event: %v", err)
+			return nil
+		}
+
+		var requests []reconcile.Request
+		for i := range kubeconfigs.Items {
+			kubeconfig := &kubeconfigs.Items[i]
+			if serviceaccount.NewBuilder(kubeconfig).UsesNamespaceSelectors() {
+				requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(kubeconfig)})
+			}
+		}
+		return requests
+	}
+}
+
+// kubeconfigsForCustomResourceDefinition enqueues the Kubeconfigs whose permissions depend on the served API resources
+// whenever a CustomResourceDefinition changes. The cached API resources are invalidated first, so wildcards of
+// permissions including new resources are expanded into the resources of new CRDs, resources of deleted CRDs are
+// removed and rules referring to them are no longer reported as unknown.
+func (r *reconciler) kubeconfigsForCustomResourceDefinition(c client.Client) handler.MapFunc {
+	return func(ctx context.Context, _ client.Object) []reconcile.Request {
+		r.discovery.Invalidate()
+
+		kubeconfigs := &v1alpha1.KubeconfigList{}
+		if err := c.List(ctx, kubeconfigs); err != nil {
+			r.log.Warnf("failed to list kubeconfigs for custom resource definition event: %v", err)
+			return nil
+		}
+
+		var requests []reconcile.Request
+		for i := range kubeconfigs.Items {
+			kubeconfig := &kubeconfigs.Items[i]
+			if serviceaccount.NewBuilder(kubeconfig).UsesExcludeResources() ||
+				kubeconfig.GetCondition(v1alpha1.TypeUnknownResources).Status == corev1.ConditionTrue {
+				requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(kubeconfig)})
+			}
+		}
+		return requests
+	}
+}
+
+// watchSigningKeys polls the signing keys of the API server until the context is done. Once a key is removed, the
+// Kubeconfigs holding tokens signed by that key are sent to the given channel to reissue them before they are used.
+func (r *reconciler) watchSigningKeys(ctx context.Context, c client.Client, rotated chan<- event.GenericEvent) {
+	ticker := time.NewTicker(signingKeysPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		changed, err := r.keySet.Refresh(ctx)
+		if err != nil {
+			r.log.Warnf("failed to refresh signing keys: %v", err)
+			continue
+		}
+		if !changed {
+			continue
+		}
+
+		kubeconfigs := &v1alpha1.KubeconfigList{}
+		if err := c.List(ctx, kubeconfigs); err != nil {
+			r.log.Warnf("failed to list kubeconfigs after signing key rotation: %v", err)
+			continue
+		}
+
+		keyIDs := r.keySet.KeyIDs()
+		for i := range kubeconfigs.Items {
+			kubeconfig := &kubeconfigs.Items[i]
+			keyID := kubeconfig.Status.ServiceAccountTokenKeyID
+			if keyID == "" || keyIDs.Has(keyID) {
+				continue
+			}
+
+			r.log.Infof("signing key %q of kubeconfig %s was rotated", keyID, client.ObjectKeyFromObject(kubeconfig))
+			select {
+			case rotated <- event.GenericEvent{Object: kubeconfig}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// PermissionsReviewer lists the rules the identity is allowed cluster-wide and in each of the namespaces.
+type PermissionsReviewer interface {
+	Review(ctx context.Context, identity rest.ImpersonationConfig, namespaces []string) ([]v1alpha1.EffectivePermissions, error)
+}
+
+// SetupOption configures the Kubeconfig controller.
+type SetupOption func(*setupOptions)
+
+type setupOptions struct {
+	providers map[v1alpha1.CredentialType]token.Provider
+	reviewer  PermissionsReviewer
+}
+
+// WithProvider issues the credentials of the credential type via the provider instead of the built-in one.
+func WithProvider(credentialType v1alpha1.CredentialType, provider token.Provider) SetupOption {
+	return func(o *setupOptions) {
+		o.providers[credentialType] = provider
+	}
+}
+
+// WithPermissionsReviewer reviews the effective permissions via the reviewer instead of SelfSubjectRulesReviews.
+func WithPermissionsReviewer(reviewer PermissionsReviewer) SetupOption {
+	return func(o *setupOptions) {
+		o.reviewer = reviewer
+	}
+}
+
 func SetupController(
 	ctx context.Context,
 	cpCtx controlplane.Context,
 	mgr ctrl.Manager,
 	rl workqueue.RateLimiter,
 	c *io.ClientApplicator,
+	opts ...SetupOption,
 ) error {
 	_, log, err := logging.ControllerCtx(ctx, controllerName)
 	if err != nil {
@@ -237,17 +966,49 @@ func SetupController(
 		return err
 	}
 
+	keySet := token.NewKeySet(kubeClient.Discovery().RESTClient())
+	options := &setupOptions{
+		providers: map[v1alpha1.CredentialType]token.Provider{
+			v1alpha1.CredentialTypeServiceAccountToken: token.NewServiceAccountTokenProvider(kubeClient, keySet),
+			v1alpha1.CredentialTypeClientCertificate:   token.NewClientCertificateProvider(kubeClient),
+		},
+		reviewer: permissions.NewReviewer(cfg),
+	}
+	for _, opt := range opts {
+		opt(options)
+	}
+
 	r := &reconciler{
-		c:          c,
-		scheme:     mgr.GetScheme(),
-		log:        log,
-		kubeClient: kubeClient,
-		caCrtData:  caCrtData,
+		c:         c,
+		scheme:    mgr.GetScheme(),
+		log:       log,
+		recorder:  mgr.GetEventRecorderFor(controllerName),
+		keySet:    keySet,
+		providers: options.providers,
+		reviewer:  options.reviewer,
+		discovery: memory.NewMemCacheClient(kubeClient.Discovery()),
+		caCrtData: caCrtData,
 	}
 
+	signingKeysRotated := make(chan event.GenericEvent)
+	if err := mgr.Add(manager.RunnableFunc(func(ctx context.Context) error {
+		r.watchSigningKeys(ctx, mgr.GetClient(), signingKeysRotated)
+		return nil
+	})); err != nil {
+		return err
+	}
+
+	// only the metadata of CRDs is watched, changes to the served resources are read via discovery
+	customResourceDefinition := &metav1.PartialObjectMetadata{}
+	customResourceDefinition.SetGroupVersionKind(schema.GroupVersionKind{
+		Group:   "apiextensions.k8s.io",
+		Version: "v1",
+		Kind:    "CustomResourceDefinition",
+	})
+
 	builder := fsm.NewBuilder(
 		&v1alpha1.Kubeconfig{},
-		r.provisionServiceAccount(),
+		r.validateSpec(),
 		mgr.GetScheme(),
 	).Manages(
 		corev1.SchemeGroupVersion.WithKind("Secret"),
@@ -256,6 +1017,18 @@ func SetupController(
 		rbacv1.SchemeGroupVersion.WithKind("RoleBinding"),
 		rbacv1.SchemeGroupVersion.WithKind("ClusterRole"),
 		rbacv1.SchemeGroupVersion.WithKind("ClusterRoleBinding"),
+	).Watches(
+		&corev1.Namespace{},
+		handler.EnqueueRequestsFromMapFunc(r.kubeconfigsForNamespace(mgr.GetClient())),
+		fsmhandler.TriggerTypeRelative,
+	).Watches(
+		customResourceDefinition,
+		handler.EnqueueRequestsFromMapFunc(r.kubeconfigsForCustomResourceDefinition(mgr.GetClient())),
+		fsmhandler.TriggerTypeRelative,
+	).WatchesRawSource(
+		&source.Channel{Source: signingKeysRotated},
+		&handler.EnqueueRequestForObject{},
+		fsmhandler.TriggerTypeSelf,
 	).WithFinalizerState(
 		// NOTE: we can't rely on native Kubernetes GC to delete cluster scoped resources (ClusterRole, ClusterRoleBinding)
 		// or cross-namespace resources (Roles, RoleBindings) so we need to handle this ourselves
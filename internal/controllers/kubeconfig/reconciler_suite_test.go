@@ -2,6 +2,7 @@ package kubeconfig_test
 
 import (
 	"context"
+	"sync"
 	"testing"
 	"time"
 
@@ -16,15 +17,20 @@ import (
 	sdktest "github.com/reddit/achilles-sdk/pkg/test"
 	"go.uber.org/zap"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
 	ctrlzap "sigs.k8s.io/controller-runtime/pkg/log/zap"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 
+	"github.com/klaudworks/kubeconfig-operator/api/klaud.works/v1alpha1"
 	"github.com/klaudworks/kubeconfig-operator/internal/controllers/kubeconfig"
 	"github.com/klaudworks/kubeconfig-operator/internal/controlplane"
+	"github.com/klaudworks/kubeconfig-operator/internal/permissions"
 	intscheme "github.com/klaudworks/kubeconfig-operator/internal/scheme"
 	"github.com/klaudworks/kubeconfig-operator/internal/test"
+	"github.com/klaudworks/kubeconfig-operator/internal/token"
 )
 
 var (
@@ -33,8 +39,61 @@ var (
 	c       client.Client
 	scheme  *runtime.Scheme
 	log     *zap.SugaredLogger
+
+	// tokenProvider issues the service account tokens of the controller being tested
+	tokenProvider *failingProvider
+	// permissionsReviewer reviews the effective permissions for the controller being tested
+	permissionsReviewer *failingReviewer
 )
 
+// failures holds errors injected per user to let the wrapped dependencies of the controller fail.
+type failures struct {
+	errs sync.Map
+}
+
+// Fail lets every call for the user fail with the error until it's reset with a nil error.
+func (f *failures) Fail(user string, err error) {
+	if err == nil {
+		f.errs.Delete(user)
+		return
+	}
+	f.errs.Store(user, err)
+}
+
+// err returns the error injected for the user, nil if calls for the user succeed.
+func (f *failures) err(user string) error {
+	if err, ok := f.errs.Load(user); ok {
+		return err.(error)
+	}
+	return nil
+}
+
+// failingProvider issues credentials via the wrapped provider unless a failure is set for the requesting user.
+type failingProvider struct {
+	token.Provider
+	failures
+}
+
+func (p *failingProvider) Ensure(ctx context.Context, existingToken string, config token.EnsureConfig) (*token.TokenInfo, error) {
+	if err := p.err(config.User); err != nil {
+		return nil, err
+	}
+	return p.Provider.Ensure(ctx, existingToken, config)
+}
+
+// failingReviewer reviews permissions via the wrapped reviewer unless a failure is set for the reviewed user.
+type failingReviewer struct {
+	kubeconfig.PermissionsReviewer
+	failures
+}
+
+func (r *failingReviewer) Review(ctx context.Context, identity rest.ImpersonationConfig, namespaces []string) ([]v1alpha1.EffectivePermissions, error) {
+	if err := r.err(identity.UserName); err != nil {
+		return nil, err
+	}
+	return r.PermissionsReviewer.Review(ctx, identity, namespaces)
+}
+
 func TestKubeconfig(t *testing.T) {
 	RegisterFailHandler(Fail)
 	ctrllog.SetLogger(ctrlzap.New(ctrlzap.WriteTo(GinkgoWriter), ctrlzap.UseDevMode(true)))
@@ -70,7 +129,19 @@ var _ = BeforeSuite(func() {
 					Metrics: metrics.MustMakeMetrics(scheme, prometheus.NewRegistry()),
 				}
 
-				return kubeconfig.SetupController(ctx, cpCtx, mgr, rl, clientApplicator)
+				kubeClient, err := kubernetes.NewForConfig(mgr.GetConfig())
+				if err != nil {
+					return err
+				}
+				tokenProvider = &failingProvider{
+					Provider: token.NewServiceAccountTokenProvider(kubeClient, token.NewKeySet(kubeClient.Discovery().RESTClient())),
+				}
+
+				permissionsReviewer = &failingReviewer{PermissionsReviewer: permissions.NewReviewer(mgr.GetConfig())}
+
+				return kubeconfig.SetupController(ctx, cpCtx, mgr, rl, clientApplicator,
+					kubeconfig.WithProvider(v1alpha1.CredentialTypeServiceAccountToken, tokenProvider),
+					kubeconfig.WithPermissionsReviewer(permissionsReviewer))
 			},
 		).
 		WithKubeConfigFile("./").
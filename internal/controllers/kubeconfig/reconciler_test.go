@@ -2,21 +2,33 @@ package kubeconfig_test
 
 import (
 	"context"
-	"fmt"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	goerrors "errors"
+	"slices"
 	"time"
 
+	"github.com/golang-jwt/jwt/v5"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	"github.com/reddit/achilles-sdk-api/api"
+	authenticationv1 "k8s.io/api/authentication/v1"
 	corev1 "k8s.io/api/core/v1"
 	rbacv1 "k8s.io/api/rbac/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
 	"k8s.io/utils/ptr"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 
 	"github.com/klaudworks/kubeconfig-operator/api/klaud.works/v1alpha1"
+	"github.com/klaudworks/kubeconfig-operator/internal/serviceaccount"
+	"github.com/klaudworks/kubeconfig-operator/internal/test"
 )
 
 var _ = Describe("KubeconfigReconciler", Ordered, func() {
@@ -81,6 +93,7 @@ var _ = Describe("KubeconfigReconciler", Ordered, func() {
 	})
 
 	It("should reconcile Kubeconfig objects", func() {
+		roleName := serviceaccount.NewBuilder(kubeconfig).ObjectName()
 
 		By("provisioning resources required for the kubeconfig")
 
@@ -100,7 +113,7 @@ var _ = Describe("KubeconfigReconciler", Ordered, func() {
 		Eventually(func(g Gomega) {
 			expectedRole := &rbacv1.Role{
 				ObjectMeta: metav1.ObjectMeta{
-					Name:      kubeconfig.Name,
+					Name:      roleName,
 					Namespace: "default",
 				},
 				Rules: []rbacv1.PolicyRule{
@@ -113,13 +126,13 @@ var _ = Describe("KubeconfigReconciler", Ordered, func() {
 			}
 			expectedRoleBinding := &rbacv1.RoleBinding{
 				ObjectMeta: metav1.ObjectMeta{
-					Name:      kubeconfig.Name,
+					Name:      roleName,
 					Namespace: "default",
 				},
 				RoleRef: rbacv1.RoleRef{
 					APIGroup: rbacv1.GroupName,
 					Kind:     "Role",
-					Name:     kubeconfig.Name,
+					Name:     roleName,
 				},
 				Subjects: []rbacv1.Subject{
 					{
@@ -144,7 +157,7 @@ var _ = Describe("KubeconfigReconciler", Ordered, func() {
 		Eventually(func(g Gomega) {
 			expectedRole := &rbacv1.Role{
 				ObjectMeta: metav1.ObjectMeta{
-					Name:      kubeconfig.Name,
+					Name:      roleName,
 					Namespace: "kube-system",
 				},
 				Rules: []rbacv1.PolicyRule{
@@ -157,13 +170,13 @@ var _ = Describe("KubeconfigReconciler", Ordered, func() {
 			}
 			expectedRoleBinding := &rbacv1.RoleBinding{
 				ObjectMeta: metav1.ObjectMeta{
-					Name:      kubeconfig.Name,
+					Name:      roleName,
 					Namespace: "kube-system",
 				},
 				RoleRef: rbacv1.RoleRef{
 					APIGroup: rbacv1.GroupName,
 					Kind:     "Role",
-					Name:     kubeconfig.Name,
+					Name:     roleName,
 				},
 				Subjects: []rbacv1.Subject{
 					{
@@ -186,7 +199,7 @@ var _ = Describe("KubeconfigReconciler", Ordered, func() {
 
 		// 4. ClusterRole and ClusterRoleBinding for cluster permissions
 		Eventually(func(g Gomega) {
-			clusterRoleName := fmt.Sprintf("%s-%s", kubeconfig.Name, kubeconfig.Namespace)
+			clusterRoleName := serviceaccount.NewBuilder(kubeconfig).ObjectName()
 			expectedClusterRole := &rbacv1.ClusterRole{
 				ObjectMeta: metav1.ObjectMeta{
 					Name: clusterRoleName,
@@ -287,13 +300,13 @@ var _ = Describe("KubeconfigReconciler", Ordered, func() {
 		Eventually(func(g Gomega) {
 			expectedDeletedRole := &rbacv1.Role{
 				ObjectMeta: metav1.ObjectMeta{
-					Name:      kubeconfig.Name,
+					Name:      roleName,
 					Namespace: "kube-system",
 				},
 			}
 			expectedDeletedRoleBinding := &rbacv1.RoleBinding{
 				ObjectMeta: metav1.ObjectMeta{
-					Name:      kubeconfig.Name,
+					Name:      roleName,
 					Namespace: "kube-system",
 				},
 			}
@@ -307,7 +320,7 @@ var _ = Describe("KubeconfigReconciler", Ordered, func() {
 		Expect(c.Delete(ctx, kubeconfig)).To(Succeed())
 
 		Eventually(func(g Gomega) {
-			clusterRoleName := fmt.Sprintf("%s-%s", kubeconfig.Name, kubeconfig.Namespace)
+			clusterRoleName := serviceaccount.NewBuilder(kubeconfig).ObjectName()
 			expectedDeletedClusterRole := &rbacv1.ClusterRole{
 				ObjectMeta: metav1.ObjectMeta{
 					Name: clusterRoleName,
@@ -321,6 +334,7 @@ var _ = Describe("KubeconfigReconciler", Ordered, func() {
 
 			g.Expect(errors.IsNotFound(c.Get(ctx, client.ObjectKeyFromObject(expectedDeletedClusterRole), &rbacv1.ClusterRole{}))).To(BeTrue())
 			g.Expect(errors.IsNotFound(c.Get(ctx, client.ObjectKeyFromObject(expectedDeletedClusterRoleBinding), &rbacv1.ClusterRoleBinding{}))).To(BeTrue())
+			g.Expect(errors.IsNotFound(c.Get(ctx, client.ObjectKeyFromObject(kubeconfig), &v1alpha1.Kubeconfig{}))).To(BeTrue())
 		}).Should(Succeed())
 	})
 
@@ -368,3 +382,2042 @@ var _ = Describe("KubeconfigReconciler", Ordered, func() {
 		}, "60s", "2s").Should(Succeed())
 	})
 })
+
+var _ = Describe("KubeconfigReconciler token rotation", func() {
+	var (
+		ctx                  = context.Background()
+		kubeconfig           *v1alpha1.Kubeconfig
+		kubeconfigSecretName string
+	)
+
+	BeforeEach(func() {
+		kubeconfig = &v1alpha1.Kubeconfig{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "token-rotation",
+				Namespace: "default",
+			},
+			Spec: v1alpha1.KubeconfigSpec{
+				Server:        "https://kubernetes.example.com",
+				ClusterName:   "kubernetes",
+				ExpirationTTL: "10m",
+			},
+		}
+		kubeconfigSecretName = kubeconfig.Name + "-kubeconfig"
+
+		Expect(c.Create(ctx, kubeconfig)).To(Succeed())
+	})
+
+	AfterEach(func() {
+		deleteKubeconfig(ctx, kubeconfig)
+	})
+
+	It("should rotate the token at its refresh time without an external trigger", func() {
+		By("refreshing 10 minute tokens 10 seconds after they were issued")
+		updatedKubeconfig := kubeconfig.DeepCopy()
+		_, err := controllerutil.CreateOrPatch(ctx, c, updatedKubeconfig, func() error {
+			updatedKubeconfig.Spec.RefreshPolicy = &v1alpha1.RefreshPolicy{
+				RefreshBefore: "590s",
+			}
+			return nil
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		By("waiting for a token rotated at its refresh time")
+		var rotatedToken string
+		var refreshesAt time.Time
+		Eventually(func(g Gomega) {
+			actual := &v1alpha1.Kubeconfig{}
+			g.Expect(c.Get(ctx, client.ObjectKeyFromObject(kubeconfig), actual)).To(Succeed())
+			g.Expect(actual.Status.ServiceAccountTokenRotationReason).To(Equal("RefreshDue"))
+			refreshesAt = actual.Status.ServiceAccountTokenRefreshesAt.Time
+
+			secret := &corev1.Secret{}
+			g.Expect(c.Get(ctx, client.ObjectKey{Namespace: kubeconfig.Namespace, Name: kubeconfigSecretName}, secret)).To(Succeed())
+			rotatedToken = string(secret.Data["token"])
+		}, "30s", "1s").Should(Succeed())
+
+		By("rotating the token again once the refresh time has passed")
+		// nothing but the scheduled requeue triggers this rotation
+		Eventually(func(g Gomega) {
+			secret := &corev1.Secret{}
+			g.Expect(c.Get(ctx, client.ObjectKey{Namespace: kubeconfig.Namespace, Name: kubeconfigSecretName}, secret)).To(Succeed())
+			g.Expect(secret.Data["token"]).NotTo(BeEmpty())
+			g.Expect(string(secret.Data["token"])).NotTo(Equal(rotatedToken))
+
+			actual := &v1alpha1.Kubeconfig{}
+			g.Expect(c.Get(ctx, client.ObjectKeyFromObject(kubeconfig), actual)).To(Succeed())
+			g.Expect(actual.Status.ServiceAccountTokenRefreshesAt.Time).To(BeTemporally(">", refreshesAt))
+		}, "30s", "1s").Should(Succeed())
+	})
+
+	It("should reissue tokens rejected by the API server", func() {
+		By("waiting for the initial kubeconfig secret")
+		Eventually(func(g Gomega) {
+			reviewedToken(g, kubeconfig, kubeconfigSecretName)
+		}).Should(Succeed())
+
+		By("replacing the token with one that has valid claims but no valid signature")
+		secret := &corev1.Secret{}
+		issuedAt := time.Now().Truncate(time.Second)
+		invalidToken := unsignedToken(issuedAt, issuedAt.Add(10*time.Minute))
+		Eventually(func(g Gomega) {
+			g.Expect(c.Get(ctx, client.ObjectKey{Namespace: kubeconfig.Namespace, Name: kubeconfigSecretName}, secret)).To(Succeed())
+			secret.Data["token"] = []byte(invalidToken)
+			g.Expect(c.Update(ctx, secret)).To(Succeed())
+		}).Should(Succeed())
+
+		By("issuing a new token")
+		Eventually(func(g Gomega) {
+			actual := &v1alpha1.Kubeconfig{}
+			g.Expect(c.Get(ctx, client.ObjectKeyFromObject(kubeconfig), actual)).To(Succeed())
+			g.Expect(actual.Status.ServiceAccountTokenRotationReason).To(Equal("TokenRejected"))
+			g.Expect(actual.GetCondition(v1alpha1.TypeTokenReviewed).Status).To(Equal(corev1.ConditionTrue))
+
+			g.Expect(c.Get(ctx, client.ObjectKey{Namespace: kubeconfig.Namespace, Name: kubeconfigSecretName}, secret)).To(Succeed())
+			g.Expect(string(secret.Data["token"])).NotTo(Equal(invalidToken))
+			g.Expect(tokenAuthenticated(g, string(secret.Data["token"]))).To(BeTrue())
+		}).Should(Succeed())
+	})
+
+	It("should record the key that signed the token", func() {
+		By("waiting for the initial token")
+		var initialToken string
+		Eventually(func(g Gomega) {
+			initialToken = reviewedToken(g, kubeconfig, kubeconfigSecretName)
+		}).Should(Succeed())
+
+		parsed, _, err := jwt.NewParser().ParseUnverified(initialToken, jwt.MapClaims{})
+		Expect(err).NotTo(HaveOccurred())
+
+		kubeClient, err := kubernetes.NewForConfig(testEnv.Cfg)
+		Expect(err).NotTo(HaveOccurred())
+		body, err := kubeClient.Discovery().RESTClient().Get().AbsPath("/openid/v1/jwks").DoRaw(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		var jwks struct {
+			Keys []struct {
+				KeyID string `json:"kid"`
+			} `json:"keys"`
+		}
+		Expect(json.Unmarshal(body, &jwks)).To(Succeed())
+
+		Eventually(func(g Gomega) {
+			actual := &v1alpha1.Kubeconfig{}
+			g.Expect(c.Get(ctx, client.ObjectKeyFromObject(kubeconfig), actual)).To(Succeed())
+			g.Expect(actual.Status.ServiceAccountTokenKeyID).NotTo(BeEmpty())
+			g.Expect(actual.Status.ServiceAccountTokenKeyID).To(Equal(parsed.Header["kid"]))
+			g.Expect(jwks.Keys).To(ContainElement(HaveField("KeyID", actual.Status.ServiceAccountTokenKeyID)))
+		}).Should(Succeed())
+	})
+
+	It("should refresh the token according to the refresh policy", func() {
+		By("refreshing 585 seconds before a 10 minute token expires, with up to 5 seconds of jitter")
+		updatedKubeconfig := kubeconfig.DeepCopy()
+		_, err := controllerutil.CreateOrPatch(ctx, c, updatedKubeconfig, func() error {
+			updatedKubeconfig.Spec.RefreshPolicy = &v1alpha1.RefreshPolicy{
+				RefreshBefore: "585s",
+				Jitter:        "5s",
+			}
+			return nil
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		By("scheduling the refresh within the jitter window")
+		var initialToken string
+		Eventually(func(g Gomega) {
+			secret := &corev1.Secret{}
+			g.Expect(c.Get(ctx, client.ObjectKey{Namespace: kubeconfig.Namespace, Name: kubeconfigSecretName}, secret)).To(Succeed())
+			g.Expect(secret.Data["token"]).NotTo(BeEmpty())
+			initialToken = string(secret.Data["token"])
+
+			actual := &v1alpha1.Kubeconfig{}
+			g.Expect(c.Get(ctx, client.ObjectKeyFromObject(kubeconfig), actual)).To(Succeed())
+			g.Expect(actual.Status.ServiceAccountTokenRefreshesAt).NotTo(BeNil())
+			issuedAt := actual.Status.ServiceAccountTokenIssuedAt.Time
+			g.Expect(actual.Status.ServiceAccountTokenRefreshesAt.Time).To(BeTemporally(">=", issuedAt.Add(9*time.Second)))
+			g.Expect(actual.Status.ServiceAccountTokenRefreshesAt.Time).To(BeTemporally("<=", issuedAt.Add(15*time.Second)))
+		}).Should(Succeed())
+
+		By("rotating the token at the refresh time")
+		Eventually(func(g Gomega) {
+			secret := &corev1.Secret{}
+			g.Expect(c.Get(ctx, client.ObjectKey{Namespace: kubeconfig.Namespace, Name: kubeconfigSecretName}, secret)).To(Succeed())
+			g.Expect(string(secret.Data["token"])).NotTo(Equal(initialToken))
+
+			actual := &v1alpha1.Kubeconfig{}
+			g.Expect(c.Get(ctx, client.ObjectKeyFromObject(kubeconfig), actual)).To(Succeed())
+			g.Expect(actual.Status.ServiceAccountTokenRotationReason).To(Equal("RefreshDue"))
+		}, "30s", "1s").Should(Succeed())
+	})
+
+	It("should report a jitter exceeding the time until the refresh as a condition", func() {
+		updatedKubeconfig := kubeconfig.DeepCopy()
+		_, err := controllerutil.CreateOrPatch(ctx, c, updatedKubeconfig, func() error {
+			updatedKubeconfig.Spec.RefreshPolicy = &v1alpha1.RefreshPolicy{
+				RefreshBefore: "5m",
+				Jitter:        "5m",
+			}
+			return nil
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		Eventually(func(g Gomega) {
+			actual := &v1alpha1.Kubeconfig{}
+			g.Expect(c.Get(ctx, client.ObjectKeyFromObject(kubeconfig), actual)).To(Succeed())
+			specValid := actual.GetCondition(v1alpha1.TypeSpecValid)
+			g.Expect(specValid.Status).To(Equal(corev1.ConditionFalse))
+			g.Expect(specValid.Reason).To(Equal(api.ConditionReason("InvalidRefreshPolicy")))
+			g.Expect(specValid.Message).To(ContainSubstring(`jitter "5m"`))
+		}).Should(Succeed())
+	})
+
+	It("should report failures of the credential provider and recover once it issues tokens again", func() {
+		By("waiting for the initial token")
+		var initialToken string
+		Eventually(func(g Gomega) {
+			initialToken = reviewedToken(g, kubeconfig, kubeconfigSecretName)
+		}).Should(Succeed())
+
+		By("requesting a rotation while the provider fails")
+		user := serviceaccount.NewBuilder(kubeconfig).User()
+		tokenProvider.Fail(user, goerrors.New("token backend unavailable"))
+		DeferCleanup(func() { tokenProvider.Fail(user, nil) })
+
+		updatedKubeconfig := kubeconfig.DeepCopy()
+		_, err := controllerutil.CreateOrPatch(ctx, c, updatedKubeconfig, func() error {
+			metav1.SetMetaDataAnnotation(&updatedKubeconfig.ObjectMeta, v1alpha1.AnnotationRotateRequestedAt, time.Now().Format(time.RFC3339))
+			return nil
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		Eventually(func(g Gomega) {
+			actual := &v1alpha1.Kubeconfig{}
+			g.Expect(c.Get(ctx, client.ObjectKeyFromObject(kubeconfig), actual)).To(Succeed())
+			provisioned := actual.GetCondition(v1alpha1.TypeKubeconfigProvisioned)
+			g.Expect(provisioned.Status).To(Equal(corev1.ConditionFalse))
+			g.Expect(provisioned.Message).To(ContainSubstring("token backend unavailable"))
+		}).Should(Succeed())
+
+		By("issuing the requested token once the provider recovers")
+		tokenProvider.Fail(user, nil)
+		Eventually(func(g Gomega) {
+			g.Expect(reviewedToken(g, kubeconfig, kubeconfigSecretName)).NotTo(Equal(initialToken))
+
+			actual := &v1alpha1.Kubeconfig{}
+			g.Expect(c.Get(ctx, client.ObjectKeyFromObject(kubeconfig), actual)).To(Succeed())
+			g.Expect(actual.Status.ServiceAccountTokenRotationReason).To(Equal("RotationRequested"))
+			g.Expect(actual.GetCondition(v1alpha1.TypeKubeconfigProvisioned).Status).To(Equal(corev1.ConditionTrue))
+		}, "30s").Should(Succeed())
+	})
+
+	It("should rotate the token once per rotation request", func() {
+		By("waiting for the initial token")
+		var initialToken string
+		Eventually(func(g Gomega) {
+			initialToken = reviewedToken(g, kubeconfig, kubeconfigSecretName)
+		}).Should(Succeed())
+
+		By("requesting a rotation via annotation")
+		rotationRequest := time.Now().Format(time.RFC3339)
+		updatedKubeconfig := kubeconfig.DeepCopy()
+		_, err := controllerutil.CreateOrPatch(ctx, c, updatedKubeconfig, func() error {
+			metav1.SetMetaDataAnnotation(&updatedKubeconfig.ObjectMeta, v1alpha1.AnnotationRotateRequestedAt, rotationRequest)
+			return nil
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		By("issuing a new token and recording the handled request")
+		var rotatedToken string
+		Eventually(func(g Gomega) {
+			secret := &corev1.Secret{}
+			g.Expect(c.Get(ctx, client.ObjectKey{Namespace: kubeconfig.Namespace, Name: kubeconfigSecretName}, secret)).To(Succeed())
+			g.Expect(string(secret.Data["token"])).NotTo(Equal(initialToken))
+			rotatedToken = string(secret.Data["token"])
+
+			actual := &v1alpha1.Kubeconfig{}
+			g.Expect(c.Get(ctx, client.ObjectKeyFromObject(kubeconfig), actual)).To(Succeed())
+			g.Expect(actual.Status.RotationRequestHandled).To(Equal(rotationRequest))
+			g.Expect(actual.Status.ServiceAccountTokenRotationReason).To(Equal("RotationRequested"))
+			g.Expect(actual.Status.ServiceAccountTokenRotatedAt).NotTo(BeNil())
+		}).Should(Succeed())
+
+		By("not rotating the token again for the same request")
+		Consistently(func(g Gomega) {
+			secret := &corev1.Secret{}
+			g.Expect(c.Get(ctx, client.ObjectKey{Namespace: kubeconfig.Namespace, Name: kubeconfigSecretName}, secret)).To(Succeed())
+			g.Expect(string(secret.Data["token"])).To(Equal(rotatedToken))
+		}, "3s").Should(Succeed())
+	})
+
+	It("should revoke previously issued tokens when the revocationGeneration changes", func() {
+		By("waiting for the initial token to be valid")
+		var initialToken string
+		Eventually(func(g Gomega) {
+			initialToken = reviewedToken(g, kubeconfig, kubeconfigSecretName)
+			g.Expect(tokenAuthenticated(g, initialToken)).To(BeTrue())
+		}).Should(Succeed())
+
+		By("incrementing the revocationGeneration")
+		updatedKubeconfig := kubeconfig.DeepCopy()
+		_, err := controllerutil.CreateOrPatch(ctx, c, updatedKubeconfig, func() error {
+			updatedKubeconfig.Spec.RevocationGeneration++
+			return nil
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		By("invalidating the old token and issuing a valid new one")
+		Eventually(func(g Gomega) {
+			actual := &v1alpha1.Kubeconfig{}
+			g.Expect(c.Get(ctx, client.ObjectKeyFromObject(kubeconfig), actual)).To(Succeed())
+			g.Expect(actual.Status.RevocationGeneration).To(Equal(int64(1)))
+			g.Expect(actual.Status.RevokedAt).NotTo(BeNil())
+			g.Expect(actual.Status.ServiceAccountTokenRotationReason).To(Equal("ServiceAccountChanged"))
+
+			secret := &corev1.Secret{}
+			g.Expect(c.Get(ctx, client.ObjectKey{Namespace: kubeconfig.Namespace, Name: kubeconfigSecretName}, secret)).To(Succeed())
+			g.Expect(string(secret.Data["token"])).NotTo(Equal(initialToken))
+			g.Expect(tokenAuthenticated(g, string(secret.Data["token"]))).To(BeTrue())
+			g.Expect(tokenAuthenticated(g, initialToken)).To(BeFalse())
+		}).Should(Succeed())
+
+		By("revoking the tokens once more when the revocationGeneration is reset")
+		revokedToken := reviewedToken(Default, kubeconfig, kubeconfigSecretName)
+		_, err = controllerutil.CreateOrPatch(ctx, c, updatedKubeconfig, func() error {
+			updatedKubeconfig.Spec.RevocationGeneration = 0
+			return nil
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		var resetToken string
+		Eventually(func(g Gomega) {
+			actual := &v1alpha1.Kubeconfig{}
+			g.Expect(c.Get(ctx, client.ObjectKeyFromObject(kubeconfig), actual)).To(Succeed())
+			g.Expect(actual.Status.RevocationGeneration).To(BeZero())
+
+			resetToken = reviewedToken(g, kubeconfig, kubeconfigSecretName)
+			g.Expect(resetToken).NotTo(Equal(revokedToken))
+			g.Expect(tokenAuthenticated(g, resetToken)).To(BeTrue())
+		}).Should(Succeed())
+
+		By("keeping the token once the reset has been handled")
+		Consistently(func(g Gomega) {
+			g.Expect(reviewedToken(g, kubeconfig, kubeconfigSecretName)).To(Equal(resetToken))
+		}, "3s").Should(Succeed())
+	})
+
+	It("should invalidate bound tokens when the kubeconfig secret is deleted", func() {
+		By("waiting for the initial token")
+		Eventually(func(g Gomega) {
+			reviewedToken(g, kubeconfig, kubeconfigSecretName)
+		}).Should(Succeed())
+
+		By("binding the token to the kubeconfig secret")
+		updatedKubeconfig := kubeconfig.DeepCopy()
+		_, err := controllerutil.CreateOrPatch(ctx, c, updatedKubeconfig, func() error {
+			updatedKubeconfig.Spec.BindTokenToSecret = true
+			return nil
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		var boundToken string
+		boundSecret := &corev1.Secret{}
+		Eventually(func(g Gomega) {
+			g.Expect(c.Get(ctx, client.ObjectKey{Namespace: kubeconfig.Namespace, Name: kubeconfigSecretName}, boundSecret)).To(Succeed())
+			g.Expect(boundSecret.Data).To(HaveKey("kubeconfig"))
+			g.Expect(boundSecret.Data["token"]).NotTo(BeEmpty())
+			boundToken = string(boundSecret.Data["token"])
+
+			claims := jwt.MapClaims{}
+			_, _, err := jwt.NewParser().ParseUnverified(boundToken, claims)
+			g.Expect(err).NotTo(HaveOccurred())
+			g.Expect(claims).To(HaveKeyWithValue("kubernetes.io", HaveKeyWithValue("secret", HaveKeyWithValue("uid", string(boundSecret.UID)))))
+			g.Expect(tokenAuthenticated(g, boundToken)).To(BeTrue())
+		}).Should(Succeed())
+
+		By("reporting the binding as the rotation reason although the secret was recreated")
+		Eventually(func(g Gomega) {
+			actual := &v1alpha1.Kubeconfig{}
+			g.Expect(c.Get(ctx, client.ObjectKeyFromObject(kubeconfig), actual)).To(Succeed())
+			g.Expect(actual.Status.ServiceAccountTokenRotationReason).To(Equal("BindingChanged"))
+			g.Expect(actual.Status.ServiceAccountTokenPendingRotationReason).To(BeEmpty())
+			g.Expect(actual.Status.ServiceAccountTokenRotatedAt).NotTo(BeNil())
+		}).Should(Succeed())
+
+		By("deleting the kubeconfig secret")
+		Expect(c.Delete(ctx, boundSecret)).To(Succeed())
+
+		By("invalidating the old token and issuing a token bound to the new secret")
+		Eventually(func(g Gomega) {
+			g.Expect(tokenAuthenticated(g, boundToken)).To(BeFalse())
+
+			secret := &corev1.Secret{}
+			g.Expect(c.Get(ctx, client.ObjectKey{Namespace: kubeconfig.Namespace, Name: kubeconfigSecretName}, secret)).To(Succeed())
+			g.Expect(secret.UID).NotTo(Equal(boundSecret.UID))
+			g.Expect(secret.Data["token"]).NotTo(BeEmpty())
+			g.Expect(tokenAuthenticated(g, string(secret.Data["token"]))).To(BeTrue())
+
+		}).Should(Succeed())
+	})
+
+	It("should reissue the token when the audiences change", func() {
+		By("waiting for the initial token with the default audiences")
+		var defaultAudiences []string
+		Eventually(func(g Gomega) {
+			reviewedToken(g, kubeconfig, kubeconfigSecretName)
+
+			actual := &v1alpha1.Kubeconfig{}
+			g.Expect(c.Get(ctx, client.ObjectKeyFromObject(kubeconfig), actual)).To(Succeed())
+			g.Expect(actual.Status.ServiceAccountTokenAudiences).NotTo(BeEmpty())
+			defaultAudiences = actual.Status.ServiceAccountTokenAudiences
+		}).Should(Succeed())
+
+		By("requesting a custom audience")
+		updatedKubeconfig := kubeconfig.DeepCopy()
+		_, err := controllerutil.CreateOrPatch(ctx, c, updatedKubeconfig, func() error {
+			updatedKubeconfig.Spec.Audiences = []string{"vault"}
+			return nil
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		Eventually(func(g Gomega) {
+			actual := &v1alpha1.Kubeconfig{}
+			g.Expect(c.Get(ctx, client.ObjectKeyFromObject(kubeconfig), actual)).To(Succeed())
+			g.Expect(actual.Status.ServiceAccountTokenRotationReason).To(Equal("AudiencesChanged"))
+			g.Expect(actual.Status.ServiceAccountTokenAudiences).To(Equal([]string{"vault"}))
+			g.Expect(actual.Status.ServiceAccountTokenRequestedAudiences).To(Equal([]string{"vault"}))
+
+			secret := &corev1.Secret{}
+			g.Expect(c.Get(ctx, client.ObjectKey{Namespace: kubeconfig.Namespace, Name: kubeconfigSecretName}, secret)).To(Succeed())
+			claims := jwt.MapClaims{}
+			_, _, err := jwt.NewParser().ParseUnverified(string(secret.Data["token"]), claims)
+			g.Expect(err).NotTo(HaveOccurred())
+			g.Expect(claims.GetAudience()).To(ConsistOf("vault"))
+		}).Should(Succeed())
+
+		By("reverting to the default audiences")
+		_, err = controllerutil.CreateOrPatch(ctx, c, updatedKubeconfig, func() error {
+			updatedKubeconfig.Spec.Audiences = nil
+			return nil
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		Eventually(func(g Gomega) {
+			actual := &v1alpha1.Kubeconfig{}
+			g.Expect(c.Get(ctx, client.ObjectKeyFromObject(kubeconfig), actual)).To(Succeed())
+			g.Expect(actual.Status.ServiceAccountTokenAudiences).To(Equal(defaultAudiences))
+			g.Expect(actual.Status.ServiceAccountTokenRequestedAudiences).To(BeEmpty())
+		}).Should(Succeed())
+	})
+
+	It("should keep publishing the previous token during the rotation grace period", func() {
+		By("waiting for the initial token")
+		var initialToken string
+		Eventually(func(g Gomega) {
+			initialToken = reviewedToken(g, kubeconfig, kubeconfigSecretName)
+		}).Should(Succeed())
+
+		By("enabling the grace period and requesting a rotation")
+		updatedKubeconfig := kubeconfig.DeepCopy()
+		_, err := controllerutil.CreateOrPatch(ctx, c, updatedKubeconfig, func() error {
+			updatedKubeconfig.Spec.RotationGracePeriod = "10s"
+			metav1.SetMetaDataAnnotation(&updatedKubeconfig.ObjectMeta, v1alpha1.AnnotationRotateRequestedAt, time.Now().Format(time.RFC3339))
+			return nil
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		By("publishing the new and the previous token")
+		Eventually(func(g Gomega) {
+			secret := &corev1.Secret{}
+			g.Expect(c.Get(ctx, client.ObjectKey{Namespace: kubeconfig.Namespace, Name: kubeconfigSecretName}, secret)).To(Succeed())
+			g.Expect(string(secret.Data["token"])).NotTo(Equal(initialToken))
+			g.Expect(string(secret.Data["token.previous"])).To(Equal(initialToken))
+
+			cfg, err := clientcmd.Load(secret.Data["kubeconfig"])
+			g.Expect(err).NotTo(HaveOccurred())
+			g.Expect(cfg.AuthInfos[kubeconfig.Name].Token).To(Equal(string(secret.Data["token"])))
+			g.Expect(cfg.AuthInfos[kubeconfig.Name+"-previous"].Token).To(Equal(initialToken))
+			g.Expect(cfg.Contexts).To(HaveKey(kubeconfig.Name + "-previous@kubernetes"))
+			g.Expect(cfg.CurrentContext).To(Equal(kubeconfig.Name + "@kubernetes"))
+
+			actual := &v1alpha1.Kubeconfig{}
+			g.Expect(c.Get(ctx, client.ObjectKeyFromObject(kubeconfig), actual)).To(Succeed())
+			g.Expect(actual.Status.PreviousTokenPublishedUntil.IsZero()).To(BeFalse())
+			g.Expect(actual.Status.PreviousTokenPublishedUntil.Time).To(BeTemporally("~", actual.Status.ServiceAccountTokenIssuedAt.Add(10*time.Second), 2*time.Second))
+		}).Should(Succeed())
+
+		By("removing the previous token once the grace period ends")
+		Eventually(func(g Gomega) {
+			secret := &corev1.Secret{}
+			g.Expect(c.Get(ctx, client.ObjectKey{Namespace: kubeconfig.Namespace, Name: kubeconfigSecretName}, secret)).To(Succeed())
+			g.Expect(secret.Data).NotTo(HaveKey("token.previous"))
+
+			cfg, err := clientcmd.Load(secret.Data["kubeconfig"])
+			g.Expect(err).NotTo(HaveOccurred())
+			g.Expect(cfg.AuthInfos).To(HaveLen(1))
+			g.Expect(cfg.Contexts).To(HaveLen(1))
+
+			actual := &v1alpha1.Kubeconfig{}
+			g.Expect(c.Get(ctx, client.ObjectKeyFromObject(kubeconfig), actual)).To(Succeed())
+			g.Expect(actual.Status.PreviousTokenPublishedUntil.IsZero()).To(BeTrue())
+		}, "30s", "1s").Should(Succeed())
+	})
+
+	It("should report an invalid expirationTTL as a condition", func() {
+		Eventually(func(g Gomega) {
+			reviewedToken(g, kubeconfig, kubeconfigSecretName)
+		}).Should(Succeed())
+
+		By("setting an invalid expirationTTL")
+		updatedKubeconfig := kubeconfig.DeepCopy()
+		_, err := controllerutil.CreateOrPatch(ctx, c, updatedKubeconfig, func() error {
+			updatedKubeconfig.Spec.ExpirationTTL = "5m"
+			return nil
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		Eventually(func(g Gomega) {
+			actual := &v1alpha1.Kubeconfig{}
+			g.Expect(c.Get(ctx, client.ObjectKeyFromObject(kubeconfig), actual)).To(Succeed())
+			g.Expect(actual.GetCondition(api.TypeReady).Status).To(Equal(corev1.ConditionFalse))
+			g.Expect(actual.GetCondition(api.TypeReady).ObservedGeneration).To(Equal(actual.Generation))
+			specValid := actual.GetCondition(v1alpha1.TypeSpecValid)
+			g.Expect(specValid.Status).To(Equal(corev1.ConditionFalse))
+			g.Expect(specValid.Reason).To(Equal(api.ConditionReason("InvalidExpirationTTL")))
+			g.Expect(specValid.Message).To(ContainSubstring("shorter than the minimum of 10m0s"))
+		}).Should(Succeed())
+
+		By("setting a compound expirationTTL")
+		_, err = controllerutil.CreateOrPatch(ctx, c, updatedKubeconfig, func() error {
+			updatedKubeconfig.Spec.ExpirationTTL = "1d12h"
+			return nil
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		Eventually(func(g Gomega) {
+			actual := &v1alpha1.Kubeconfig{}
+			g.Expect(c.Get(ctx, client.ObjectKeyFromObject(kubeconfig), actual)).To(Succeed())
+			g.Expect(actual.GetCondition(api.TypeReady).Status).To(Equal(corev1.ConditionTrue))
+			g.Expect(actual.GetCondition(v1alpha1.TypeSpecValid).Status).To(Equal(corev1.ConditionTrue))
+			g.Expect(actual.Status.ServiceAccountTokenExpiresAt.Sub(actual.Status.ServiceAccountTokenIssuedAt.Time)).To(Equal(36 * time.Hour))
+		}).Should(Succeed())
+	})
+
+	It("should reissue the token when the expirationTTL changes", func() {
+		By("waiting for the initial token")
+		var initialToken string
+		Eventually(func(g Gomega) {
+			initialToken = reviewedToken(g, kubeconfig, kubeconfigSecretName)
+		}).Should(Succeed())
+
+		By("changing the expirationTTL")
+		updatedKubeconfig := kubeconfig.DeepCopy()
+		_, err := controllerutil.CreateOrPatch(ctx, c, updatedKubeconfig, func() error {
+			updatedKubeconfig.Spec.ExpirationTTL = "1h"
+			return nil
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		By("issuing a token with the new lifetime")
+		Eventually(func(g Gomega) {
+			secret := &corev1.Secret{}
+			g.Expect(c.Get(ctx, client.ObjectKey{Namespace: kubeconfig.Namespace, Name: kubeconfigSecretName}, secret)).To(Succeed())
+			g.Expect(string(secret.Data["token"])).NotTo(Equal(initialToken))
+
+			actual := &v1alpha1.Kubeconfig{}
+			g.Expect(c.Get(ctx, client.ObjectKeyFromObject(kubeconfig), actual)).To(Succeed())
+			g.Expect(actual.Status.ServiceAccountTokenRotationReason).To(Equal("ExpirationTTLChanged"))
+			g.Expect(actual.Status.ServiceAccountTokenExpiresAt.Sub(actual.Status.ServiceAccountTokenIssuedAt.Time)).To(Equal(time.Hour))
+		}).Should(Succeed())
+	})
+})
+
+var _ = Describe("KubeconfigReconciler client certificates", func() {
+	var (
+		ctx                  = context.Background()
+		kubeconfig           *v1alpha1.Kubeconfig
+		kubeconfigSecretName string
+		clusterRoleName      string
+	)
+
+	BeforeEach(func() {
+		// envtest doesn't run the kube-controller-manager which signs approved requests
+		kubeClient, err := kubernetes.NewForConfig(testEnv.Cfg)
+		Expect(err).NotTo(HaveOccurred())
+		signer, err := test.NewCertificateSigner(kubeClient)
+		Expect(err).NotTo(HaveOccurred())
+		// caps the lifetime of certificates like the --cluster-signing-duration of the kube-controller-manager
+		signer.MaxDuration = 90 * time.Minute
+		signerCtx, cancel := context.WithCancel(ctx)
+		DeferCleanup(cancel)
+		go signer.Run(signerCtx)
+
+		kubeconfig = &v1alpha1.Kubeconfig{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "client-certificate",
+				Namespace: "default",
+			},
+			Spec: v1alpha1.KubeconfigSpec{
+				Server:         "https://kubernetes.example.com",
+				ClusterName:    "kubernetes",
+				CredentialType: v1alpha1.CredentialTypeClientCertificate,
+				ExpirationTTL:  "1h",
+				ClusterPermissions: &v1alpha1.ClusterPermissions{
+					Rules: []rbacv1.PolicyRule{
+						{
+							APIGroups: []string{""},
+							Resources: []string{"namespaces"},
+							Verbs:     []string{"get", "list", "watch"},
+						},
+					},
+				},
+			},
+		}
+		kubeconfigSecretName = kubeconfig.Name + "-kubeconfig"
+		clusterRoleName = serviceaccount.NewBuilder(kubeconfig).ObjectName()
+
+		Expect(c.Create(ctx, kubeconfig)).To(Succeed())
+	})
+
+	AfterEach(func() {
+		deleteKubeconfig(ctx, kubeconfig)
+	})
+
+	It("should publish a client certificate and bind the permissions to its user", func() {
+		By("publishing the certificate and key instead of a token")
+		var certificate []byte
+		Eventually(func(g Gomega) {
+			secret := &corev1.Secret{}
+			g.Expect(c.Get(ctx, client.ObjectKey{Namespace: kubeconfig.Namespace, Name: kubeconfigSecretName}, secret)).To(Succeed())
+			g.Expect(secret.Data).NotTo(HaveKey("token"))
+			g.Expect(secret.Data).To(HaveKey(corev1.TLSPrivateKeyKey))
+			certificate = secret.Data[corev1.TLSCertKey]
+			g.Expect(certificate).NotTo(BeEmpty())
+
+			kubeconfigData, err := clientcmd.Load(secret.Data["kubeconfig"])
+			g.Expect(err).NotTo(HaveOccurred())
+			authInfo := kubeconfigData.AuthInfos[kubeconfig.Name]
+			g.Expect(authInfo).NotTo(BeNil())
+			g.Expect(authInfo.Token).To(BeEmpty())
+			g.Expect(authInfo.ClientCertificateData).To(Equal(certificate))
+			g.Expect(authInfo.ClientKeyData).To(Equal(secret.Data[corev1.TLSPrivateKeyKey]))
+		}).Should(Succeed())
+
+		actual := &v1alpha1.Kubeconfig{}
+		Expect(c.Get(ctx, client.ObjectKeyFromObject(kubeconfig), actual)).To(Succeed())
+		Expect(actual.Status.User).To(Equal("klaud.works:kubeconfig:default:client-certificate"))
+		Expect(certificateSubject(certificate)).To(Equal(actual.Status.User))
+		Expect(actual.Status.ServiceAccountTokenExpiresAt.Time).To(BeTemporally("~", time.Now().Add(time.Hour), 10*time.Second))
+
+		By("binding the permissions to the user of the certificate")
+		Eventually(func(g Gomega) {
+			binding := &rbacv1.ClusterRoleBinding{}
+			g.Expect(c.Get(ctx, client.ObjectKey{Name: clusterRoleName}, binding)).To(Succeed())
+			g.Expect(binding.Subjects).To(ConsistOf(rbacv1.Subject{
+				APIGroup: rbacv1.GroupName,
+				Kind:     rbacv1.UserKind,
+				Name:     actual.Status.User,
+			}))
+		}).Should(Succeed())
+
+		By("not creating a service account")
+		Expect(errors.IsNotFound(c.Get(ctx, client.ObjectKeyFromObject(kubeconfig), &corev1.ServiceAccount{}))).To(BeTrue())
+	})
+
+	It("should clear the service account details when switching from a service account token to a certificate", func() {
+		Eventually(func(g Gomega) {
+			secret := &corev1.Secret{}
+			g.Expect(c.Get(ctx, client.ObjectKey{Namespace: kubeconfig.Namespace, Name: kubeconfigSecretName}, secret)).To(Succeed())
+			g.Expect(secret.Data[corev1.TLSCertKey]).NotTo(BeEmpty())
+		}).Should(Succeed())
+
+		By("switching to a service account token")
+		updatedKubeconfig := kubeconfig.DeepCopy()
+		_, err := controllerutil.CreateOrPatch(ctx, c, updatedKubeconfig, func() error {
+			updatedKubeconfig.Spec.CredentialType = v1alpha1.CredentialTypeServiceAccountToken
+			return nil
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		Eventually(func(g Gomega) {
+			actual := &v1alpha1.Kubeconfig{}
+			g.Expect(c.Get(ctx, client.ObjectKeyFromObject(kubeconfig), actual)).To(Succeed())
+			g.Expect(actual.Status.ServiceAccountRef).To(Equal(ptr.To(kubeconfig.Name)))
+			g.Expect(actual.Status.ServiceAccountTokenKeyID).NotTo(BeEmpty())
+			g.Expect(actual.Status.ServiceAccountTokenAudiences).NotTo(BeEmpty())
+		}).Should(Succeed())
+
+		By("switching back to a client certificate")
+		_, err = controllerutil.CreateOrPatch(ctx, c, updatedKubeconfig, func() error {
+			updatedKubeconfig.Spec.CredentialType = v1alpha1.CredentialTypeClientCertificate
+			return nil
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		Eventually(func(g Gomega) {
+			secret := &corev1.Secret{}
+			g.Expect(c.Get(ctx, client.ObjectKey{Namespace: kubeconfig.Namespace, Name: kubeconfigSecretName}, secret)).To(Succeed())
+			g.Expect(secret.Data).NotTo(HaveKey("token"))
+			g.Expect(secret.Data[corev1.TLSCertKey]).NotTo(BeEmpty())
+
+			actual := &v1alpha1.Kubeconfig{}
+			g.Expect(c.Get(ctx, client.ObjectKeyFromObject(kubeconfig), actual)).To(Succeed())
+			g.Expect(actual.Status.User).To(Equal("klaud.works:kubeconfig:default:client-certificate"))
+			g.Expect(actual.Status.ServiceAccountRef).To(BeNil())
+			g.Expect(actual.Status.ServiceAccountTokenKeyID).To(BeEmpty())
+			g.Expect(actual.Status.ServiceAccountTokenAudiences).To(BeEmpty())
+		}).Should(Succeed())
+	})
+
+	It("should report when the lifetime of the credential is capped", func() {
+		Eventually(func(g Gomega) {
+			actual := &v1alpha1.Kubeconfig{}
+			g.Expect(c.Get(ctx, client.ObjectKeyFromObject(kubeconfig), actual)).To(Succeed())
+			g.Expect(actual.GetCondition(v1alpha1.TypeTokenLifetimeCapped).Status).To(Equal(corev1.ConditionFalse))
+			g.Expect(actual.Status.ServiceAccountTokenRequestedTTL.Duration).To(Equal(time.Hour))
+		}).Should(Succeed())
+
+		By("requesting a lifetime longer than the signer grants")
+		updatedKubeconfig := kubeconfig.DeepCopy()
+		_, err := controllerutil.CreateOrPatch(ctx, c, updatedKubeconfig, func() error {
+			updatedKubeconfig.Spec.ExpirationTTL = "2h"
+			return nil
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		var actual *v1alpha1.Kubeconfig
+		Eventually(func(g Gomega) {
+			actual = &v1alpha1.Kubeconfig{}
+			g.Expect(c.Get(ctx, client.ObjectKeyFromObject(kubeconfig), actual)).To(Succeed())
+			g.Expect(actual.GetCondition(v1alpha1.TypeTokenLifetimeCapped).Status).To(Equal(corev1.ConditionTrue))
+			g.Expect(actual.Status.ServiceAccountTokenRotationReason).To(Equal("ExpirationTTLChanged"))
+			g.Expect(actual.Status.ServiceAccountTokenRequestedTTL.Duration).To(Equal(2 * time.Hour))
+			// the signer backdates certificates by 5 minutes
+			g.Expect(actual.Status.ServiceAccountTokenEffectiveTTL.Duration).To(Equal(95 * time.Minute))
+		}).Should(Succeed())
+
+		By("scheduling the refresh based on the issued certificate")
+		Expect(actual.Status.ServiceAccountTokenRefreshesAt.Time).To(Equal(
+			actual.Status.ServiceAccountTokenIssuedAt.Add(76 * time.Minute)))
+
+		By("emitting an event")
+		Eventually(func(g Gomega) {
+			events := &corev1.EventList{}
+			g.Expect(c.List(ctx, events, client.InNamespace(kubeconfig.Namespace))).To(Succeed())
+			g.Expect(events.Items).To(ContainElement(And(
+				HaveField("InvolvedObject.Name", kubeconfig.Name),
+				HaveField("Reason", "TokenLifetimeCapped"),
+				HaveField("Type", corev1.EventTypeWarning),
+			)))
+		}).Should(Succeed())
+
+		By("not rotating the capped certificate again")
+		issuedAt := actual.Status.ServiceAccountTokenIssuedAt.Time
+		Consistently(func(g Gomega) {
+			actual := &v1alpha1.Kubeconfig{}
+			g.Expect(c.Get(ctx, client.ObjectKeyFromObject(kubeconfig), actual)).To(Succeed())
+			g.Expect(actual.Status.ServiceAccountTokenIssuedAt.Time).To(Equal(issuedAt))
+		}, "3s").Should(Succeed())
+
+		By("refreshing before more time than the issued certificate is valid")
+		_, err = controllerutil.CreateOrPatch(ctx, c, updatedKubeconfig, func() error {
+			updatedKubeconfig.Spec.RefreshPolicy = &v1alpha1.RefreshPolicy{RefreshBefore: "100m"}
+			return nil
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		By("falling back to the default schedule of the issued certificate instead of rotating continuously")
+		Consistently(func(g Gomega) {
+			actual := &v1alpha1.Kubeconfig{}
+			g.Expect(c.Get(ctx, client.ObjectKeyFromObject(kubeconfig), actual)).To(Succeed())
+			g.Expect(actual.Status.ServiceAccountTokenIssuedAt.Time).To(Equal(issuedAt))
+			g.Expect(actual.Status.ServiceAccountTokenRefreshesAt.Time).To(Equal(issuedAt.Add(76 * time.Minute)))
+		}, "3s").Should(Succeed())
+	})
+
+	It("should bind the permissions to a new user when the revocationGeneration changes", func() {
+		var initialCertificate []byte
+		Eventually(func(g Gomega) {
+			secret := &corev1.Secret{}
+			g.Expect(c.Get(ctx, client.ObjectKey{Namespace: kubeconfig.Namespace, Name: kubeconfigSecretName}, secret)).To(Succeed())
+			initialCertificate = secret.Data[corev1.TLSCertKey]
+			g.Expect(initialCertificate).NotTo(BeEmpty())
+		}).Should(Succeed())
+
+		By("incrementing the revocationGeneration")
+		updatedKubeconfig := kubeconfig.DeepCopy()
+		_, err := controllerutil.CreateOrPatch(ctx, c, updatedKubeconfig, func() error {
+			updatedKubeconfig.Spec.RevocationGeneration++
+			return nil
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		By("issuing a certificate for the new user and binding the permissions to it")
+		newUser := "klaud.works:kubeconfig:default:client-certificate:1"
+		Eventually(func(g Gomega) {
+			actual := &v1alpha1.Kubeconfig{}
+			g.Expect(c.Get(ctx, client.ObjectKeyFromObject(kubeconfig), actual)).To(Succeed())
+			g.Expect(actual.Status.RevocationGeneration).To(Equal(int64(1)))
+			g.Expect(actual.Status.User).To(Equal(newUser))
+			g.Expect(actual.Status.ServiceAccountTokenRotationReason).To(Equal("SubjectChanged"))
+
+			secret := &corev1.Secret{}
+			g.Expect(c.Get(ctx, client.ObjectKey{Namespace: kubeconfig.Namespace, Name: kubeconfigSecretName}, secret)).To(Succeed())
+			g.Expect(secret.Data[corev1.TLSCertKey]).NotTo(Equal(initialCertificate))
+			g.Expect(certificateSubject(secret.Data[corev1.TLSCertKey])).To(Equal(newUser))
+
+			binding := &rbacv1.ClusterRoleBinding{}
+			g.Expect(c.Get(ctx, client.ObjectKey{Name: clusterRoleName}, binding)).To(Succeed())
+			g.Expect(binding.Subjects).To(HaveLen(1))
+			g.Expect(binding.Subjects[0].Name).To(Equal(newUser))
+		}).Should(Succeed())
+	})
+})
+
+var _ = Describe("KubeconfigReconciler role references", func() {
+	var (
+		ctx        = context.Background()
+		kubeconfig *v1alpha1.Kubeconfig
+		role       *rbacv1.Role
+	)
+
+	BeforeEach(func() {
+		role = &rbacv1.Role{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "pod-reader",
+				Namespace: "default",
+			},
+			Rules: []rbacv1.PolicyRule{
+				{
+					APIGroups: []string{""},
+					Resources: []string{"pods"},
+					Verbs:     []string{"get", "list"},
+				},
+			},
+		}
+		Expect(c.Create(ctx, role)).To(Succeed())
+		DeferCleanup(func() {
+			Expect(client.IgnoreNotFound(c.Delete(ctx, role))).To(Succeed())
+		})
+
+		kubeconfig = &v1alpha1.Kubeconfig{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "role-refs",
+				Namespace: "default",
+			},
+			Spec: v1alpha1.KubeconfigSpec{
+				Server:        "https://kubernetes.example.com",
+				ClusterName:   "kubernetes",
+				ExpirationTTL: "1h",
+				RoleRefs: []v1alpha1.RoleRef{
+					{Kind: "Role", Name: role.Name, Namespace: role.Namespace},
+					{Kind: "ClusterRole", Name: "edit", Namespace: "kube-public"},
+					{Kind: "ClusterRole", Name: "view"},
+				},
+			},
+		}
+		Expect(c.Create(ctx, kubeconfig)).To(Succeed())
+	})
+
+	AfterEach(func() {
+		deleteKubeconfig(ctx, kubeconfig)
+	})
+
+	It("should bind the referenced roles and remove stale bindings", func() {
+		subject := rbacv1.Subject{
+			Kind:      rbacv1.ServiceAccountKind,
+			Name:      kubeconfig.Name,
+			Namespace: kubeconfig.Namespace,
+		}
+		builder := serviceaccount.NewBuilder(kubeconfig)
+		roleBinding := &rbacv1.RoleBinding{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: builder.ObjectName("role", "pod-reader")}}
+		namespacedClusterRoleBinding := &rbacv1.RoleBinding{ObjectMeta: metav1.ObjectMeta{Namespace: "kube-public", Name: builder.ObjectName("clusterrole", "edit")}}
+		clusterRoleBinding := &rbacv1.ClusterRoleBinding{ObjectMeta: metav1.ObjectMeta{Name: builder.ObjectName("clusterrole", "view")}}
+
+		By("binding the Role, the ClusterRole in a namespace and the ClusterRole cluster-wide")
+		Eventually(func(g Gomega) {
+			g.Expect(c.Get(ctx, client.ObjectKeyFromObject(roleBinding), roleBinding)).To(Succeed())
+			g.Expect(roleBinding.RoleRef).To(Equal(rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "Role", Name: role.Name}))
+			g.Expect(roleBinding.Subjects).To(ConsistOf(subject))
+
+			g.Expect(c.Get(ctx, client.ObjectKeyFromObject(namespacedClusterRoleBinding), namespacedClusterRoleBinding)).To(Succeed())
+			g.Expect(namespacedClusterRoleBinding.RoleRef).To(Equal(rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: "edit"}))
+			g.Expect(namespacedClusterRoleBinding.Subjects).To(ConsistOf(subject))
+
+			g.Expect(c.Get(ctx, client.ObjectKeyFromObject(clusterRoleBinding), clusterRoleBinding)).To(Succeed())
+			g.Expect(clusterRoleBinding.RoleRef).To(Equal(rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: "view"}))
+			g.Expect(clusterRoleBinding.Subjects).To(ConsistOf(subject))
+		}).Should(Succeed())
+
+		By("not modifying the referenced Role")
+		actualRole := &rbacv1.Role{}
+		Expect(c.Get(ctx, client.ObjectKeyFromObject(role), actualRole)).To(Succeed())
+		Expect(actualRole.Rules).To(Equal(role.Rules))
+		Expect(actualRole.OwnerReferences).To(BeEmpty())
+
+		By("removing the bindings of removed references")
+		updatedKubeconfig := kubeconfig.DeepCopy()
+		_, err := controllerutil.CreateOrPatch(ctx, c, updatedKubeconfig, func() error {
+			updatedKubeconfig.Spec.RoleRefs = updatedKubeconfig.Spec.RoleRefs[:1]
+			return nil
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		Eventually(func(g Gomega) {
+			g.Expect(errors.IsNotFound(c.Get(ctx, client.ObjectKeyFromObject(namespacedClusterRoleBinding), &rbacv1.RoleBinding{}))).To(BeTrue())
+			g.Expect(errors.IsNotFound(c.Get(ctx, client.ObjectKeyFromObject(clusterRoleBinding), &rbacv1.ClusterRoleBinding{}))).To(BeTrue())
+			g.Expect(c.Get(ctx, client.ObjectKeyFromObject(roleBinding), &rbacv1.RoleBinding{})).To(Succeed())
+		}).Should(Succeed())
+
+		By("removing the remaining bindings when the kubeconfig is deleted")
+		Expect(c.Delete(ctx, kubeconfig)).To(Succeed())
+		Eventually(func(g Gomega) {
+			g.Expect(errors.IsNotFound(c.Get(ctx, client.ObjectKeyFromObject(roleBinding), &rbacv1.RoleBinding{}))).To(BeTrue())
+		}).Should(Succeed())
+		Expect(c.Get(ctx, client.ObjectKeyFromObject(role), &rbacv1.Role{})).To(Succeed())
+	})
+
+	It("should reject Roles without a namespace", func() {
+		invalid := &v1alpha1.Kubeconfig{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "role-refs-invalid",
+				Namespace: "default",
+			},
+			Spec: v1alpha1.KubeconfigSpec{
+				Server:   "https://kubernetes.example.com",
+				RoleRefs: []v1alpha1.RoleRef{{Kind: "Role", Name: role.Name}},
+			},
+		}
+		Expect(c.Create(ctx, invalid)).To(MatchError(ContainSubstring("namespace is required for Roles")))
+	})
+})
+
+var _ = Describe("KubeconfigReconciler presets", func() {
+	var (
+		ctx        = context.Background()
+		kubeconfig *v1alpha1.Kubeconfig
+	)
+
+	// grantsSecrets reports whether any rule grants access to secrets.
+	grantsSecrets := func(rules []rbacv1.PolicyRule) bool {
+		for _, rule := range rules {
+			if slices.Contains(rule.APIGroups, "") && slices.Contains(rule.Resources, "secrets") {
+				return true
+			}
+		}
+		return false
+	}
+
+	BeforeEach(func() {
+		kubeconfig = &v1alpha1.Kubeconfig{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "presets",
+				Namespace: "default",
+			},
+			Spec: v1alpha1.KubeconfigSpec{
+				Server:        "https://kubernetes.example.com",
+				ClusterName:   "kubernetes",
+				ExpirationTTL: "1h",
+				NamespacedPermissions: []v1alpha1.NamespacedPermissions{
+					{
+						Namespace: "default",
+						Rules: []rbacv1.PolicyRule{
+							{
+								APIGroups: []string{""},
+								Resources: []string{"configmaps", "secrets"},
+								Verbs:     []string{"get"},
+							},
+						},
+					},
+				},
+				Presets: []v1alpha1.Preset{
+					{Name: v1alpha1.PresetReadOnlyCluster},
+					{Name: v1alpha1.PresetNamespaceEditor, Namespace: "kube-public"},
+				},
+			},
+		}
+		Expect(c.Create(ctx, kubeconfig)).To(Succeed())
+	})
+
+	AfterEach(func() {
+		deleteKubeconfig(ctx, kubeconfig)
+	})
+
+	It("should expand presets, deny secrets and remove roles of removed presets", func() {
+		builder := serviceaccount.NewBuilder(kubeconfig)
+		clusterRole := &rbacv1.ClusterRole{ObjectMeta: metav1.ObjectMeta{Name: builder.ObjectName("preset", "readonlycluster")}}
+		editorRole := &rbacv1.Role{ObjectMeta: metav1.ObjectMeta{Namespace: "kube-public", Name: builder.ObjectName("preset", "namespaceeditor")}}
+		explicitRole := &rbacv1.Role{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: builder.ObjectName()}}
+
+		By("creating a role and binding per preset and listing the expanded rules")
+		Eventually(func(g Gomega) {
+			g.Expect(c.Get(ctx, client.ObjectKeyFromObject(clusterRole), clusterRole)).To(Succeed())
+			g.Expect(grantsSecrets(clusterRole.Rules)).To(BeTrue())
+			g.Expect(c.Get(ctx, client.ObjectKeyFromObject(clusterRole), &rbacv1.ClusterRoleBinding{})).To(Succeed())
+			g.Expect(c.Get(ctx, client.ObjectKeyFromObject(editorRole), editorRole)).To(Succeed())
+			g.Expect(c.Get(ctx, client.ObjectKeyFromObject(editorRole), &rbacv1.RoleBinding{})).To(Succeed())
+
+			actual := &v1alpha1.Kubeconfig{}
+			g.Expect(c.Get(ctx, client.ObjectKeyFromObject(kubeconfig), actual)).To(Succeed())
+			g.Expect(actual.Status.Presets).To(HaveLen(2))
+			g.Expect(actual.Status.Presets[0].Name).To(Equal(v1alpha1.PresetReadOnlyCluster))
+			g.Expect(actual.Status.Presets[0].Version).To(Equal(int32(1)))
+			g.Expect(actual.Status.Presets[0].Rules).To(Equal(clusterRole.Rules))
+			g.Expect(actual.Status.Presets[1].Namespace).To(Equal("kube-public"))
+			g.Expect(actual.Status.Presets[1].Rules).To(Equal(editorRole.Rules))
+		}).Should(Succeed())
+
+		By("removing secrets from all rules with secretsDenied")
+		updatedKubeconfig := kubeconfig.DeepCopy()
+		_, err := controllerutil.CreateOrPatch(ctx, c, updatedKubeconfig, func() error {
+			updatedKubeconfig.Spec.Presets = append(updatedKubeconfig.Spec.Presets, v1alpha1.Preset{Name: v1alpha1.PresetSecretsDenied})
+			return nil
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		Eventually(func(g Gomega) {
+			g.Expect(c.Get(ctx, client.ObjectKeyFromObject(clusterRole), clusterRole)).To(Succeed())
+			g.Expect(grantsSecrets(clusterRole.Rules)).To(BeFalse())
+			g.Expect(c.Get(ctx, client.ObjectKeyFromObject(editorRole), editorRole)).To(Succeed())
+			g.Expect(grantsSecrets(editorRole.Rules)).To(BeFalse())
+			g.Expect(c.Get(ctx, client.ObjectKeyFromObject(explicitRole), explicitRole)).To(Succeed())
+			g.Expect(explicitRole.Rules).To(ConsistOf(rbacv1.PolicyRule{
+				APIGroups: []string{""},
+				Resources: []string{"configmaps"},
+				Verbs:     []string{"get"},
+			}))
+
+			actual := &v1alpha1.Kubeconfig{}
+			g.Expect(c.Get(ctx, client.ObjectKeyFromObject(kubeconfig), actual)).To(Succeed())
+			g.Expect(actual.Status.Presets).To(HaveLen(3))
+			g.Expect(grantsSecrets(actual.Status.Presets[0].Rules)).To(BeFalse())
+		}).Should(Succeed())
+
+		By("removing the roles of removed presets")
+		_, err = controllerutil.CreateOrPatch(ctx, c, updatedKubeconfig, func() error {
+			updatedKubeconfig.Spec.Presets = nil
+			return nil
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		Eventually(func(g Gomega) {
+			g.Expect(errors.IsNotFound(c.Get(ctx, client.ObjectKeyFromObject(clusterRole), &rbacv1.ClusterRole{}))).To(BeTrue())
+			g.Expect(errors.IsNotFound(c.Get(ctx, client.ObjectKeyFromObject(clusterRole), &rbacv1.ClusterRoleBinding{}))).To(BeTrue())
+			g.Expect(errors.IsNotFound(c.Get(ctx, client.ObjectKeyFromObject(editorRole), &rbacv1.Role{}))).To(BeTrue())
+
+			actual := &v1alpha1.Kubeconfig{}
+			g.Expect(c.Get(ctx, client.ObjectKeyFromObject(kubeconfig), actual)).To(Succeed())
+			g.Expect(actual.Status.Presets).To(BeEmpty())
+		}).Should(Succeed())
+	})
+
+	It("should report unknown preset versions and unrestrictable rules as a condition", func() {
+		By("requesting an unknown version")
+		updatedKubeconfig := kubeconfig.DeepCopy()
+		_, err := controllerutil.CreateOrPatch(ctx, c, updatedKubeconfig, func() error {
+			updatedKubeconfig.Spec.Presets[0].Version = 99
+			return nil
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		Eventually(func(g Gomega) {
+			actual := &v1alpha1.Kubeconfig{}
+			g.Expect(c.Get(ctx, client.ObjectKeyFromObject(kubeconfig), actual)).To(Succeed())
+			specValid := actual.GetCondition(v1alpha1.TypeSpecValid)
+			g.Expect(specValid.Status).To(Equal(corev1.ConditionFalse))
+			g.Expect(specValid.Reason).To(Equal(api.ConditionReason("InvalidPresets")))
+			g.Expect(specValid.Message).To(ContainSubstring("unknown version 99"))
+		}).Should(Succeed())
+
+		By("denying secrets for rules granting all core resources")
+		_, err = controllerutil.CreateOrPatch(ctx, c, updatedKubeconfig, func() error {
+			updatedKubeconfig.Spec.Presets = []v1alpha1.Preset{{Name: v1alpha1.PresetSecretsDenied}}
+			updatedKubeconfig.Spec.NamespacedPermissions[0].Rules[0].Resources = []string{"*"}
+			return nil
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		Eventually(func(g Gomega) {
+			actual := &v1alpha1.Kubeconfig{}
+			g.Expect(c.Get(ctx, client.ObjectKeyFromObject(kubeconfig), actual)).To(Succeed())
+			specValid := actual.GetCondition(v1alpha1.TypeSpecValid)
+			g.Expect(specValid.Status).To(Equal(corev1.ConditionFalse))
+			g.Expect(specValid.Reason).To(Equal(api.ConditionReason("InvalidPresets")))
+			g.Expect(specValid.Message).To(ContainSubstring("secrets can't be excluded"))
+		}).Should(Succeed())
+	})
+
+	It("should require a namespace for namespaced presets", func() {
+		invalid := &v1alpha1.Kubeconfig{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "presets-invalid",
+				Namespace: "default",
+			},
+			Spec: v1alpha1.KubeconfigSpec{
+				Server:  "https://kubernetes.example.com",
+				Presets: []v1alpha1.Preset{{Name: v1alpha1.PresetNamespaceAdmin}},
+			},
+		}
+		Expect(c.Create(ctx, invalid)).To(MatchError(ContainSubstring("namespace is required for namespaceEditor and namespaceAdmin")))
+	})
+})
+
+var _ = Describe("KubeconfigReconciler namespace selectors", func() {
+	var (
+		ctx        = context.Background()
+		kubeconfig *v1alpha1.Kubeconfig
+		previewA   *corev1.Namespace
+		previewB   *corev1.Namespace
+	)
+
+	BeforeEach(func() {
+		// envtest doesn't run the namespace controller so deleted namespaces are never removed, use unique names
+		previewA = &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+			GenerateName: "preview-a-",
+			Labels:       map[string]string{"env": "preview"},
+		}}
+		previewB = &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+			GenerateName: "preview-b-",
+		}}
+		Expect(c.Create(ctx, previewA)).To(Succeed())
+		Expect(c.Create(ctx, previewB)).To(Succeed())
+
+		kubeconfig = &v1alpha1.Kubeconfig{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "namespace-selector",
+				Namespace: "default",
+			},
+			Spec: v1alpha1.KubeconfigSpec{
+				Server:        "https://kubernetes.example.com",
+				ClusterName:   "kubernetes",
+				ExpirationTTL: "1h",
+				NamespacedPermissions: []v1alpha1.NamespacedPermissions{
+					{
+						NamespaceSelector: &metav1.LabelSelector{
+							MatchLabels: map[string]string{"env": "preview"},
+						},
+						Rules: []rbacv1.PolicyRule{
+							{
+								APIGroups: []string{""},
+								Resources: []string{"pods"},
+								Verbs:     []string{"get", "list"},
+							},
+						},
+					},
+				},
+			},
+		}
+		Expect(c.Create(ctx, kubeconfig)).To(Succeed())
+	})
+
+	AfterEach(func() {
+		deleteKubeconfig(ctx, kubeconfig, previewA, previewB)
+	})
+
+	It("should follow namespaces that start or stop matching the selector", func() {
+		roleIn := func(ns *corev1.Namespace) client.ObjectKey {
+			return client.ObjectKey{Namespace: ns.Name, Name: serviceaccount.NewBuilder(kubeconfig).ObjectName()}
+		}
+
+		By("creating the role in the matching namespace")
+		Eventually(func(g Gomega) {
+			g.Expect(c.Get(ctx, roleIn(previewA), &rbacv1.Role{})).To(Succeed())
+			g.Expect(c.Get(ctx, roleIn(previewA), &rbacv1.RoleBinding{})).To(Succeed())
+			g.Expect(errors.IsNotFound(c.Get(ctx, roleIn(previewB), &rbacv1.Role{}))).To(BeTrue())
+
+			actual := &v1alpha1.Kubeconfig{}
+			g.Expect(c.Get(ctx, client.ObjectKeyFromObject(kubeconfig), actual)).To(Succeed())
+			g.Expect(actual.Status.CoveredNamespaces).To(Equal([]string{previewA.Name}))
+		}).Should(Succeed())
+
+		By("creating the role in a namespace that starts matching")
+		previewB.Labels = map[string]string{"env": "preview"}
+		Expect(c.Update(ctx, previewB)).To(Succeed())
+
+		Eventually(func(g Gomega) {
+			g.Expect(c.Get(ctx, roleIn(previewB), &rbacv1.Role{})).To(Succeed())
+			g.Expect(c.Get(ctx, roleIn(previewB), &rbacv1.RoleBinding{})).To(Succeed())
+
+			actual := &v1alpha1.Kubeconfig{}
+			g.Expect(c.Get(ctx, client.ObjectKeyFromObject(kubeconfig), actual)).To(Succeed())
+			g.Expect(actual.Status.CoveredNamespaces).To(ConsistOf(previewA.Name, previewB.Name))
+		}).Should(Succeed())
+
+		By("removing the role from a namespace that stops matching")
+		previewA.Labels = nil
+		Expect(c.Update(ctx, previewA)).To(Succeed())
+
+		Eventually(func(g Gomega) {
+			g.Expect(errors.IsNotFound(c.Get(ctx, roleIn(previewA), &rbacv1.Role{}))).To(BeTrue())
+			g.Expect(errors.IsNotFound(c.Get(ctx, roleIn(previewA), &rbacv1.RoleBinding{}))).To(BeTrue())
+
+			actual := &v1alpha1.Kubeconfig{}
+			g.Expect(c.Get(ctx, client.ObjectKeyFromObject(kubeconfig), actual)).To(Succeed())
+			g.Expect(actual.Status.CoveredNamespaces).To(Equal([]string{previewB.Name}))
+		}).Should(Succeed())
+	})
+
+	It("should merge entries applying to the same namespace into one role", func() {
+		podRule := kubeconfig.Spec.NamespacedPermissions[0].Rules[0]
+		configMapRule := rbacv1.PolicyRule{
+			APIGroups: []string{""},
+			Resources: []string{"configmaps"},
+			Verbs:     []string{"get"},
+		}
+
+		By("reporting that no entries are merged yet")
+		Eventually(func(g Gomega) {
+			actual := &v1alpha1.Kubeconfig{}
+			g.Expect(c.Get(ctx, client.ObjectKeyFromObject(kubeconfig), actual)).To(Succeed())
+			g.Expect(actual.GetCondition(v1alpha1.TypeNamespacesMerged).Status).To(Equal(corev1.ConditionFalse))
+		}).Should(Succeed())
+
+		By("merging the entries listing a namespace matched by the selector")
+		updatedKubeconfig := kubeconfig.DeepCopy()
+		_, err := controllerutil.CreateOrPatch(ctx, c, updatedKubeconfig, func() error {
+			updatedKubeconfig.Spec.NamespacedPermissions = append(updatedKubeconfig.Spec.NamespacedPermissions,
+				v1alpha1.NamespacedPermissions{Namespace: previewA.Name, Rules: []rbacv1.PolicyRule{configMapRule}},
+				v1alpha1.NamespacedPermissions{Namespace: previewA.Name, Rules: []rbacv1.PolicyRule{podRule}},
+			)
+			return nil
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		Eventually(func(g Gomega) {
+			role := &rbacv1.Role{}
+			g.Expect(c.Get(ctx, client.ObjectKey{Namespace: previewA.Name, Name: serviceaccount.NewBuilder(kubeconfig).ObjectName()}, role)).To(Succeed())
+			g.Expect(role.Rules).To(Equal([]rbacv1.PolicyRule{podRule, configMapRule}))
+
+			actual := &v1alpha1.Kubeconfig{}
+			g.Expect(c.Get(ctx, client.ObjectKeyFromObject(kubeconfig), actual)).To(Succeed())
+			g.Expect(actual.Status.CoveredNamespaces).To(Equal([]string{previewA.Name}))
+
+			condition := actual.GetCondition(v1alpha1.TypeNamespacesMerged)
+			g.Expect(condition.Status).To(Equal(corev1.ConditionTrue))
+			g.Expect(condition.Message).To(ContainSubstring(previewA.Name))
+		}).Should(Succeed())
+	})
+
+	It("should reject permissions with both a namespace and a namespaceSelector", func() {
+		invalid := kubeconfig.DeepCopy()
+		invalid.ObjectMeta = metav1.ObjectMeta{Name: "namespace-selector-invalid", Namespace: "default"}
+		invalid.Spec.NamespacedPermissions[0].Namespace = "default"
+		Expect(c.Create(ctx, invalid)).To(MatchError(ContainSubstring("exactly one of namespace or namespaceSelector is required")))
+	})
+})
+
+// deleteKubeconfig deletes the kubeconfig and waits until its finalizer removed the roles and bindings. envtest doesn't
+// run the garbage collector, so the owned secret and service account are deleted as well to let the next spec start
+// without a token. Additional objects created by the spec are deleted afterward.
+func deleteKubeconfig(ctx context.Context, kubeconfig *v1alpha1.Kubeconfig, objs ...client.Object) {
+	Expect(client.IgnoreNotFound(c.Delete(ctx, kubeconfig))).To(Succeed())
+	Eventually(func(g Gomega) {
+		g.Expect(errors.IsNotFound(c.Get(ctx, client.ObjectKeyFromObject(kubeconfig), &v1alpha1.Kubeconfig{}))).To(BeTrue())
+	}).Should(Succeed())
+
+	for _, obj := range []client.Object{
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: kubeconfig.Namespace, Name: kubeconfig.Name + "-kubeconfig"}},
+		&corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Namespace: kubeconfig.Namespace, Name: kubeconfig.Name}},
+	} {
+		Expect(client.IgnoreNotFound(c.Delete(ctx, obj))).To(Succeed())
+		Eventually(func(g Gomega) {
+			g.Expect(errors.IsNotFound(c.Get(ctx, client.ObjectKeyFromObject(obj), obj))).To(BeTrue())
+		}).Should(Succeed())
+	}
+	for _, obj := range objs {
+		Expect(client.IgnoreNotFound(c.Delete(ctx, obj))).To(Succeed())
+	}
+}
+
+// reviewedToken returns the token of the kubeconfig secret once the controller reviewed it.
+// Waiting for the review ensures the initial reconciles are done before a spec modifies the kubeconfig.
+func reviewedToken(g Gomega, kubeconfig *v1alpha1.Kubeconfig, secretName string) string {
+	actual := &v1alpha1.Kubeconfig{}
+	g.Expect(c.Get(context.Background(), client.ObjectKeyFromObject(kubeconfig), actual)).To(Succeed())
+	g.Expect(actual.GetCondition(v1alpha1.TypeTokenReviewed).Status).To(Equal(corev1.ConditionTrue))
+
+	secret := &corev1.Secret{}
+	g.Expect(c.Get(context.Background(), client.ObjectKey{Namespace: kubeconfig.Namespace, Name: secretName}, secret)).To(Succeed())
+	g.Expect(secret.Data["token"]).NotTo(BeEmpty())
+	return string(secret.Data["token"])
+}
+
+// tokenAuthenticated reports whether the API server accepts the given token.
+func tokenAuthenticated(g Gomega, token string) bool {
+	review := &authenticationv1.TokenReview{
+		Spec: authenticationv1.TokenReviewSpec{
+			Token: token,
+		},
+	}
+	g.Expect(c.Create(ctx, review)).To(Succeed())
+	return review.Status.Authenticated
+}
+
+// unsignedToken returns a JWT with the given "iat" and "exp" claims that is rejected by the API server.
+func unsignedToken(issuedAt, expiresAt time.Time) string {
+	token, err := jwt.NewWithClaims(jwt.SigningMethodNone, jwt.MapClaims{
+		"iat": issuedAt.Unix(),
+		"exp": expiresAt.Unix(),
+	}).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	Expect(err).NotTo(HaveOccurred())
+	return token
+}
+
+// certificateSubject returns the common name of the PEM encoded certificate.
+func certificateSubject(certificate []byte) string {
+	block, _ := pem.Decode(certificate)
+	Expect(block).NotTo(BeNil())
+	cert, err := x509.ParseCertificate(block.Bytes)
+	Expect(err).NotTo(HaveOccurred())
+	return cert.Subject.CommonName
+}
+
+var _ = Describe("KubeconfigReconciler ownership", func() {
+	var ctx = context.Background()
+
+	// create creates a kubeconfig granting read access to pods in the default namespace and removes it after the spec.
+	create := func(namespace, name string) *v1alpha1.Kubeconfig {
+		kubeconfig := &v1alpha1.Kubeconfig{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: namespace,
+			},
+			Spec: v1alpha1.KubeconfigSpec{
+				Server:        "https://kubernetes.example.com",
+				ClusterName:   "kubernetes",
+				ExpirationTTL: "1h",
+				NamespacedPermissions: []v1alpha1.NamespacedPermissions{
+					{
+						Namespace: "default",
+						Rules: []rbacv1.PolicyRule{
+							{
+								APIGroups: []string{""},
+								Resources: []string{"pods"},
+								Verbs:     []string{"get"},
+							},
+						},
+					},
+				},
+			},
+		}
+		Expect(c.Create(ctx, kubeconfig)).To(Succeed())
+		DeferCleanup(func() {
+			deleteKubeconfig(ctx, kubeconfig)
+		})
+		return kubeconfig
+	}
+
+	It("should create distinct roles for kubeconfigs with the same name in different namespaces", func() {
+		first := create("default", "ownership")
+		second := create("kube-public", "ownership")
+
+		firstName := serviceaccount.NewBuilder(first).ObjectName()
+		secondName := serviceaccount.NewBuilder(second).ObjectName()
+		Expect(firstName).NotTo(Equal(secondName))
+
+		Eventually(func(g Gomega) {
+			for _, kubeconfig := range []*v1alpha1.Kubeconfig{first, second} {
+				builder := serviceaccount.NewBuilder(kubeconfig)
+				role := &rbacv1.Role{}
+				g.Expect(c.Get(ctx, client.ObjectKey{Namespace: "default", Name: builder.ObjectName()}, role)).To(Succeed())
+				g.Expect(role.Labels).To(HaveKeyWithValue(serviceaccount.LabelOwnerNamespace, kubeconfig.Namespace))
+				g.Expect(role.Labels).To(HaveKeyWithValue(serviceaccount.LabelOwnerName, kubeconfig.Name))
+
+				binding := &rbacv1.RoleBinding{}
+				g.Expect(c.Get(ctx, client.ObjectKey{Namespace: "default", Name: builder.ObjectName()}, binding)).To(Succeed())
+				g.Expect(binding.Subjects).To(ConsistOf(rbacv1.Subject{
+					Kind:      rbacv1.ServiceAccountKind,
+					Name:      kubeconfig.Name,
+					Namespace: kubeconfig.Namespace,
+				}))
+			}
+		}).Should(Succeed())
+	})
+
+	It("should report a conflict instead of taking over roles owned by another kubeconfig", func() {
+		kubeconfig := &v1alpha1.Kubeconfig{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "ownership-conflict"}}
+		foreign := &rbacv1.Role{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      serviceaccount.NewBuilder(kubeconfig).ObjectName(),
+				Namespace: "default",
+				Labels: map[string]string{
+					serviceaccount.LabelOwnerNamespace: "kube-public",
+					serviceaccount.LabelOwnerName:      "other",
+				},
+			},
+			Rules: []rbacv1.PolicyRule{
+				{
+					APIGroups: []string{""},
+					Resources: []string{"configmaps"},
+					Verbs:     []string{"list"},
+				},
+			},
+		}
+		Expect(c.Create(ctx, foreign)).To(Succeed())
+		DeferCleanup(func() {
+			Expect(client.IgnoreNotFound(c.Delete(ctx, foreign))).To(Succeed())
+		})
+
+		kubeconfig = create(kubeconfig.Namespace, kubeconfig.Name)
+
+		Eventually(func(g Gomega) {
+			actual := &v1alpha1.Kubeconfig{}
+			g.Expect(c.Get(ctx, client.ObjectKeyFromObject(kubeconfig), actual)).To(Succeed())
+			g.Expect(actual.GetCondition(api.TypeReady).Status).To(Equal(corev1.ConditionFalse))
+			ownershipVerified := actual.GetCondition(v1alpha1.TypeOwnershipVerified)
+			g.Expect(ownershipVerified.Status).To(Equal(corev1.ConditionFalse))
+			g.Expect(ownershipVerified.Reason).To(Equal(api.ConditionReason("OwnershipConflict")))
+			g.Expect(ownershipVerified.Message).To(ContainSubstring("Role default/%s is owned by Kubeconfig kube-public/other", foreign.Name))
+		}).Should(Succeed())
+
+		By("not modifying the role of the other kubeconfig")
+		actual := &rbacv1.Role{}
+		Expect(c.Get(ctx, client.ObjectKeyFromObject(foreign), actual)).To(Succeed())
+		Expect(actual.Rules).To(Equal(foreign.Rules))
+		Expect(actual.Labels).To(Equal(foreign.Labels))
+		Expect(errors.IsNotFound(c.Get(ctx, client.ObjectKeyFromObject(foreign), &rbacv1.RoleBinding{}))).To(BeTrue())
+
+		By("provisioning the permissions once the conflict is resolved")
+		Expect(c.Delete(ctx, foreign)).To(Succeed())
+		Eventually(func(g Gomega) {
+			actual := &v1alpha1.Kubeconfig{}
+			g.Expect(c.Get(ctx, client.ObjectKeyFromObject(kubeconfig), actual)).To(Succeed())
+			g.Expect(actual.GetCondition(v1alpha1.TypeOwnershipVerified).Status).To(Equal(corev1.ConditionTrue))
+			g.Expect(c.Get(ctx, client.ObjectKeyFromObject(foreign), &rbacv1.RoleBinding{})).To(Succeed())
+		}).Should(Succeed())
+	})
+
+	It("should only adopt roles that weren't created by the operator if adoptExisting is set", func() {
+		kubeconfig := &v1alpha1.Kubeconfig{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "ownership-adopt"}}
+		existing := &rbacv1.Role{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      serviceaccount.NewBuilder(kubeconfig).ObjectName(),
+				Namespace: "default",
+			},
+			Rules: []rbacv1.PolicyRule{
+				{
+					APIGroups: []string{""},
+					Resources: []string{"configmaps"},
+					Verbs:     []string{"list"},
+				},
+			},
+		}
+		Expect(c.Create(ctx, existing)).To(Succeed())
+		DeferCleanup(func() {
+			Expect(client.IgnoreNotFound(c.Delete(ctx, existing))).To(Succeed())
+		})
+
+		kubeconfig = create(kubeconfig.Namespace, kubeconfig.Name)
+
+		Eventually(func(g Gomega) {
+			actual := &v1alpha1.Kubeconfig{}
+			g.Expect(c.Get(ctx, client.ObjectKeyFromObject(kubeconfig), actual)).To(Succeed())
+			ownershipVerified := actual.GetCondition(v1alpha1.TypeOwnershipVerified)
+			g.Expect(ownershipVerified.Status).To(Equal(corev1.ConditionFalse))
+			g.Expect(ownershipVerified.Reason).To(Equal(api.ConditionReason("OwnershipConflict")))
+			g.Expect(ownershipVerified.Message).To(ContainSubstring("Role default/%s wasn't created by the operator", existing.Name))
+		}).Should(Succeed())
+
+		actual := &rbacv1.Role{}
+		Expect(c.Get(ctx, client.ObjectKeyFromObject(existing), actual)).To(Succeed())
+		Expect(actual.Rules).To(Equal(existing.Rules))
+
+		By("adopting the role once adoptExisting is set")
+		updatedKubeconfig := kubeconfig.DeepCopy()
+		_, err := controllerutil.CreateOrPatch(ctx, c, updatedKubeconfig, func() error {
+			updatedKubeconfig.Spec.AdoptExisting = true
+			return nil
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		Eventually(func(g Gomega) {
+			g.Expect(c.Get(ctx, client.ObjectKeyFromObject(existing), actual)).To(Succeed())
+			g.Expect(actual.Rules).To(Equal(kubeconfig.Spec.NamespacedPermissions[0].Rules))
+			g.Expect(actual.Labels).To(HaveKeyWithValue(serviceaccount.LabelOwnerName, kubeconfig.Name))
+
+			actual := &v1alpha1.Kubeconfig{}
+			g.Expect(c.Get(ctx, client.ObjectKeyFromObject(kubeconfig), actual)).To(Succeed())
+			g.Expect(actual.GetCondition(v1alpha1.TypeOwnershipVerified).Status).To(Equal(corev1.ConditionTrue))
+		}).Should(Succeed())
+	})
+
+	It("should never adopt an existing service account", func() {
+		existing := &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "ownership-sa"}}
+		Expect(c.Create(ctx, existing)).To(Succeed())
+		DeferCleanup(func() {
+			Expect(client.IgnoreNotFound(c.Delete(ctx, existing))).To(Succeed())
+		})
+
+		kubeconfig := create(existing.Namespace, existing.Name)
+		updatedKubeconfig := kubeconfig.DeepCopy()
+		_, err := controllerutil.CreateOrPatch(ctx, c, updatedKubeconfig, func() error {
+			updatedKubeconfig.Spec.AdoptExisting = true
+			return nil
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		Eventually(func(g Gomega) {
+			actual := &v1alpha1.Kubeconfig{}
+			g.Expect(c.Get(ctx, client.ObjectKeyFromObject(kubeconfig), actual)).To(Succeed())
+			g.Expect(actual.Spec.AdoptExisting).To(BeTrue())
+			ownershipVerified := actual.GetCondition(v1alpha1.TypeOwnershipVerified)
+			g.Expect(ownershipVerified.Status).To(Equal(corev1.ConditionFalse))
+			g.Expect(ownershipVerified.ObservedGeneration).To(Equal(actual.Generation))
+			g.Expect(ownershipVerified.Message).To(ContainSubstring("ServiceAccount default/%s wasn't created by the operator", existing.Name))
+		}).Should(Succeed())
+
+		By("neither issuing a token for it nor deleting it with the kubeconfig")
+		Expect(errors.IsNotFound(c.Get(ctx, client.ObjectKey{Namespace: "default", Name: kubeconfig.Name + "-kubeconfig"}, &corev1.Secret{}))).To(BeTrue())
+		Expect(c.Delete(ctx, kubeconfig)).To(Succeed())
+		Eventually(func(g Gomega) {
+			g.Expect(errors.IsNotFound(c.Get(ctx, client.ObjectKeyFromObject(kubeconfig), &v1alpha1.Kubeconfig{}))).To(BeTrue())
+		}).Should(Succeed())
+		actual := &corev1.ServiceAccount{}
+		Expect(c.Get(ctx, client.ObjectKeyFromObject(existing), actual)).To(Succeed())
+		Expect(actual.Labels).NotTo(HaveKey(serviceaccount.LabelOwnerName))
+	})
+
+	It("should not delete stale roles owned by another kubeconfig", func() {
+		kubeconfig := create("default", "ownership-stale")
+		role := &rbacv1.Role{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: serviceaccount.NewBuilder(kubeconfig).ObjectName()}}
+		Eventually(func(g Gomega) {
+			g.Expect(c.Get(ctx, client.ObjectKeyFromObject(role), role)).To(Succeed())
+		}).Should(Succeed())
+		DeferCleanup(func() {
+			Expect(client.IgnoreNotFound(c.Delete(ctx, role))).To(Succeed())
+		})
+
+		By("handing the role over to another kubeconfig and removing the permissions")
+		// a reconcile in progress may restore the labels, so they're set until the conflict is reported
+		Eventually(func(g Gomega) {
+			g.Expect(c.Get(ctx, client.ObjectKeyFromObject(role), role)).To(Succeed())
+			if role.Labels[serviceaccount.LabelOwnerNamespace] != "kube-public" {
+				role.Labels[serviceaccount.LabelOwnerNamespace] = "kube-public"
+				g.Expect(c.Update(ctx, role)).To(Succeed())
+			}
+
+			actual := &v1alpha1.Kubeconfig{}
+			g.Expect(c.Get(ctx, client.ObjectKeyFromObject(kubeconfig), actual)).To(Succeed())
+			g.Expect(actual.GetCondition(v1alpha1.TypeOwnershipVerified).Status).To(Equal(corev1.ConditionFalse))
+		}).Should(Succeed())
+
+		updatedKubeconfig := kubeconfig.DeepCopy()
+		_, err := controllerutil.CreateOrPatch(ctx, c, updatedKubeconfig, func() error {
+			updatedKubeconfig.Spec.NamespacedPermissions = nil
+			return nil
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		Eventually(func(g Gomega) {
+			g.Expect(errors.IsNotFound(c.Get(ctx, client.ObjectKeyFromObject(role), &rbacv1.RoleBinding{}))).To(BeTrue())
+		}).Should(Succeed())
+		Consistently(func(g Gomega) {
+			g.Expect(c.Get(ctx, client.ObjectKeyFromObject(role), &rbacv1.Role{})).To(Succeed())
+		}, "2s").Should(Succeed())
+	})
+})
+
+var _ = Describe("KubeconfigReconciler effective permissions", func() {
+	var (
+		ctx        = context.Background()
+		kubeconfig *v1alpha1.Kubeconfig
+		podRule    = rbacv1.PolicyRule{
+			APIGroups: []string{""},
+			Resources: []string{"pods"},
+			Verbs:     []string{"get"},
+		}
+		namespaceRule = rbacv1.PolicyRule{
+			APIGroups: []string{""},
+			Resources: []string{"namespaces"},
+			Verbs:     []string{"list"},
+		}
+		configMapRule = rbacv1.PolicyRule{
+			APIGroups: []string{""},
+			Resources: []string{"configmaps"},
+			Verbs:     []string{"watch"},
+		}
+	)
+
+	BeforeEach(func() {
+		By("granting all service accounts access to config maps outside of the operator")
+		role := &rbacv1.Role{
+			ObjectMeta: metav1.ObjectMeta{Name: "configmap-watcher", Namespace: "kube-public"},
+			Rules:      []rbacv1.PolicyRule{configMapRule},
+		}
+		binding := &rbacv1.RoleBinding{
+			ObjectMeta: metav1.ObjectMeta{Name: "configmap-watcher", Namespace: "kube-public"},
+			Subjects:   []rbacv1.Subject{{Kind: rbacv1.GroupKind, APIGroup: rbacv1.GroupName, Name: "system:serviceaccounts"}},
+			RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "Role", Name: role.Name},
+		}
+		for _, obj := range []client.Object{role, binding} {
+			Expect(c.Create(ctx, obj)).To(Succeed())
+			DeferCleanup(func() {
+				Expect(client.IgnoreNotFound(c.Delete(ctx, obj))).To(Succeed())
+			})
+		}
+
+		kubeconfig = &v1alpha1.Kubeconfig{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "effective-permissions",
+				Namespace: "default",
+			},
+			Spec: v1alpha1.KubeconfigSpec{
+				Server:        "https://kubernetes.example.com",
+				ClusterName:   "kubernetes",
+				ExpirationTTL: "1h",
+				NamespacedPermissions: []v1alpha1.NamespacedPermissions{
+					{Namespace: "kube-public", Rules: []rbacv1.PolicyRule{podRule}},
+				},
+				ClusterPermissions: &v1alpha1.ClusterPermissions{
+					Rules: []rbacv1.PolicyRule{namespaceRule},
+				},
+			},
+		}
+		Expect(c.Create(ctx, kubeconfig)).To(Succeed())
+	})
+
+	AfterEach(func() {
+		deleteKubeconfig(ctx, kubeconfig)
+	})
+
+	It("should list the rules granted cluster-wide and per namespace including other bindings", func() {
+		Eventually(func(g Gomega) {
+			actual := &v1alpha1.Kubeconfig{}
+			g.Expect(c.Get(ctx, client.ObjectKeyFromObject(kubeconfig), actual)).To(Succeed())
+			g.Expect(actual.GetCondition(v1alpha1.TypePermissionsReviewed).Status).To(Equal(corev1.ConditionTrue))
+			g.Expect(actual.Status.EffectivePermissions).To(HaveLen(2))
+
+			cluster := actual.Status.EffectivePermissions[0]
+			g.Expect(cluster.Namespace).To(BeEmpty())
+			g.Expect(cluster.Rules).To(ContainElement(namespaceRule))
+			g.Expect(cluster.Rules).NotTo(ContainElements(podRule, configMapRule))
+
+			namespaced := actual.Status.EffectivePermissions[1]
+			g.Expect(namespaced.Namespace).To(Equal("kube-public"))
+			g.Expect(namespaced.Rules).To(ConsistOf(podRule, configMapRule))
+		}).Should(Succeed())
+
+		By("removing rules of deleted bindings")
+		Expect(c.Delete(ctx, &rbacv1.RoleBinding{ObjectMeta: metav1.ObjectMeta{Name: "configmap-watcher", Namespace: "kube-public"}})).To(Succeed())
+		updatedKubeconfig := kubeconfig.DeepCopy()
+		_, err := controllerutil.CreateOrPatch(ctx, c, updatedKubeconfig, func() error {
+			updatedKubeconfig.Spec.ClusterPermissions = nil
+			return nil
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		Eventually(func(g Gomega) {
+			actual := &v1alpha1.Kubeconfig{}
+			g.Expect(c.Get(ctx, client.ObjectKeyFromObject(kubeconfig), actual)).To(Succeed())
+			g.Expect(actual.Status.EffectivePermissions).To(HaveLen(2))
+			g.Expect(actual.Status.EffectivePermissions[0].Rules).NotTo(ContainElement(namespaceRule))
+			g.Expect(actual.Status.EffectivePermissions[1].Rules).To(ConsistOf(podRule))
+		}).Should(Succeed())
+	})
+	It("should report a failed review and still provision the kubeconfig", func() {
+		user := serviceaccount.NewBuilder(kubeconfig).User()
+		permissionsReviewer.Fail(user, goerrors.New("authorizer unavailable"))
+		DeferCleanup(func() { permissionsReviewer.Fail(user, nil) })
+
+		updatedKubeconfig := kubeconfig.DeepCopy()
+		_, err := controllerutil.CreateOrPatch(ctx, c, updatedKubeconfig, func() error {
+			updatedKubeconfig.Spec.ExpirationTTL = "2h"
+			return nil
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		Eventually(func(g Gomega) {
+			actual := &v1alpha1.Kubeconfig{}
+			g.Expect(c.Get(ctx, client.ObjectKeyFromObject(kubeconfig), actual)).To(Succeed())
+			reviewed := actual.GetCondition(v1alpha1.TypePermissionsReviewed)
+			g.Expect(reviewed.Status).To(Equal(corev1.ConditionFalse))
+			g.Expect(reviewed.ObservedGeneration).To(Equal(actual.Generation))
+			g.Expect(reviewed.Reason).To(Equal(api.ConditionReason("ReviewFailed")))
+			g.Expect(reviewed.Message).To(ContainSubstring("authorizer unavailable"))
+
+			provisioned := actual.GetCondition(v1alpha1.TypeKubeconfigProvisioned)
+			g.Expect(provisioned.Status).To(Equal(corev1.ConditionTrue))
+			g.Expect(provisioned.ObservedGeneration).To(Equal(actual.Generation))
+			g.Expect(actual.Status.ServiceAccountTokenRequestedTTL.Duration).To(Equal(2 * time.Hour))
+		}).Should(Succeed())
+
+		By("reporting the review once it succeeds again")
+		permissionsReviewer.Fail(user, nil)
+		_, err = controllerutil.CreateOrPatch(ctx, c, updatedKubeconfig, func() error {
+			updatedKubeconfig.Spec.ExpirationTTL = "3h"
+			return nil
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		Eventually(func(g Gomega) {
+			actual := &v1alpha1.Kubeconfig{}
+			g.Expect(c.Get(ctx, client.ObjectKeyFromObject(kubeconfig), actual)).To(Succeed())
+			g.Expect(actual.GetCondition(v1alpha1.TypePermissionsReviewed).Status).To(Equal(corev1.ConditionTrue))
+			g.Expect(actual.Status.EffectivePermissions).To(HaveLen(2))
+		}).Should(Succeed())
+	})
+})
+
+var _ = Describe("KubeconfigReconciler impersonation", func() {
+	var (
+		ctx           = context.Background()
+		kubeconfig    *v1alpha1.Kubeconfig
+		namespaceRule = rbacv1.PolicyRule{
+			APIGroups: []string{""},
+			Resources: []string{"namespaces"},
+			Verbs:     []string{"list"},
+		}
+	)
+
+	BeforeEach(func() {
+		By("allowing the impersonated group to list namespaces")
+		clusterRole := &rbacv1.ClusterRole{
+			ObjectMeta: metav1.ObjectMeta{Name: "impersonation-namespace-lister"},
+			Rules:      []rbacv1.PolicyRule{namespaceRule},
+		}
+		binding := &rbacv1.ClusterRoleBinding{
+			ObjectMeta: metav1.ObjectMeta{Name: "impersonation-namespace-lister"},
+			Subjects:   []rbacv1.Subject{{Kind: rbacv1.GroupKind, APIGroup: rbacv1.GroupName, Name: "developers"}},
+			RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: clusterRole.Name},
+		}
+		for _, obj := range []client.Object{clusterRole, binding} {
+			Expect(c.Create(ctx, obj)).To(Succeed())
+			DeferCleanup(func() {
+				Expect(client.IgnoreNotFound(c.Delete(ctx, obj))).To(Succeed())
+			})
+		}
+
+		kubeconfig = &v1alpha1.Kubeconfig{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "impersonation",
+				Namespace: "default",
+			},
+			Spec: v1alpha1.KubeconfigSpec{
+				Server:        "https://kubernetes.example.com",
+				ClusterName:   "kubernetes",
+				ExpirationTTL: "1h",
+				Impersonate: &v1alpha1.Impersonation{
+					User:   "jane",
+					Groups: []string{"developers"},
+					Extra:  map[string][]string{"scopes": {"deploy"}},
+				},
+			},
+		}
+		Expect(c.Create(ctx, kubeconfig)).To(Succeed())
+	})
+
+	AfterEach(func() {
+		deleteKubeconfig(ctx, kubeconfig)
+	})
+
+	It("should only allow impersonating the given identity and act as it", func() {
+		builder := serviceaccount.NewBuilder(kubeconfig)
+		clusterRole := &rbacv1.ClusterRole{ObjectMeta: metav1.ObjectMeta{Name: builder.ObjectName("impersonate")}}
+
+		By("granting the permission to impersonate exactly the identity")
+		Eventually(func(g Gomega) {
+			g.Expect(c.Get(ctx, client.ObjectKeyFromObject(clusterRole), clusterRole)).To(Succeed())
+			g.Expect(clusterRole.Rules).To(Equal([]rbacv1.PolicyRule{
+				{APIGroups: []string{""}, Resources: []string{"users"}, ResourceNames: []string{"jane"}, Verbs: []string{"impersonate"}},
+				{APIGroups: []string{""}, Resources: []string{"groups"}, ResourceNames: []string{"developers"}, Verbs: []string{"impersonate"}},
+				{APIGroups: []string{"authentication.k8s.io"}, Resources: []string{"userextras/scopes"}, ResourceNames: []string{"deploy"}, Verbs: []string{"impersonate"}},
+			}))
+			g.Expect(c.Get(ctx, client.ObjectKeyFromObject(clusterRole), &rbacv1.ClusterRoleBinding{})).To(Succeed())
+		}).Should(Succeed())
+
+		By("impersonating the identity in the kubeconfig")
+		secret := &corev1.Secret{}
+		Eventually(func(g Gomega) {
+			g.Expect(c.Get(ctx, client.ObjectKey{Namespace: kubeconfig.Namespace, Name: kubeconfig.Name + "-kubeconfig"}, secret)).To(Succeed())
+		}).Should(Succeed())
+		cfg, err := clientcmd.Load(secret.Data["kubeconfig"])
+		Expect(err).NotTo(HaveOccurred())
+		authInfo := cfg.AuthInfos[kubeconfig.Name]
+		Expect(authInfo.Impersonate).To(Equal("jane"))
+		Expect(authInfo.ImpersonateGroups).To(Equal([]string{"developers"}))
+		Expect(authInfo.ImpersonateUserExtra).To(Equal(map[string][]string{"scopes": {"deploy"}}))
+
+		By("acting with the permissions of the impersonated group")
+		restConfig, err := clientcmd.NewDefaultClientConfig(*cfg, &clientcmd.ConfigOverrides{
+			ClusterInfo: clientcmdapi.Cluster{Server: testEnv.Cfg.Host, CertificateAuthorityData: testEnv.Cfg.CAData},
+		}).ClientConfig()
+		Expect(err).NotTo(HaveOccurred())
+		clientset, err := kubernetes.NewForConfig(restConfig)
+		Expect(err).NotTo(HaveOccurred())
+		Eventually(func() error {
+			_, err := clientset.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
+			return err
+		}).Should(Succeed())
+
+		Eventually(func(g Gomega) {
+			actual := &v1alpha1.Kubeconfig{}
+			g.Expect(c.Get(ctx, client.ObjectKeyFromObject(kubeconfig), actual)).To(Succeed())
+			g.Expect(actual.Status.EffectivePermissions).NotTo(BeEmpty())
+			g.Expect(actual.Status.EffectivePermissions[0].Rules).To(ContainElement(namespaceRule))
+		}).Should(Succeed())
+
+		By("impersonating service accounts only in their namespace")
+		updatedKubeconfig := kubeconfig.DeepCopy()
+		_, err = controllerutil.CreateOrPatch(ctx, c, updatedKubeconfig, func() error {
+			updatedKubeconfig.Spec.Impersonate = &v1alpha1.Impersonation{User: "system:serviceaccount:kube-public:deployer"}
+			return nil
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		role := &rbacv1.Role{ObjectMeta: metav1.ObjectMeta{Namespace: "kube-public", Name: builder.ObjectName("impersonate")}}
+		Eventually(func(g Gomega) {
+			g.Expect(c.Get(ctx, client.ObjectKeyFromObject(role), role)).To(Succeed())
+			g.Expect(role.Rules).To(Equal([]rbacv1.PolicyRule{
+				{APIGroups: []string{""}, Resources: []string{"serviceaccounts"}, ResourceNames: []string{"deployer"}, Verbs: []string{"impersonate"}},
+			}))
+			g.Expect(errors.IsNotFound(c.Get(ctx, client.ObjectKeyFromObject(clusterRole), &rbacv1.ClusterRole{}))).To(BeTrue())
+		}).Should(Succeed())
+	})
+})
+
+var _ = Describe("KubeconfigReconciler API discovery", func() {
+	var (
+		ctx        = context.Background()
+		kubeconfig *v1alpha1.Kubeconfig
+	)
+
+	BeforeEach(func() {
+		kubeconfig = &v1alpha1.Kubeconfig{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "discovery",
+				Namespace: "default",
+			},
+			Spec: v1alpha1.KubeconfigSpec{
+				Server:        "https://kubernetes.example.com",
+				ClusterName:   "kubernetes",
+				ExpirationTTL: "1h",
+				NamespacedPermissions: []v1alpha1.NamespacedPermissions{
+					{
+						Namespace: "default",
+						Rules: []rbacv1.PolicyRule{
+							{APIGroups: []string{""}, Resources: []string{"*"}, Verbs: []string{"get"}},
+							{APIGroups: []string{"apps"}, Resources: []string{"deploymnets"}, Verbs: []string{"get"}},
+							{APIGroups: []string{"test.klaud.works"}, Resources: []string{"widgets"}, Verbs: []string{"get"}},
+						},
+						ExcludeResources: []string{"secrets"},
+					},
+				},
+				ClusterPermissions: &v1alpha1.ClusterPermissions{
+					Rules: []rbacv1.PolicyRule{
+						{APIGroups: []string{"*"}, Resources: []string{"*"}, Verbs: []string{"list"}},
+					},
+					ExcludeResources: []string{"secrets"},
+				},
+			},
+		}
+		Expect(c.Create(ctx, kubeconfig)).To(Succeed())
+	})
+
+	AfterEach(func() {
+		deleteKubeconfig(ctx, kubeconfig)
+	})
+
+	It("should expand wildcards except excluded resources and grant new resources only if they are included", func() {
+		builder := serviceaccount.NewBuilder(kubeconfig)
+		role := &rbacv1.Role{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: builder.ObjectName()}}
+		clusterRole := &rbacv1.ClusterRole{ObjectMeta: metav1.ObjectMeta{Name: builder.ObjectName()}}
+		widgetsRule := rbacv1.PolicyRule{APIGroups: []string{"test.klaud.works"}, Resources: []string{"widgets"}, Verbs: []string{"list"}}
+
+		By("expanding the wildcards into the served resources except secrets")
+		Eventually(func(g Gomega) {
+			g.Expect(c.Get(ctx, client.ObjectKeyFromObject(role), role)).To(Succeed())
+			g.Expect(role.Rules).NotTo(BeEmpty())
+			g.Expect(role.Rules[0].APIGroups).To(Equal([]string{""}))
+			g.Expect(role.Rules[0].Resources).To(ContainElements("pods", "pods/log", "configmaps"))
+			g.Expect(role.Rules[0].Resources).NotTo(ContainElement("secrets"))
+
+			g.Expect(c.Get(ctx, client.ObjectKeyFromObject(clusterRole), clusterRole)).To(Succeed())
+			g.Expect(clusterRole.Rules).To(ContainElement(HaveField("APIGroups", []string{"apps"})))
+			g.Expect(clusterRole.Rules).NotTo(ContainElement(widgetsRule))
+			for _, rule := range clusterRole.Rules {
+				g.Expect(rule.Resources).NotTo(ContainElement("secrets"))
+			}
+		}).Should(Succeed())
+
+		By("reporting the unknown API group and resource")
+		Eventually(func(g Gomega) {
+			actual := &v1alpha1.Kubeconfig{}
+			g.Expect(c.Get(ctx, client.ObjectKeyFromObject(kubeconfig), actual)).To(Succeed())
+			condition := actual.GetCondition(v1alpha1.TypeUnknownResources)
+			g.Expect(condition.Status).To(Equal(corev1.ConditionTrue))
+			g.Expect(condition.Message).To(ContainSubstring(`unknown resource "deploymnets.apps"`))
+			g.Expect(condition.Message).To(ContainSubstring(`unknown API group "test.klaud.works"`))
+		}).Should(Succeed())
+
+		By("recording the resources the wildcards were expanded into")
+		Eventually(func(g Gomega) {
+			actual := &v1alpha1.Kubeconfig{}
+			g.Expect(c.Get(ctx, client.ObjectKeyFromObject(kubeconfig), actual)).To(Succeed())
+			g.Expect(actual.Status.ExpandedResources).NotTo(BeNil())
+			g.Expect(actual.Status.ExpandedResources.Resources).To(ContainElements("pods", "secrets", "deployments.apps"))
+		}).Should(Succeed())
+
+		By("keeping the expansion once the CRD is installed")
+		crd := &unstructured.Unstructured{Object: map[string]any{
+			"apiVersion": "apiextensions.k8s.io/v1",
+			"kind":       "CustomResourceDefinition",
+			"metadata":   map[string]any{"name": "widgets.test.klaud.works"},
+			"spec": map[string]any{
+				"group": "test.klaud.works",
+				"scope": "Namespaced",
+				"names": map[string]any{"plural": "widgets", "singular": "widget", "kind": "Widget", "listKind": "WidgetList"},
+				"versions": []any{
+					map[string]any{
+						"name":    "v1",
+						"served":  true,
+						"storage": true,
+						"schema": map[string]any{
+							"openAPIV3Schema": map[string]any{"type": "object", "x-kubernetes-preserve-unknown-fields": true},
+						},
+					},
+				},
+			},
+		}}
+		Expect(c.Create(ctx, crd)).To(Succeed())
+		DeferCleanup(func() {
+			Expect(client.IgnoreNotFound(c.Delete(ctx, crd))).To(Succeed())
+		})
+
+		Eventually(func(g Gomega) {
+			actual := &v1alpha1.Kubeconfig{}
+			g.Expect(c.Get(ctx, client.ObjectKeyFromObject(kubeconfig), actual)).To(Succeed())
+			condition := actual.GetCondition(v1alpha1.TypeUnknownResources)
+			g.Expect(condition.Status).To(Equal(corev1.ConditionTrue))
+			g.Expect(condition.Message).To(ContainSubstring(`unknown resource "deploymnets.apps"`))
+			g.Expect(condition.Message).NotTo(ContainSubstring("test.klaud.works"))
+		}).Should(Succeed())
+		Expect(c.Get(ctx, client.ObjectKeyFromObject(clusterRole), clusterRole)).To(Succeed())
+		Expect(clusterRole.Rules).NotTo(ContainElement(widgetsRule))
+
+		By("recomputing the expansion once new resources are included")
+		updatedKubeconfig := kubeconfig.DeepCopy()
+		_, err := controllerutil.CreateOrPatch(ctx, c, updatedKubeconfig, func() error {
+			updatedKubeconfig.Spec.ClusterPermissions.IncludeNewResources = true
+			return nil
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		Eventually(func(g Gomega) {
+			g.Expect(c.Get(ctx, client.ObjectKeyFromObject(clusterRole), clusterRole)).To(Succeed())
+			g.Expect(clusterRole.Rules).To(ContainElement(widgetsRule))
+			for _, rule := range clusterRole.Rules {
+				g.Expect(rule.Resources).NotTo(ContainElement("secrets"))
+			}
+		}).Should(Succeed())
+	})
+})
+
+var _ = Describe("KubeconfigReconciler scheduled permissions", func() {
+	var (
+		ctx        = context.Background()
+		kubeconfig *v1alpha1.Kubeconfig
+		notBefore  metav1.Time
+		validUntil metav1.Time
+	)
+
+	BeforeEach(func() {
+		now := time.Now().Truncate(time.Second)
+		notBefore = metav1.NewTime(now.Add(15 * time.Second))
+		validUntil = metav1.NewTime(now.Add(25 * time.Second))
+
+		kubeconfig = &v1alpha1.Kubeconfig{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "scheduled",
+				Namespace: "default",
+			},
+			Spec: v1alpha1.KubeconfigSpec{
+				Server:        "https://kubernetes.example.com",
+				ClusterName:   "kubernetes",
+				ExpirationTTL: "1h",
+				NamespacedPermissions: []v1alpha1.NamespacedPermissions{
+					{
+						Namespace: "default",
+						Rules:     []rbacv1.PolicyRule{{APIGroups: []string{""}, Resources: []string{"pods"}, Verbs: []string{"get"}}},
+					},
+					{
+						Namespace:  "kube-public",
+						Rules:      []rbacv1.PolicyRule{{APIGroups: []string{""}, Resources: []string{"configmaps"}, Verbs: []string{"*"}}},
+						NotBefore:  &notBefore,
+						ValidUntil: &validUntil,
+					},
+				},
+				ClusterPermissions: &v1alpha1.ClusterPermissions{
+					Rules:      []rbacv1.PolicyRule{{APIGroups: []string{""}, Resources: []string{"namespaces"}, Verbs: []string{"list"}}},
+					ValidUntil: &validUntil,
+				},
+			},
+		}
+	})
+
+	AfterEach(func() {
+		deleteKubeconfig(ctx, kubeconfig)
+	})
+
+	It("should reject permissions that end before they start", func() {
+		invalid := kubeconfig.DeepCopy()
+		invalid.Spec.NamespacedPermissions[1].NotBefore, invalid.Spec.NamespacedPermissions[1].ValidUntil = &validUntil, &notBefore
+
+		err := c.Create(ctx, invalid)
+		Expect(errors.IsInvalid(err)).To(BeTrue(), "unexpected error: %v", err)
+		Expect(err).To(MatchError(ContainSubstring("validUntil must be after notBefore")))
+	})
+
+	It("should grant and remove permissions at their boundaries without reissuing the token", func() {
+		Expect(c.Create(ctx, kubeconfig)).To(Succeed())
+
+		builder := serviceaccount.NewBuilder(kubeconfig)
+		defaultRole := &rbacv1.Role{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: builder.ObjectName()}}
+		temporaryRole := &rbacv1.Role{ObjectMeta: metav1.ObjectMeta{Namespace: "kube-public", Name: builder.ObjectName()}}
+		clusterRole := &rbacv1.ClusterRole{ObjectMeta: metav1.ObjectMeta{Name: builder.ObjectName()}}
+		secret := &corev1.Secret{}
+
+		scheduledPermissions := func(g Gomega) []v1alpha1.ScheduledPermissions {
+			actual := &v1alpha1.Kubeconfig{}
+			g.Expect(c.Get(ctx, client.ObjectKeyFromObject(kubeconfig), actual)).To(Succeed())
+			return actual.Status.ScheduledPermissions
+		}
+
+		By("listing the upcoming permissions before they start")
+		Eventually(func(g Gomega) {
+			g.Expect(c.Get(ctx, client.ObjectKeyFromObject(defaultRole), defaultRole)).To(Succeed())
+			g.Expect(c.Get(ctx, client.ObjectKeyFromObject(clusterRole), clusterRole)).To(Succeed())
+			g.Expect(c.Get(ctx, client.ObjectKey{Namespace: kubeconfig.Namespace, Name: kubeconfig.Name + "-kubeconfig"}, secret)).To(Succeed())
+			g.Expect(scheduledPermissions(g)).To(ConsistOf(
+				v1alpha1.ScheduledPermissions{
+					Permissions: "namespacedPermissions[1]",
+					Namespace:   "kube-public",
+					NotBefore:   &notBefore,
+					ValidUntil:  &validUntil,
+				},
+				v1alpha1.ScheduledPermissions{
+					Permissions: "clusterPermissions",
+					ValidUntil:  &validUntil,
+					Active:      true,
+				},
+			))
+		}).Should(Succeed())
+		Expect(errors.IsNotFound(c.Get(ctx, client.ObjectKeyFromObject(temporaryRole), temporaryRole))).To(BeTrue())
+		token := secret.Data["token"]
+
+		By("granting the permissions once they start")
+		Eventually(func(g Gomega) {
+			g.Expect(c.Get(ctx, client.ObjectKeyFromObject(temporaryRole), temporaryRole)).To(Succeed())
+			g.Expect(scheduledPermissions(g)).To(ContainElement(HaveField("Active", true)))
+			g.Expect(scheduledPermissions(g)).NotTo(ContainElement(HaveField("Active", false)))
+		}, "30s").Should(Succeed())
+
+		By("removing the permissions once they end")
+		Eventually(func(g Gomega) {
+			g.Expect(errors.IsNotFound(c.Get(ctx, client.ObjectKeyFromObject(temporaryRole), &rbacv1.Role{}))).To(BeTrue())
+			g.Expect(errors.IsNotFound(c.Get(ctx, client.ObjectKeyFromObject(clusterRole), &rbacv1.ClusterRole{}))).To(BeTrue())
+			g.Expect(scheduledPermissions(g)).To(BeEmpty())
+		}, "30s").Should(Succeed())
+		Expect(c.Get(ctx, client.ObjectKeyFromObject(defaultRole), defaultRole)).To(Succeed())
+
+		Expect(c.Get(ctx, client.ObjectKeyFromObject(secret), secret)).To(Succeed())
+		Expect(secret.Data["token"]).To(Equal(token))
+	})
+})
@@ -12,12 +12,24 @@ import (
 	"github.com/klaudworks/kubeconfig-operator/api/klaud.works/v1alpha1"
 )
 
+const (
+	// TokenKey is the secret key of the service account token.
+	TokenKey = "token"
+	// PreviousTokenKey is the secret key of the token that was replaced by the latest rotation.
+	PreviousTokenKey = "token.previous"
+)
+
 type BuildConfig struct {
 	Kubeconfig         *v1alpha1.Kubeconfig
 	Namespace          string
 	ServiceAccountName string
 	Token              string
-	CACrtData          []byte
+	// PreviousToken is published next to the token if set.
+	PreviousToken string
+	// ClientCertificate and ClientKey are published instead of the token if set.
+	ClientCertificate []byte
+	ClientKey         []byte
+	CACrtData         []byte
 }
 
 func Build(config BuildConfig) (*corev1.Secret, error) {
@@ -35,20 +47,42 @@ func Build(config BuildConfig) (*corev1.Secret, error) {
 
 	secret := &corev1.Secret{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      config.Kubeconfig.GetName() + "-kubeconfig",
+			Name:      SecretName(config.Kubeconfig),
 			Namespace: config.Kubeconfig.GetNamespace(),
 		},
 		Data: map[string][]byte{
 			"kubeconfig": kubeconfigYaml,
-			"token":      []byte(config.Token),
 			"ca.crt":     config.CACrtData,
 		},
 		Type: corev1.SecretTypeOpaque,
 	}
+	if len(config.ClientCertificate) > 0 {
+		secret.Data[corev1.TLSCertKey] = config.ClientCertificate
+		secret.Data[corev1.TLSPrivateKeyKey] = config.ClientKey
+	} else {
+		secret.Data[TokenKey] = []byte(config.Token)
+	}
+	if config.PreviousToken != "" {
+		secret.Data[PreviousTokenKey] = []byte(config.PreviousToken)
+	}
 
 	return secret, nil
 }
 
+// SecretName returns the name of the secret containing the kubeconfig.
+func SecretName(kubeconfig *v1alpha1.Kubeconfig) string {
+	return kubeconfig.GetName() + "-kubeconfig"
+}
+
+// Credential returns the credential of the given credential type stored in the kubeconfig secret. Client
+// certificates are returned as the PEM encoded certificate followed by the private key.
+func Credential(secret *corev1.Secret, credentialType v1alpha1.CredentialType) string {
+	if credentialType == v1alpha1.CredentialTypeClientCertificate {
+		return string(secret.Data[corev1.TLSCertKey]) + string(secret.Data[corev1.TLSPrivateKeyKey])
+	}
+	return string(secret.Data[TokenKey])
+}
+
 func generateKubeconfigYaml(config BuildConfig) ([]byte, error) {
 	// Build the context name as serviceaccountname@clustername.
 	contextName := fmt.Sprintf("%s@%s", config.ServiceAccountName, config.Kubeconfig.Spec.ClusterName)
@@ -63,7 +97,9 @@ func generateKubeconfigYaml(config BuildConfig) ([]byte, error) {
 		},
 		AuthInfos: map[string]*clientcmdapi.AuthInfo{
 			config.ServiceAccountName: {
-				Token: config.Token,
+				Token:                 config.Token,
+				ClientCertificateData: config.ClientCertificate,
+				ClientKeyData:         config.ClientKey,
 			},
 		},
 		Contexts: map[string]*clientcmdapi.Context{
@@ -75,5 +111,28 @@ func generateKubeconfigYaml(config BuildConfig) ([]byte, error) {
 		},
 	}
 
+	if impersonate := config.Kubeconfig.Spec.Impersonate; impersonate != nil {
+		authInfo := cfg.AuthInfos[config.ServiceAccountName]
+		authInfo.Impersonate = impersonate.User
+		authInfo.ImpersonateGroups = impersonate.Groups
+		authInfo.ImpersonateUserExtra = impersonate.Extra
+	}
+
+	if config.PreviousToken != "" {
+		// the previous credential is added as a separate user and context, the current context uses the new token
+		previousAuthInfo := config.ServiceAccountName + "-previous"
+		cfg.AuthInfos[previousAuthInfo] = &clientcmdapi.AuthInfo{
+			Token:                config.PreviousToken,
+			Impersonate:          cfg.AuthInfos[config.ServiceAccountName].Impersonate,
+			ImpersonateGroups:    cfg.AuthInfos[config.ServiceAccountName].ImpersonateGroups,
+			ImpersonateUserExtra: cfg.AuthInfos[config.ServiceAccountName].ImpersonateUserExtra,
+		}
+		cfg.Contexts[fmt.Sprintf("%s@%s", previousAuthInfo, config.Kubeconfig.Spec.ClusterName)] = &clientcmdapi.Context{
+			Cluster:   config.Kubeconfig.Spec.ClusterName,
+			AuthInfo:  previousAuthInfo,
+			Namespace: config.Namespace,
+		}
+	}
+
 	return clientcmd.Write(*cfg)
 }
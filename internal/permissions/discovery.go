@@ -0,0 +1,218 @@
+package permissions
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/discovery"
+)
+
+// APIResources are the resources served by the API server, used to validate rules and to expand wildcards.
+type APIResources struct {
+	// resources lists the sorted resources and subresources of each API group e.g. "pods" and "pods/exec" for the
+	// core API group.
+	resources map[string][]string
+	// failedGroups couldn't be discovered e.g. because an aggregated API server is unavailable.
+	failedGroups sets.Set[string]
+}
+
+// Discover lists the resources served by the API server. API groups that can't be discovered are remembered instead
+// of failing, so an unavailable aggregated API server doesn't block Kubeconfigs that don't use it.
+func Discover(client discovery.DiscoveryInterface) (*APIResources, error) {
+	result := &APIResources{
+		resources:    map[string][]string{},
+		failedGroups: sets.New[string](),
+	}
+
+	_, lists, err := client.ServerGroupsAndResources()
+	if err != nil {
+		groupErr := &discovery.ErrGroupDiscoveryFailed{}
+		if !errors.As(err, &groupErr) {
+			return nil, fmt.Errorf("discovering API resources: %w", err)
+		}
+		for groupVersion := range groupErr.Groups {
+			result.failedGroups.Insert(groupVersion.Group)
+		}
+	}
+
+	for _, list := range lists {
+		groupVersion, err := schema.ParseGroupVersion(list.GroupVersion)
+		if err != nil {
+			return nil, fmt.Errorf("parsing group version %q: %w", list.GroupVersion, err)
+		}
+		for _, resource := range list.APIResources {
+			if !slices.Contains(result.resources[groupVersion.Group], resource.Name) {
+				result.resources[groupVersion.Group] = append(result.resources[groupVersion.Group], resource.Name)
+			}
+		}
+	}
+	for group := range result.resources {
+		slices.Sort(result.resources[group])
+	}
+	return result, nil
+}
+
+// Qualified returns the served resources and subresources qualified by their API group e.g. "pods", "pods/exec" or
+// "deployments.apps".
+func (r *APIResources) Qualified() []string {
+	var qualified []string
+	for group, resources := range r.resources {
+		for _, resource := range resources {
+			qualified = append(qualified, qualifiedResource(group, resource))
+		}
+	}
+	slices.Sort(qualified)
+	return qualified
+}
+
+// Only returns the API resources limited to the given qualified resources, so resources served later aren't
+// included. API groups without any of the resources are dropped.
+func (r *APIResources) Only(qualified []string) *APIResources {
+	allowed := sets.New(qualified...)
+	result := &APIResources{
+		resources:    map[string][]string{},
+		failedGroups: r.failedGroups.Clone(),
+	}
+	for group, resources := range r.resources {
+		for _, resource := range resources {
+			if allowed.Has(qualifiedResource(group, resource)) {
+				result.resources[group] = append(result.resources[group], resource)
+			}
+		}
+	}
+	return result
+}
+
+// Unknown returns a description of every API group and resource of the rules the API server doesn't serve e.g.
+// `unknown resource "deploymnets.apps"`. Wildcards and API groups that couldn't be discovered aren't checked.
+func (r *APIResources) Unknown(rules []rbacv1.PolicyRule) []string {
+	var unknown []string
+	add := func(description string) {
+		if !slices.Contains(unknown, description) {
+			unknown = append(unknown, description)
+		}
+	}
+
+	for _, rule := range rules {
+		for _, group := range rule.APIGroups {
+			if r.failedGroups.Has(group) {
+				continue
+			}
+			if _, ok := r.resources[group]; !ok && group != rbacv1.APIGroupAll {
+				add(fmt.Sprintf("unknown API group %q", group))
+				continue
+			}
+			for _, resource := range rule.Resources {
+				if strings.Contains(resource, rbacv1.ResourceAll) || r.served(group, resource) {
+					continue
+				}
+				add(fmt.Sprintf("unknown resource %q", qualifiedResource(group, resource)))
+			}
+		}
+	}
+	return unknown
+}
+
+// served reports whether the resource is served in the API group or in any API group for the "*" group.
+func (r *APIResources) served(group, resource string) bool {
+	if group != rbacv1.APIGroupAll {
+		return slices.Contains(r.resources[group], resource)
+	}
+	for _, resources := range r.resources {
+		if slices.Contains(resources, resource) {
+			return true
+		}
+	}
+	return len(r.failedGroups) > 0
+}
+
+// Expand removes the excluded resources from the rules. Exclusions are either a resource e.g. "secrets", which
+// matches the resource in every API group, or a resource qualified by its API group e.g. "deployments.apps".
+// Subresources of an excluded resource are excluded as well, single subresources are excluded by their name e.g.
+// "pods/exec". Rules granting all resources ("*") or all API groups are replaced by one rule per API group listing
+// the served resources and subresources, as RBAC rules can't exclude anything.
+func (r *APIResources) Expand(rules []rbacv1.PolicyRule, exclude []string) ([]rbacv1.PolicyRule, error) {
+	if len(exclude) == 0 {
+		return rules, nil
+	}
+
+	var expanded []rbacv1.PolicyRule
+	for _, rule := range rules {
+		if len(rule.Resources) == 0 {
+			expanded = append(expanded, rule)
+			continue
+		}
+
+		groups := rule.APIGroups
+		allGroups := slices.Contains(groups, rbacv1.APIGroupAll)
+		if allGroups {
+			// groups that couldn't be discovered are expanded once they are available again
+			groups = sets.List(sets.KeySet(r.resources))
+		}
+		for _, group := range groups {
+			if r.failedGroups.Has(group) && slices.Contains(rule.Resources, rbacv1.ResourceAll) {
+				return nil, fmt.Errorf("resources of API group %q can't be discovered", group)
+			}
+
+			var resources []string
+			for _, resource := range rule.Resources {
+				candidates := []string{resource}
+				if resource == rbacv1.ResourceAll {
+					candidates = r.resources[group]
+				}
+				if allGroups && resource != rbacv1.ResourceAll && !slices.Contains(r.resources[group], resource) {
+					continue
+				}
+				for _, candidate := range candidates {
+					if !excluded(exclude, group, candidate) && !slices.Contains(resources, candidate) {
+						resources = append(resources, candidate)
+					}
+				}
+			}
+			if len(resources) == 0 {
+				continue
+			}
+
+			groupRule := *rule.DeepCopy()
+			groupRule.APIGroups = []string{group}
+			groupRule.Resources = resources
+			expanded = append(expanded, groupRule)
+		}
+	}
+	return expanded, nil
+}
+
+// excluded reports whether the resource of the API group or one of its parent resources is excluded.
+func excluded(exclude []string, group, resource string) bool {
+	for _, exclusion := range exclude {
+		name, subresource, found := strings.Cut(exclusion, "/")
+		excludedResource, excludedGroup, qualified := strings.Cut(name, ".")
+		if qualified && excludedGroup != group {
+			continue
+		}
+		if found {
+			excludedResource += "/" + subresource
+		}
+		if resource == excludedResource || strings.HasPrefix(resource, excludedResource+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// qualifiedResource returns the resource qualified by its API group e.g. "deployments.apps" or "pods".
+func qualifiedResource(group, resource string) string {
+	if group == "" {
+		return resource
+	}
+	resource, subresource, found := strings.Cut(resource, "/")
+	if found {
+		return resource + "." + group + "/" + subresource
+	}
+	return resource + "." + group
+}
@@ -0,0 +1,118 @@
+package permissions
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+var _ = Describe("APIResources", func() {
+	var resources *APIResources
+
+	BeforeEach(func() {
+		var err error
+		resources, err = Discover(&fakediscovery.FakeDiscovery{Fake: &clienttesting.Fake{
+			Resources: []*metav1.APIResourceList{
+				{
+					GroupVersion: "v1",
+					APIResources: []metav1.APIResource{{Name: "pods"}, {Name: "pods/exec"}, {Name: "pods/log"}, {Name: "secrets"}},
+				},
+				{
+					GroupVersion: "apps/v1",
+					APIResources: []metav1.APIResource{{Name: "deployments"}, {Name: "deployments/scale"}},
+				},
+				{
+					// resources served in multiple versions are listed once
+					GroupVersion: "apps/v1beta1",
+					APIResources: []metav1.APIResource{{Name: "deployments"}},
+				},
+			},
+		}})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	Describe("Expand", func() {
+		It("should return the rules unchanged without exclusions", func() {
+			rules := []rbacv1.PolicyRule{{APIGroups: []string{"*"}, Resources: []string{"*"}, Verbs: []string{"get"}}}
+			Expect(resources.Expand(rules, nil)).To(Equal(rules))
+		})
+
+		It("should expand all resources of an API group except the excluded ones", func() {
+			rules := []rbacv1.PolicyRule{{APIGroups: []string{""}, Resources: []string{"*"}, Verbs: []string{"get"}}}
+			Expect(resources.Expand(rules, []string{"secrets", "pods/exec"})).To(Equal([]rbacv1.PolicyRule{
+				{APIGroups: []string{""}, Resources: []string{"pods", "pods/log"}, Verbs: []string{"get"}},
+			}))
+		})
+
+		It("should expand all API groups into one rule per served API group", func() {
+			rules := []rbacv1.PolicyRule{{APIGroups: []string{"*"}, Resources: []string{"*"}, Verbs: []string{"list"}}}
+			Expect(resources.Expand(rules, []string{"secrets", "deployments.apps"})).To(Equal([]rbacv1.PolicyRule{
+				{APIGroups: []string{""}, Resources: []string{"pods", "pods/exec", "pods/log"}, Verbs: []string{"list"}},
+			}))
+		})
+
+		It("should only keep named resources of all API groups in the groups serving them", func() {
+			rules := []rbacv1.PolicyRule{{APIGroups: []string{"*"}, Resources: []string{"deployments", "secrets"}, Verbs: []string{"get"}}}
+			Expect(resources.Expand(rules, []string{"secrets"})).To(Equal([]rbacv1.PolicyRule{
+				{APIGroups: []string{"apps"}, Resources: []string{"deployments"}, Verbs: []string{"get"}},
+			}))
+		})
+
+		It("should keep rules without resources and named resources of unknown API groups", func() {
+			rules := []rbacv1.PolicyRule{
+				{NonResourceURLs: []string{"/healthz"}, Verbs: []string{"get"}},
+				{APIGroups: []string{"test.klaud.works"}, Resources: []string{"widgets"}, Verbs: []string{"get"}},
+			}
+			Expect(resources.Expand(rules, []string{"secrets"})).To(Equal(rules))
+		})
+
+		It("should drop rules that only grant excluded resources", func() {
+			rules := []rbacv1.PolicyRule{{APIGroups: []string{""}, Resources: []string{"secrets"}, Verbs: []string{"get"}}}
+			Expect(resources.Expand(rules, []string{"secrets"})).To(BeEmpty())
+		})
+
+		It("should fail to expand all resources of API groups that couldn't be discovered", func() {
+			resources.failedGroups = sets.New("metrics.k8s.io")
+			rules := []rbacv1.PolicyRule{{APIGroups: []string{"metrics.k8s.io"}, Resources: []string{"*"}, Verbs: []string{"get"}}}
+			_, err := resources.Expand(rules, []string{"secrets"})
+			Expect(err).To(MatchError(ContainSubstring(`resources of API group "metrics.k8s.io" can't be discovered`)))
+		})
+	})
+
+	Describe("Only", func() {
+		It("should only expand into the given resources", func() {
+			qualified := resources.Qualified()
+			Expect(qualified).To(Equal([]string{
+				"deployments.apps", "deployments.apps/scale", "pods", "pods/exec", "pods/log", "secrets",
+			}))
+
+			limited := resources.Only([]string{"pods", "pods/log", "secrets", "widgets.test.klaud.works"})
+			rules := []rbacv1.PolicyRule{{APIGroups: []string{"*"}, Resources: []string{"*"}, Verbs: []string{"get"}}}
+			Expect(limited.Expand(rules, []string{"secrets"})).To(Equal([]rbacv1.PolicyRule{
+				{APIGroups: []string{""}, Resources: []string{"pods", "pods/log"}, Verbs: []string{"get"}},
+			}))
+		})
+	})
+})
+
+var _ = DescribeTable("excluded",
+	func(exclude []string, group, resource string, expected bool) {
+		Expect(excluded(exclude, group, resource)).To(Equal(expected))
+	},
+	Entry("resource in the core API group", []string{"secrets"}, "", "secrets", true),
+	Entry("resource in any API group", []string{"events"}, "events.k8s.io", "events", true),
+	Entry("subresources of an excluded resource", []string{"pods"}, "", "pods/exec", true),
+	Entry("resource sharing the prefix of an excluded resource", []string{"pods"}, "", "podtemplates", false),
+	Entry("resource qualified by its API group", []string{"deployments.apps"}, "apps", "deployments", true),
+	Entry("resource qualified by another API group", []string{"deployments.apps"}, "extensions", "deployments", false),
+	Entry("resource qualified by a dotted API group", []string{"ingresses.networking.k8s.io"}, "networking.k8s.io", "ingresses", true),
+	Entry("single subresource", []string{"pods/exec"}, "", "pods/exec", true),
+	Entry("other subresource", []string{"pods/exec"}, "", "pods/log", false),
+	Entry("parent of an excluded subresource", []string{"pods/exec"}, "", "pods", false),
+	Entry("subresource qualified by its API group", []string{"deployments.apps/scale"}, "apps", "deployments/scale", true),
+	Entry("no exclusions", nil, "", "secrets", false),
+)
@@ -0,0 +1,129 @@
+package permissions
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+
+	"github.com/klaudworks/kubeconfig-operator/api/klaud.works/v1alpha1"
+)
+
+// clusterScope is reviewed to list the rules that apply cluster-wide. SelfSubjectRulesReviews require a namespace,
+// so a name that isn't a valid namespace name is used which can't contain any RoleBindings.
+const clusterScope = "klaud.works:cluster"
+
+// Reviewer lists the rules a user is allowed by impersonating the user and creating SelfSubjectRulesReviews.
+type Reviewer struct {
+	config *rest.Config
+}
+
+func NewReviewer(config *rest.Config) *Reviewer {
+	return &Reviewer{
+		config: config,
+	}
+}
+
+// Review returns the rules the identity is allowed cluster-wide followed by the rules allowed in each of the
+// namespaces. The rules of a namespace don't repeat the cluster-wide rules. Rules are sorted so the result only
+// changes if the permissions change.
+func (r *Reviewer) Review(
+	ctx context.Context,
+	identity rest.ImpersonationConfig,
+	namespaces []string,
+) ([]v1alpha1.EffectivePermissions, error) {
+	config := rest.CopyConfig(r.config)
+	config.Impersonate = identity
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("creating client impersonating %s: %w", identity.UserName, err)
+	}
+
+	cluster, err := review(ctx, clientset, clusterScope)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []v1alpha1.EffectivePermissions{cluster}
+	for _, namespace := range namespaces {
+		permissions, err := review(ctx, clientset, namespace)
+		if err != nil {
+			return nil, err
+		}
+		permissions.Rules = slices.DeleteFunc(permissions.Rules, func(rule rbacv1.PolicyRule) bool {
+			return containsRule(cluster.Rules, rule)
+		})
+		result = append(result, permissions)
+	}
+	return result, nil
+}
+
+func review(ctx context.Context, clientset kubernetes.Interface, namespace string) (v1alpha1.EffectivePermissions, error) {
+	review, err := clientset.AuthorizationV1().SelfSubjectRulesReviews().Create(ctx, &authorizationv1.SelfSubjectRulesReview{
+		Spec: authorizationv1.SelfSubjectRulesReviewSpec{
+			Namespace: namespace,
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return v1alpha1.EffectivePermissions{}, fmt.Errorf("reviewing rules in namespace %q: %w", namespace, err)
+	}
+
+	var rules []rbacv1.PolicyRule
+	for _, rule := range review.Status.ResourceRules {
+		rules = appendRule(rules, rbacv1.PolicyRule{
+			Verbs:         rule.Verbs,
+			APIGroups:     rule.APIGroups,
+			Resources:     rule.Resources,
+			ResourceNames: rule.ResourceNames,
+		})
+	}
+	for _, rule := range review.Status.NonResourceRules {
+		rules = appendRule(rules, rbacv1.PolicyRule{
+			Verbs:           rule.Verbs,
+			NonResourceURLs: rule.NonResourceURLs,
+		})
+	}
+	slices.SortFunc(rules, func(a, b rbacv1.PolicyRule) int {
+		return strings.Compare(ruleKey(a), ruleKey(b))
+	})
+
+	if namespace == clusterScope {
+		namespace = ""
+	}
+	return v1alpha1.EffectivePermissions{
+		Namespace:  namespace,
+		Rules:      rules,
+		Incomplete: review.Status.Incomplete,
+	}, nil
+}
+
+// appendRule appends the rule unless it's contained in the rules already. Rules granted by several bindings are
+// reported once per binding.
+func appendRule(rules []rbacv1.PolicyRule, rule rbacv1.PolicyRule) []rbacv1.PolicyRule {
+	if containsRule(rules, rule) {
+		return rules
+	}
+	return append(rules, rule)
+}
+
+func containsRule(rules []rbacv1.PolicyRule, rule rbacv1.PolicyRule) bool {
+	return slices.ContainsFunc(rules, func(r rbacv1.PolicyRule) bool { return equality.Semantic.DeepEqual(r, rule) })
+}
+
+// ruleKey returns a key ordering non-resource rules after resource rules and resource rules by API group and resource.
+func ruleKey(rule rbacv1.PolicyRule) string {
+	return strings.Join([]string{
+		strings.Join(rule.NonResourceURLs, ","),
+		strings.Join(rule.APIGroups, ","),
+		strings.Join(rule.Resources, ","),
+		strings.Join(rule.ResourceNames, ","),
+		strings.Join(rule.Verbs, ","),
+	}, "/")
+}
@@ -0,0 +1,194 @@
+package presets
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+
+	"github.com/klaudworks/kubeconfig-operator/api/klaud.works/v1alpha1"
+)
+
+// NOTE: released versions must never change as existing Kubeconfigs would silently gain or lose permissions. Add a
+// new version instead and document it in the README.
+
+var (
+	readVerbs  = []string{"get", "list", "watch"}
+	writeVerbs = []string{"get", "list", "watch", "create", "update", "patch", "delete", "deletecollection"}
+)
+
+// definitions contains the rules of every version of every preset. Presets without rules like secretsDenied modify
+// the rules of the other permissions instead.
+var definitions = map[v1alpha1.PresetName]map[int32][]rbacv1.PolicyRule{
+	v1alpha1.PresetReadOnlyCluster: {
+		1: {
+			{
+				APIGroups: []string{""},
+				Resources: []string{
+					"configmaps", "endpoints", "events", "limitranges", "namespaces", "nodes", "persistentvolumeclaims",
+					"persistentvolumes", "pods", "pods/log", "replicationcontrollers", "resourcequotas", "secrets",
+					"serviceaccounts", "services",
+				},
+				Verbs: readVerbs,
+			},
+			{
+				APIGroups: []string{"apps"},
+				Resources: []string{"controllerrevisions", "daemonsets", "deployments", "replicasets", "statefulsets"},
+				Verbs:     readVerbs,
+			},
+			{
+				APIGroups: []string{"batch"},
+				Resources: []string{"cronjobs", "jobs"},
+				Verbs:     readVerbs,
+			},
+			{
+				APIGroups: []string{"autoscaling"},
+				Resources: []string{"horizontalpodautoscalers"},
+				Verbs:     readVerbs,
+			},
+			{
+				APIGroups: []string{"networking.k8s.io"},
+				Resources: []string{"ingressclasses", "ingresses", "networkpolicies"},
+				Verbs:     readVerbs,
+			},
+			{
+				APIGroups: []string{"discovery.k8s.io"},
+				Resources: []string{"endpointslices"},
+				Verbs:     readVerbs,
+			},
+			{
+				APIGroups: []string{"policy"},
+				Resources: []string{"poddisruptionbudgets"},
+				Verbs:     readVerbs,
+			},
+			{
+				APIGroups: []string{"storage.k8s.io"},
+				Resources: []string{"storageclasses"},
+				Verbs:     readVerbs,
+			},
+			{
+				APIGroups: []string{"rbac.authorization.k8s.io"},
+				Resources: []string{"clusterrolebindings", "clusterroles", "rolebindings", "roles"},
+				Verbs:     readVerbs,
+			},
+			{
+				APIGroups: []string{"apiextensions.k8s.io"},
+				Resources: []string{"customresourcedefinitions"},
+				Verbs:     readVerbs,
+			},
+		},
+	},
+	v1alpha1.PresetNamespaceEditor: {
+		1: namespaceEditorV1,
+	},
+	v1alpha1.PresetNamespaceAdmin: {
+		1: append(slices.Clone(namespaceEditorV1), rbacv1.PolicyRule{
+			APIGroups: []string{"rbac.authorization.k8s.io"},
+			Resources: []string{"rolebindings", "roles"},
+			Verbs:     writeVerbs,
+		}),
+	},
+	v1alpha1.PresetSecretsDenied: {
+		1: nil,
+	},
+}
+
+var namespaceEditorV1 = []rbacv1.PolicyRule{
+	{
+		APIGroups: []string{""},
+		Resources: []string{
+			"configmaps", "endpoints", "persistentvolumeclaims", "pods", "replicationcontrollers",
+			"replicationcontrollers/scale", "secrets", "serviceaccounts", "services",
+		},
+		Verbs: writeVerbs,
+	},
+	{
+		APIGroups: []string{""},
+		Resources: []string{"pods/attach", "pods/exec", "pods/portforward", "pods/proxy", "services/proxy"},
+		Verbs:     []string{"get", "create"},
+	},
+	{
+		APIGroups: []string{""},
+		Resources: []string{"events", "limitranges", "pods/log", "pods/status", "resourcequotas"},
+		Verbs:     readVerbs,
+	},
+	{
+		APIGroups: []string{"apps"},
+		Resources: []string{
+			"daemonsets", "deployments", "deployments/rollback", "deployments/scale", "replicasets",
+			"replicasets/scale", "statefulsets", "statefulsets/scale",
+		},
+		Verbs: writeVerbs,
+	},
+	{
+		APIGroups: []string{"batch"},
+		Resources: []string{"cronjobs", "jobs"},
+		Verbs:     writeVerbs,
+	},
+	{
+		APIGroups: []string{"autoscaling"},
+		Resources: []string{"horizontalpodautoscalers"},
+		Verbs:     writeVerbs,
+	},
+	{
+		APIGroups: []string{"networking.k8s.io"},
+		Resources: []string{"ingresses", "networkpolicies"},
+		Verbs:     writeVerbs,
+	},
+	{
+		APIGroups: []string{"policy"},
+		Resources: []string{"poddisruptionbudgets"},
+		Verbs:     writeVerbs,
+	},
+	{
+		APIGroups: []string{"discovery.k8s.io"},
+		Resources: []string{"endpointslices"},
+		Verbs:     readVerbs,
+	},
+}
+
+// Rules returns a copy of the rules of the preset in the requested version. Version 0 refers to version 1.
+func Rules(preset v1alpha1.Preset) ([]rbacv1.PolicyRule, error) {
+	versions, ok := definitions[preset.Name]
+	if !ok {
+		return nil, fmt.Errorf("unknown preset %q", preset.Name)
+	}
+	version := max(preset.Version, 1)
+	rules, ok := versions[version]
+	if !ok {
+		return nil, fmt.Errorf("unknown version %d of preset %q", version, preset.Name)
+	}
+
+	copied := make([]rbacv1.PolicyRule, 0, len(rules))
+	for _, rule := range rules {
+		copied = append(copied, *rule.DeepCopy())
+	}
+	return copied, nil
+}
+
+// WithoutSecrets removes secrets and their subresources from the rules. Rules that only granted access to secrets are
+// dropped. Rules granting all resources of the core API group can't be restricted without listing every other
+// resource and are rejected.
+func WithoutSecrets(rules []rbacv1.PolicyRule) ([]rbacv1.PolicyRule, error) {
+	var filtered []rbacv1.PolicyRule
+	for _, rule := range rules {
+		if !slices.Contains(rule.APIGroups, "") && !slices.Contains(rule.APIGroups, rbacv1.APIGroupAll) {
+			filtered = append(filtered, rule)
+			continue
+		}
+		if slices.Contains(rule.Resources, rbacv1.ResourceAll) {
+			return nil, fmt.Errorf("secrets can't be excluded from rules granting all resources of the core API group")
+		}
+
+		rule = *rule.DeepCopy()
+		rule.Resources = slices.DeleteFunc(rule.Resources, func(resource string) bool {
+			return resource == "secrets" || strings.HasPrefix(resource, "secrets/")
+		})
+		if len(rule.Resources) == 0 && len(rule.NonResourceURLs) == 0 {
+			continue
+		}
+		filtered = append(filtered, rule)
+	}
+	return filtered, nil
+}
@@ -0,0 +1,13 @@
+package presets
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestPresets(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Presets Suite")
+}
@@ -0,0 +1,73 @@
+package presets
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	rbacv1 "k8s.io/api/rbac/v1"
+
+	"github.com/klaudworks/kubeconfig-operator/api/klaud.works/v1alpha1"
+)
+
+var _ = Describe("Rules", func() {
+	It("should default to the first version", func() {
+		rules, err := Rules(v1alpha1.Preset{Name: v1alpha1.PresetNamespaceEditor})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(rules).To(Equal(namespaceEditorV1))
+	})
+
+	It("should return copies of the definitions", func() {
+		rules, err := Rules(v1alpha1.Preset{Name: v1alpha1.PresetNamespaceAdmin, Version: 1})
+		Expect(err).NotTo(HaveOccurred())
+		rules[0].Verbs[0] = "modified"
+
+		Expect(definitions[v1alpha1.PresetNamespaceAdmin][1][0].Verbs[0]).To(Equal("get"))
+		Expect(namespaceEditorV1[0].Verbs[0]).To(Equal("get"))
+	})
+
+	It("should extend the namespaceEditor permissions for namespaceAdmin", func() {
+		rules, err := Rules(v1alpha1.Preset{Name: v1alpha1.PresetNamespaceAdmin})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(rules).To(HaveLen(len(namespaceEditorV1) + 1))
+		Expect(rules[len(rules)-1].Resources).To(ConsistOf("rolebindings", "roles"))
+	})
+
+	It("should reject unknown versions", func() {
+		_, err := Rules(v1alpha1.Preset{Name: v1alpha1.PresetReadOnlyCluster, Version: 2})
+		Expect(err).To(MatchError(`unknown version 2 of preset "readOnlyCluster"`))
+	})
+})
+
+var _ = Describe("WithoutSecrets", func() {
+	It("should remove secrets and their subresources from the core API group", func() {
+		rules, err := WithoutSecrets([]rbacv1.PolicyRule{
+			{APIGroups: []string{""}, Resources: []string{"configmaps", "secrets", "secrets/status"}, Verbs: []string{"get"}},
+			{APIGroups: []string{"*"}, Resources: []string{"secrets"}, Verbs: []string{"get"}},
+			{APIGroups: []string{"example.com"}, Resources: []string{"secrets"}, Verbs: []string{"get"}},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(rules).To(Equal([]rbacv1.PolicyRule{
+			{APIGroups: []string{""}, Resources: []string{"configmaps"}, Verbs: []string{"get"}},
+			{APIGroups: []string{"example.com"}, Resources: []string{"secrets"}, Verbs: []string{"get"}},
+		}))
+	})
+
+	It("should keep non-resource rules", func() {
+		rules, err := WithoutSecrets([]rbacv1.PolicyRule{
+			{NonResourceURLs: []string{"/healthz"}, Verbs: []string{"get"}},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(rules).To(HaveLen(1))
+	})
+
+	It("should not modify the given rules", func() {
+		original := []rbacv1.PolicyRule{{APIGroups: []string{""}, Resources: []string{"secrets", "pods"}, Verbs: []string{"get"}}}
+		_, err := WithoutSecrets(original)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(original[0].Resources).To(Equal([]string{"secrets", "pods"}))
+	})
+
+	It("should reject wildcard resources of the core API group", func() {
+		_, err := WithoutSecrets([]rbacv1.PolicyRule{{APIGroups: []string{""}, Resources: []string{"*"}, Verbs: []string{"get"}}})
+		Expect(err).To(MatchError(ContainSubstring("secrets can't be excluded")))
+	})
+})
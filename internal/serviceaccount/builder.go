@@ -1,19 +1,41 @@
 package serviceaccount
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"slices"
+	"strings"
+	"time"
 
 	corev1 "k8s.io/api/core/v1"
 	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/validation"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	"github.com/klaudworks/kubeconfig-operator/api/klaud.works/v1alpha1"
+	"github.com/klaudworks/kubeconfig-operator/internal/permissions"
+	"github.com/klaudworks/kubeconfig-operator/internal/presets"
 	"github.com/klaudworks/kubeconfig-operator/internal/util"
 )
 
+const (
+	// LabelOwnerNamespace is the namespace of the kubeconfig that owns a permission object.
+	LabelOwnerNamespace = "kubeconfig-operator/owner-namespace"
+	// LabelOwnerName is the name of the kubeconfig that owns a permission object.
+	LabelOwnerName = "kubeconfig-operator/owner-name"
+)
+
 type builder struct {
 	kubeconfig *v1alpha1.Kubeconfig
+	namespaces []corev1.Namespace
+	resources  *permissions.APIResources
+	now        time.Time
 }
 
 func NewBuilder(
@@ -21,18 +43,39 @@ func NewBuilder(
 ) *builder {
 	return &builder{
 		kubeconfig: kubeconfig,
+		now:        time.Now(),
 	}
 }
 
+// WithNamespaces sets the namespaces the namespace selectors of the namespaced permissions are matched against.
+func (b *builder) WithNamespaces(namespaces []corev1.Namespace) *builder {
+	b.namespaces = namespaces
+	return b
+}
+
+// WithAPIResources sets the resources served by the API server which rules excluding resources are expanded into.
+func (b *builder) WithAPIResources(resources *permissions.APIResources) *builder {
+	b.resources = resources
+	return b
+}
+
 func (b *builder) Build() []client.Object {
 	resources := []client.Object{}
 
-	resources = append(resources, b.ServiceAccount())
+	if b.ServiceAccountRequired() {
+		resources = append(resources, b.ServiceAccount())
+	}
 	resources = append(resources, b.roleAndBindings()...)
 	resources = append(resources, b.clusterRoleAndBinding()...)
+	resources = append(resources, b.roleRefBindings()...)
+	resources = append(resources, b.presetRolesAndBindings()...)
+	resources = append(resources, b.impersonationRolesAndBindings()...)
 
 	for _, resource := range resources {
 		util.AddLabel(resource, "kubeconfig-operator/type", "permission")
+		for key, value := range b.OwnerLabels() {
+			util.AddLabel(resource, key, value)
+		}
 	}
 
 	return resources
@@ -47,11 +90,143 @@ func (b *builder) ServiceAccount() *corev1.ServiceAccount {
 	}
 }
 
+// ServiceAccountRequired reports whether the credential of the kubeconfig is issued for a service account.
+// Client certificates are issued for a user instead.
+func (b *builder) ServiceAccountRequired() bool {
+	return b.kubeconfig.Spec.CredentialType != v1alpha1.CredentialTypeClientCertificate
+}
+
+// User returns the name the API server authenticates the credential of the kubeconfig as.
+// For client certificates, the revocation generation is appended once set so role bindings no longer apply to
+// certificates issued before a revocation.
+func (b *builder) User() string {
+	if b.ServiceAccountRequired() {
+		return fmt.Sprintf("system:serviceaccount:%s:%s", b.kubeconfig.GetNamespace(), b.kubeconfig.GetName())
+	}
+
+	user := fmt.Sprintf("klaud.works:kubeconfig:%s:%s", b.kubeconfig.GetNamespace(), b.kubeconfig.GetName())
+	if generation := b.kubeconfig.Spec.RevocationGeneration; generation != 0 {
+		user = fmt.Sprintf("%s:%d", user, generation)
+	}
+	return user
+}
+
+// Groups returns the groups client certificates are issued for.
+func (b *builder) Groups() []string {
+	return []string{
+		"klaud.works:kubeconfigs",
+		fmt.Sprintf("klaud.works:kubeconfigs:%s", b.kubeconfig.GetNamespace()),
+	}
+}
+
+// UserGroups returns the groups the API server authenticates the credential of the kubeconfig with, besides
+// system:authenticated.
+func (b *builder) UserGroups() []string {
+	if !b.ServiceAccountRequired() {
+		return b.Groups()
+	}
+	return []string{
+		"system:serviceaccounts",
+		fmt.Sprintf("system:serviceaccounts:%s", b.kubeconfig.GetNamespace()),
+	}
+}
+
+// GrantedNamespaces returns the sorted namespaces the kubeconfig grants permissions in via namespaced permissions,
+// role references or presets.
+func (b *builder) GrantedNamespaces() []string {
+	namespaces := sets.New(b.CoveredNamespaces()...)
+	for _, ref := range b.kubeconfig.Spec.RoleRefs {
+		if ref.Namespace != "" {
+			namespaces.Insert(ref.Namespace)
+		}
+	}
+	for _, preset := range b.kubeconfig.Spec.Presets {
+		if preset.Namespace != "" {
+			namespaces.Insert(preset.Namespace)
+		}
+	}
+	return sets.List(namespaces)
+}
+
+// subject returns the subject permissions are bound to.
+func (b *builder) subject() rbacv1.Subject {
+	if !b.ServiceAccountRequired() {
+		return rbacv1.Subject{
+			APIGroup: rbacv1.GroupName,
+			Kind:     rbacv1.UserKind,
+			Name:     b.User(),
+		}
+	}
+
+	sa := b.ServiceAccount()
+	return rbacv1.Subject{
+		Kind:      rbacv1.ServiceAccountKind,
+		Name:      sa.GetName(),
+		Namespace: sa.GetNamespace(),
+	}
+}
+
+// ObjectName returns the name of a role or binding of the kubeconfig. Roles and bindings are created outside the
+// namespace of the kubeconfig, so the name contains a hash of the namespace and name of the kubeconfig. Otherwise,
+// Kubeconfigs with the same name in different namespaces or names like "a-b" in "c" and "a" in "b-c" would collide.
+// Names exceeding the maximum length e.g. because of a long role reference are truncated and suffixed with a hash of
+// the full name.
+func (b *builder) ObjectName(suffix ...string) string {
+	hash := sha256.Sum256([]byte(b.kubeconfig.GetNamespace() + "/" + b.kubeconfig.GetName()))
+	name := strings.Join(append([]string{b.kubeconfig.GetName(), hex.EncodeToString(hash[:4])}, suffix...), "-")
+	if len(name) <= validation.DNS1123SubdomainMaxLength {
+		return name
+	}
+
+	nameHash := sha256.Sum256([]byte(name))
+	return strings.TrimRight(name[:validation.DNS1123SubdomainMaxLength-9], "-.") + "-" + hex.EncodeToString(nameHash[:4])
+}
+
+// OwnerLabels returns the labels identifying the kubeconfig that owns an object. Names exceeding the maximum length
+// of label values are truncated and suffixed with a hash of the full name.
+func (b *builder) OwnerLabels() map[string]string {
+	name := b.kubeconfig.GetName()
+	if len(name) > validation.LabelValueMaxLength {
+		hash := sha256.Sum256([]byte(name))
+		name = strings.TrimRight(name[:validation.LabelValueMaxLength-9], "-.") + "-" + hex.EncodeToString(hash[:4])
+	}
+
+	return map[string]string{
+		LabelOwnerNamespace: b.kubeconfig.GetNamespace(),
+		LabelOwnerName:      name,
+	}
+}
+
+// Owns reports whether the object carries the owner labels of the kubeconfig.
+func (b *builder) Owns(obj client.Object) bool {
+	for key, value := range b.OwnerLabels() {
+		if obj.GetLabels()[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// roleAndBindings creates one role and binding per namespace. Entries that apply to the same namespace, either
+// listed multiple times or matched by several selectors, are merged into the same role as the names would collide
+// otherwise.
 func (b *builder) roleAndBindings() []client.Object {
 	var objs []client.Object
 
+	var namespaces []string
+	rulesByNamespace := map[string][]rbacv1.PolicyRule{}
 	for _, namespacedRole := range b.kubeconfig.Spec.NamespacedPermissions {
-		role := b.role(b.kubeconfig, namespacedRole.Namespace, namespacedRole.Rules)
+		rules := b.expand(namespacedRole.Rules, namespacedRole.ExcludeResources, namespacedRole.IncludeNewResources)
+		for _, ns := range b.permissionNamespaces(namespacedRole) {
+			if !slices.Contains(namespaces, ns) {
+				namespaces = append(namespaces, ns)
+			}
+			rulesByNamespace[ns] = appendRules(rulesByNamespace[ns], rules...)
+		}
+	}
+
+	for _, ns := range namespaces {
+		role := b.role(b.ObjectName(), ns, b.rules(rulesByNamespace[ns]))
 		objs = append(objs, role)
 
 		roleRef := rbacv1.RoleRef{
@@ -60,47 +235,158 @@ func (b *builder) roleAndBindings() []client.Object {
 			Name:     role.Name,
 		}
 
-		objs = append(objs, b.roleBinding(roleRef, namespacedRole.Namespace))
+		objs = append(objs, b.roleBinding(role.Name, ns, roleRef))
 	}
 
 	return objs
 }
 
-func (b *builder) role(kubeconfig *v1alpha1.Kubeconfig, ns string, rules []rbacv1.PolicyRule) *rbacv1.Role {
+// appendRules appends the rules that aren't contained in the existing rules yet.
+func appendRules(existing []rbacv1.PolicyRule, rules ...rbacv1.PolicyRule) []rbacv1.PolicyRule {
+	for _, rule := range rules {
+		if !slices.ContainsFunc(existing, func(r rbacv1.PolicyRule) bool { return equality.Semantic.DeepEqual(r, rule) }) {
+			existing = append(existing, rule)
+		}
+	}
+	return existing
+}
+
+// CoveredNamespaces returns the sorted namespaces the namespaced permissions apply to.
+func (b *builder) CoveredNamespaces() []string {
+	namespaces := sets.New[string]()
+	for _, namespacedRole := range b.kubeconfig.Spec.NamespacedPermissions {
+		namespaces.Insert(b.permissionNamespaces(namespacedRole)...)
+	}
+	return sets.List(namespaces)
+}
+
+// MergedNamespaces returns the sorted namespaces more than one namespaced permission applies to, either listed
+// multiple times or matched by a selector and another entry. Their rules are merged into a single role.
+func (b *builder) MergedNamespaces() []string {
+	entries := map[string]int{}
+	merged := sets.New[string]()
+	for _, namespacedRole := range b.kubeconfig.Spec.NamespacedPermissions {
+		for _, ns := range b.permissionNamespaces(namespacedRole) {
+			entries[ns]++
+			if entries[ns] > 1 {
+				merged.Insert(ns)
+			}
+		}
+	}
+	return sets.List(merged)
+}
+
+// permissionNamespaces returns the namespaces the namespaced permission applies to. Namespaces that are being deleted
+// are skipped as no objects can be created in them. Permissions that aren't active don't apply to any namespace.
+func (b *builder) permissionNamespaces(namespacedRole v1alpha1.NamespacedPermissions) []string {
+	if !b.active(namespacedRole.NotBefore, namespacedRole.ValidUntil) {
+		return nil
+	}
+	if namespacedRole.NamespaceSelector == nil {
+		return []string{namespacedRole.Namespace}
+	}
+
+	selector, err := metav1.LabelSelectorAsSelector(namespacedRole.NamespaceSelector)
+	if err != nil {
+		// invalid selectors are rejected by the validate-spec state of the reconciler
+		return nil
+	}
+
+	var namespaces []string
+	for _, ns := range b.namespaces {
+		if ns.DeletionTimestamp != nil || ns.Status.Phase == corev1.NamespaceTerminating {
+			continue
+		}
+		if selector.Matches(labels.Set(ns.GetLabels())) {
+			namespaces = append(namespaces, ns.GetName())
+		}
+	}
+	return namespaces
+}
+
+// ScheduledPermissions returns the permissions limited by notBefore or validUntil that are active or upcoming.
+func (b *builder) ScheduledPermissions() []v1alpha1.ScheduledPermissions {
+	var scheduled []v1alpha1.ScheduledPermissions
+	add := func(path, namespace string, notBefore, validUntil *metav1.Time) {
+		if (notBefore == nil && validUntil == nil) || (validUntil != nil && !b.now.Before(validUntil.Time)) {
+			return
+		}
+		scheduled = append(scheduled, v1alpha1.ScheduledPermissions{
+			Permissions: path,
+			Namespace:   namespace,
+			NotBefore:   notBefore,
+			ValidUntil:  validUntil,
+			Active:      b.active(notBefore, validUntil),
+		})
+	}
+
+	for i, namespacedRole := range b.kubeconfig.Spec.NamespacedPermissions {
+		add(fmt.Sprintf("namespacedPermissions[%d]", i), namespacedRole.Namespace, namespacedRole.NotBefore, namespacedRole.ValidUntil)
+	}
+	if clusterPermissions := b.kubeconfig.Spec.ClusterPermissions; clusterPermissions != nil {
+		add("clusterPermissions", "", clusterPermissions.NotBefore, clusterPermissions.ValidUntil)
+	}
+	return scheduled
+}
+
+// NextPermissionsChange returns the next time scheduled permissions are granted or removed. It returns the zero time
+// if no change is scheduled.
+func (b *builder) NextPermissionsChange() time.Time {
+	var next time.Time
+	for _, permissions := range b.ScheduledPermissions() {
+		for _, t := range []*metav1.Time{permissions.NotBefore, permissions.ValidUntil} {
+			if t != nil && t.Time.After(b.now) && (next.IsZero() || t.Time.Before(next)) {
+				next = t.Time
+			}
+		}
+	}
+	return next
+}
+
+// active reports whether permissions limited by the given times are granted at the moment.
+func (b *builder) active(notBefore, validUntil *metav1.Time) bool {
+	return (notBefore == nil || !b.now.Before(notBefore.Time)) && (validUntil == nil || b.now.Before(validUntil.Time))
+}
+
+// UsesNamespaceSelectors reports whether any namespaced permission selects namespaces by labels.
+func (b *builder) UsesNamespaceSelectors() bool {
+	for _, namespacedRole := range b.kubeconfig.Spec.NamespacedPermissions {
+		if namespacedRole.NamespaceSelector != nil {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *builder) role(name, ns string, rules []rbacv1.PolicyRule) *rbacv1.Role {
 	return &rbacv1.Role{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      kubeconfig.GetName(),
+			Name:      name,
 			Namespace: ns,
 		},
 		Rules: rules,
 	}
 }
 
-func (b *builder) roleBinding(roleRef rbacv1.RoleRef, ns string) *rbacv1.RoleBinding {
-	sa := b.ServiceAccount()
+func (b *builder) roleBinding(name, ns string, roleRef rbacv1.RoleRef) *rbacv1.RoleBinding {
 	return &rbacv1.RoleBinding{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      b.kubeconfig.GetName(),
+			Name:      name,
 			Namespace: ns,
 		},
-		RoleRef: roleRef,
-		Subjects: []rbacv1.Subject{
-			{
-				Kind:      rbacv1.ServiceAccountKind,
-				Name:      sa.GetName(),
-				Namespace: sa.GetNamespace(),
-			},
-		},
+		RoleRef:  roleRef,
+		Subjects: []rbacv1.Subject{b.subject()},
 	}
 }
 
 func (b *builder) clusterRoleAndBinding() []client.Object {
 	var objs []client.Object
-	if b.kubeconfig.Spec.ClusterPermissions == nil {
+	clusterPermissions := b.kubeconfig.Spec.ClusterPermissions
+	if clusterPermissions == nil || !b.active(clusterPermissions.NotBefore, clusterPermissions.ValidUntil) {
 		return nil
 	}
 
-	clusterRole := b.clusterRole(b.kubeconfig.Spec.ClusterPermissions.Rules)
+	clusterRole := b.clusterRole(b.ObjectName(), b.rules(b.expand(clusterPermissions.Rules, clusterPermissions.ExcludeResources, clusterPermissions.IncludeNewResources)))
 	objs = append(objs, clusterRole)
 
 	roleRef := rbacv1.RoleRef{
@@ -108,35 +394,377 @@ func (b *builder) clusterRoleAndBinding() []client.Object {
 		Kind:     "ClusterRole",
 		Name:     clusterRole.Name,
 	}
-	objs = append(objs, b.clusterRoleBinding(roleRef))
+	objs = append(objs, b.clusterRoleBinding(clusterRole.Name, roleRef))
 
 	return objs
 }
 
-func (b *builder) clusterRole(rules []rbacv1.PolicyRule) *rbacv1.ClusterRole {
+func (b *builder) clusterRole(name string, rules []rbacv1.PolicyRule) *rbacv1.ClusterRole {
 	return &rbacv1.ClusterRole{
 		ObjectMeta: metav1.ObjectMeta{
-			// NOTE: ClusterRoles are cluster-scoped objects so we qualify the name with the namespace to avoid colliding names
-			Name: fmt.Sprintf("%s-%s", b.kubeconfig.GetName(), b.kubeconfig.GetNamespace()),
+			Name: name,
 		},
 		Rules: rules,
 	}
 }
 
-func (b *builder) clusterRoleBinding(roleRef rbacv1.RoleRef) *rbacv1.ClusterRoleBinding {
-	sa := b.ServiceAccount()
+func (b *builder) clusterRoleBinding(name string, roleRef rbacv1.RoleRef) *rbacv1.ClusterRoleBinding {
 	return &rbacv1.ClusterRoleBinding{
 		ObjectMeta: metav1.ObjectMeta{
-			// NOTE: ClusterRoles are cluster-scoped objects so we qualify the name with the namespace to avoid colliding names
-			Name: fmt.Sprintf("%s-%s", b.kubeconfig.GetName(), b.kubeconfig.GetNamespace()),
-		},
-		RoleRef: roleRef,
-		Subjects: []rbacv1.Subject{
-			{
-				Kind:      rbacv1.ServiceAccountKind,
-				Name:      sa.GetName(),
-				Namespace: sa.GetNamespace(),
-			},
+			Name: name,
 		},
+		RoleRef:  roleRef,
+		Subjects: []rbacv1.Subject{b.subject()},
+	}
+}
+
+// roleRefBindings binds the existing roles referenced by the kubeconfig. The binding names contain the kind and name
+// of the referenced role so they don't collide with the bindings of the inline permissions.
+func (b *builder) roleRefBindings() []client.Object {
+	var objs []client.Object
+
+	for _, ref := range b.kubeconfig.Spec.RoleRefs {
+		roleRef := rbacv1.RoleRef{
+			APIGroup: rbacv1.GroupName,
+			Kind:     ref.Kind,
+			Name:     ref.Name,
+		}
+		name := b.ObjectName(strings.ToLower(ref.Kind), ref.Name)
+
+		if ref.Namespace != "" {
+			objs = append(objs, b.roleBinding(name, ref.Namespace, roleRef))
+			continue
+		}
+		if ref.Kind != "ClusterRole" {
+			// Roles are always bound within their namespace, the CRD rejects Roles without a namespace
+			continue
+		}
+		objs = append(objs, b.clusterRoleBinding(name, roleRef))
+	}
+
+	return objs
+}
+
+// presetRolesAndBindings creates a role and binding for every preset that grants rules. The names contain the preset
+// so they don't collide with the roles of the inline permissions.
+func (b *builder) presetRolesAndBindings() []client.Object {
+	var objs []client.Object
+
+	for _, preset := range b.ExpandedPresets() {
+		if len(preset.Rules) == 0 {
+			continue
+		}
+		name := b.ObjectName("preset", strings.ToLower(string(preset.Name)))
+
+		if preset.Namespace == "" {
+			clusterRole := b.clusterRole(name, preset.Rules)
+			roleRef := rbacv1.RoleRef{
+				APIGroup: rbacv1.GroupName,
+				Kind:     "ClusterRole",
+				Name:     clusterRole.Name,
+			}
+			objs = append(objs, clusterRole, b.clusterRoleBinding(clusterRole.Name, roleRef))
+			continue
+		}
+
+		role := b.role(name, preset.Namespace, preset.Rules)
+		roleRef := rbacv1.RoleRef{
+			APIGroup: rbacv1.GroupName,
+			Kind:     "Role",
+			Name:     role.Name,
+		}
+		objs = append(objs, role, b.roleBinding(role.Name, preset.Namespace, roleRef))
+	}
+
+	return objs
+}
+
+// impersonationRolesAndBindings grants the permission to impersonate the identity of spec.impersonate. Service account
+// users are impersonated via the serviceaccounts resource of their namespace and get a separate role in it.
+func (b *builder) impersonationRolesAndBindings() []client.Object {
+	var objs []client.Object
+
+	clusterRules, serviceAccountNamespace, serviceAccountRules := b.ImpersonationRules()
+	name := b.ObjectName("impersonate")
+	if len(clusterRules) > 0 {
+		clusterRole := b.clusterRole(name, clusterRules)
+		roleRef := rbacv1.RoleRef{
+			APIGroup: rbacv1.GroupName,
+			Kind:     "ClusterRole",
+			Name:     clusterRole.Name,
+		}
+		objs = append(objs, clusterRole, b.clusterRoleBinding(clusterRole.Name, roleRef))
+	}
+	if len(serviceAccountRules) > 0 {
+		role := b.role(name, serviceAccountNamespace, serviceAccountRules)
+		roleRef := rbacv1.RoleRef{
+			APIGroup: rbacv1.GroupName,
+			Kind:     "Role",
+			Name:     role.Name,
+		}
+		objs = append(objs, role, b.roleBinding(role.Name, serviceAccountNamespace, roleRef))
+	}
+
+	return objs
+}
+
+// ImpersonationRules returns the rules allowing to impersonate exactly the identity of spec.impersonate. The rule for
+// a service account user only applies in the namespace of the service account and is returned separately.
+func (b *builder) ImpersonationRules() (
+	clusterRules []rbacv1.PolicyRule,
+	serviceAccountNamespace string,
+	serviceAccountRules []rbacv1.PolicyRule,
+) {
+	impersonate := b.kubeconfig.Spec.Impersonate
+	if impersonate == nil {
+		return nil, "", nil
+	}
+
+	if namespace, name, ok := splitServiceAccountUser(impersonate.User); ok {
+		serviceAccountNamespace = namespace
+		serviceAccountRules = append(serviceAccountRules, impersonateRule("", "serviceaccounts", name))
+	} else {
+		clusterRules = append(clusterRules, impersonateRule("", "users", impersonate.User))
+	}
+	if len(impersonate.Groups) > 0 {
+		clusterRules = append(clusterRules, impersonateRule("", "groups", impersonate.Groups...))
+	}
+	keys := make([]string, 0, len(impersonate.Extra))
+	for key := range impersonate.Extra {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	for _, key := range keys {
+		if len(impersonate.Extra[key]) > 0 {
+			clusterRules = append(clusterRules, impersonateRule("authentication.k8s.io", "userextras/"+key, impersonate.Extra[key]...))
+		}
+	}
+
+	return clusterRules, serviceAccountNamespace, serviceAccountRules
+}
+
+func impersonateRule(apiGroup, resource string, names ...string) rbacv1.PolicyRule {
+	return rbacv1.PolicyRule{
+		APIGroups:     []string{apiGroup},
+		Resources:     []string{resource},
+		ResourceNames: names,
+		Verbs:         []string{"impersonate"},
+	}
+}
+
+// splitServiceAccountUser returns the namespace and name of a service account user of the form
+// system:serviceaccount:<namespace>:<name>.
+func splitServiceAccountUser(user string) (namespace, name string, ok bool) {
+	parts := strings.Split(strings.TrimPrefix(user, "system:serviceaccount:"), ":")
+	if !strings.HasPrefix(user, "system:serviceaccount:") || len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
+
+// ExpandedPresets returns the rules of every preset of the kubeconfig after applying secretsDenied.
+func (b *builder) ExpandedPresets() []v1alpha1.ExpandedPreset {
+	var expanded []v1alpha1.ExpandedPreset
+	for _, preset := range b.kubeconfig.Spec.Presets {
+		rules, err := presets.Rules(preset)
+		if err != nil {
+			// unknown presets are rejected by the validate-spec state of the reconciler
+			continue
+		}
+		expanded = append(expanded, v1alpha1.ExpandedPreset{
+			Name:      preset.Name,
+			Namespace: preset.Namespace,
+			Version:   max(preset.Version, 1),
+			Rules:     b.rules(rules),
+		})
+	}
+	return expanded
+}
+
+// ValidatePresets returns an error if a preset is unknown or secretsDenied can't be applied to the permissions.
+func (b *builder) ValidatePresets() error {
+	ruleSets := [][]rbacv1.PolicyRule{}
+	for _, preset := range b.kubeconfig.Spec.Presets {
+		rules, err := presets.Rules(preset)
+		if err != nil {
+			return err
+		}
+		ruleSets = append(ruleSets, rules)
+	}
+	if !b.secretsDenied() {
+		return nil
+	}
+
+	// wildcards of rules excluding resources are expanded into the served resources which can always be restricted
+	for _, namespacedRole := range b.kubeconfig.Spec.NamespacedPermissions {
+		if len(namespacedRole.ExcludeResources) == 0 {
+			ruleSets = append(ruleSets, namespacedRole.Rules)
+		}
+	}
+	if clusterPermissions := b.kubeconfig.Spec.ClusterPermissions; clusterPermissions != nil && len(clusterPermissions.ExcludeResources) == 0 {
+		ruleSets = append(ruleSets, clusterPermissions.Rules)
+	}
+	for _, rules := range ruleSets {
+		if _, err := presets.WithoutSecrets(rules); err != nil {
+			return fmt.Errorf("preset %q: %w", v1alpha1.PresetSecretsDenied, err)
+		}
+	}
+	return nil
+}
+
+// UnknownResources returns a description of every API group and resource of the namespaced and cluster permissions
+// the API server doesn't serve. Nothing is reported until the API resources have been set.
+func (b *builder) UnknownResources() []string {
+	if b.resources == nil {
+		return nil
+	}
+
+	var unknown []string
+	for _, rules := range b.permissionRules() {
+		for _, description := range b.resources.Unknown(rules) {
+			if !slices.Contains(unknown, description) {
+				unknown = append(unknown, description)
+			}
+		}
+	}
+	return unknown
+}
+
+// ValidateExclusions returns an error if the rules excluding resources can't be expanded into the API resources.
+func (b *builder) ValidateExclusions() error {
+	if !b.UsesExcludeResources() {
+		return nil
+	}
+	if b.resources == nil {
+		return fmt.Errorf("API resources haven't been discovered")
+	}
+	for _, namespacedRole := range b.kubeconfig.Spec.NamespacedPermissions {
+		if len(namespacedRole.ExcludeResources) == 0 {
+			continue
+		}
+		resources := b.expansionResources(namespacedRole.IncludeNewResources)
+		if _, err := resources.Expand(namespacedRole.Rules, namespacedRole.ExcludeResources); err != nil {
+			return err
+		}
+	}
+	if clusterPermissions := b.kubeconfig.Spec.ClusterPermissions; clusterPermissions != nil && len(clusterPermissions.ExcludeResources) > 0 {
+		resources := b.expansionResources(clusterPermissions.IncludeNewResources)
+		if _, err := resources.Expand(clusterPermissions.Rules, clusterPermissions.ExcludeResources); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UsesExcludeResources reports whether any permissions exclude resources and therefore depend on the API resources.
+func (b *builder) UsesExcludeResources() bool {
+	for _, namespacedRole := range b.kubeconfig.Spec.NamespacedPermissions {
+		if len(namespacedRole.ExcludeResources) > 0 {
+			return true
+		}
+	}
+	clusterPermissions := b.kubeconfig.Spec.ClusterPermissions
+	return clusterPermissions != nil && len(clusterPermissions.ExcludeResources) > 0
+}
+
+func (b *builder) permissionRules() [][]rbacv1.PolicyRule {
+	var ruleSets [][]rbacv1.PolicyRule
+	for _, namespacedRole := range b.kubeconfig.Spec.NamespacedPermissions {
+		ruleSets = append(ruleSets, namespacedRole.Rules)
+	}
+	if b.kubeconfig.Spec.ClusterPermissions != nil {
+		ruleSets = append(ruleSets, b.kubeconfig.Spec.ClusterPermissions.Rules)
+	}
+	return ruleSets
+}
+
+// ExpandedResources returns the resources rules excluding resources without includeNewResources are expanded into.
+// The resources recorded in the status are kept until the namespaced or cluster permissions change, which the webhook
+// checks against the resources served at that time. Otherwise, the served resources are recorded. Nil if no rules
+// depend on them or the API resources haven't been set.
+func (b *builder) ExpandedResources() *v1alpha1.ExpandedResources {
+	if !b.freezesResources() {
+		return nil
+	}
+	hash := b.permissionsHash()
+	if recorded := b.kubeconfig.Status.ExpandedResources; recorded != nil && recorded.PermissionsHash == hash {
+		return recorded
+	}
+	if b.resources == nil {
+		return nil
+	}
+	return &v1alpha1.ExpandedResources{PermissionsHash: hash, Resources: b.resources.Qualified()}
+}
+
+// freezesResources reports whether any permissions exclude resources without including resources served later.
+func (b *builder) freezesResources() bool {
+	for _, namespacedRole := range b.kubeconfig.Spec.NamespacedPermissions {
+		if len(namespacedRole.ExcludeResources) > 0 && !namespacedRole.IncludeNewResources {
+			return true
+		}
+	}
+	clusterPermissions := b.kubeconfig.Spec.ClusterPermissions
+	return clusterPermissions != nil && len(clusterPermissions.ExcludeResources) > 0 && !clusterPermissions.IncludeNewResources
+}
+
+// permissionsHash returns a hash of the namespaced and cluster permissions.
+func (b *builder) permissionsHash() string {
+	// marshalling the spec types can't fail
+	data, _ := json.Marshal([]any{b.kubeconfig.Spec.NamespacedPermissions, b.kubeconfig.Spec.ClusterPermissions})
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:8])
+}
+
+// expansionResources returns the API resources rules excluding resources are expanded into. Unless resources served
+// later are included, they are limited to the recorded expanded resources. The served resources are used if nothing
+// has been recorded because no permissions freeze them.
+func (b *builder) expansionResources(includeNew bool) *permissions.APIResources {
+	if b.resources == nil || includeNew {
+		return b.resources
+	}
+	expanded := b.ExpandedResources()
+	if expanded == nil {
+		return b.resources
+	}
+	return b.resources.Only(expanded.Resources)
+}
+
+// expand removes the excluded resources from the rules. Nothing is granted by rules excluding resources until the API
+// resources have been set, as granting the wildcard instead would include the excluded resources.
+func (b *builder) expand(rules []rbacv1.PolicyRule, exclude []string, includeNew bool) []rbacv1.PolicyRule {
+	if len(exclude) == 0 {
+		return rules
+	}
+	resources := b.expansionResources(includeNew)
+	if resources == nil {
+		return nil
+	}
+	expanded, err := resources.Expand(rules, exclude)
+	if err != nil {
+		// rules that can't be expanded are rejected by the provision-service-account state of the reconciler
+		return nil
+	}
+	return expanded
+}
+
+// rules returns the rules without access to secrets if the secretsDenied preset is set.
+func (b *builder) rules(rules []rbacv1.PolicyRule) []rbacv1.PolicyRule {
+	if !b.secretsDenied() {
+		return rules
+	}
+	filtered, err := presets.WithoutSecrets(rules)
+	if err != nil {
+		// rules that can't be restricted are rejected by the validate-spec state of the reconciler
+		return nil
+	}
+	return filtered
+}
+
+func (b *builder) secretsDenied() bool {
+	for _, preset := range b.kubeconfig.Spec.Presets {
+		if preset.Name == v1alpha1.PresetSecretsDenied {
+			return true
+		}
 	}
+	return false
 }
@@ -0,0 +1,166 @@
+package serviceaccount
+
+import (
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	clienttesting "k8s.io/client-go/testing"
+
+	"github.com/klaudworks/kubeconfig-operator/api/klaud.works/v1alpha1"
+	"github.com/klaudworks/kubeconfig-operator/internal/permissions"
+)
+
+var _ = Describe("Builder", func() {
+	var kubeconfig *v1alpha1.Kubeconfig
+
+	BeforeEach(func() {
+		kubeconfig = &v1alpha1.Kubeconfig{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "long-names",
+				Namespace: "default",
+			},
+			Spec: v1alpha1.KubeconfigSpec{
+				Server:      "https://kubernetes.example.com",
+				ClusterName: "kubernetes",
+			},
+		}
+	})
+
+	It("should keep names within the maximum length unchanged", func() {
+		name := NewBuilder(kubeconfig).ObjectName("clusterrole", "view")
+		Expect(name).To(HavePrefix("long-names-"))
+		Expect(name).To(HaveSuffix("-clusterrole-view"))
+	})
+
+	It("should truncate names exceeding the maximum length and keep them unique", func() {
+		longName := strings.Repeat("a", validation.DNS1123SubdomainMaxLength)
+		kubeconfig.Spec.RoleRefs = []v1alpha1.RoleRef{
+			{Kind: "ClusterRole", Name: longName},
+			{Kind: "ClusterRole", Name: longName[:validation.DNS1123SubdomainMaxLength-1] + "b"},
+			{Kind: "Role", Name: longName, Namespace: "default"},
+		}
+
+		var clusterRoleBindings []string
+		for _, obj := range NewBuilder(kubeconfig).Build() {
+			Expect(validation.IsDNS1123Subdomain(obj.GetName())).To(BeEmpty(), obj.GetName())
+			Expect(obj.GetName()).To(HavePrefix("long-names"))
+			if _, ok := obj.(*rbacv1.ClusterRoleBinding); ok {
+				clusterRoleBindings = append(clusterRoleBindings, obj.GetName())
+			}
+		}
+		Expect(clusterRoleBindings).To(HaveLen(2))
+		Expect(clusterRoleBindings[0]).NotTo(Equal(clusterRoleBindings[1]))
+
+		By("truncating names of kubeconfigs with the maximum length")
+		kubeconfig.Name = longName
+		name := NewBuilder(kubeconfig).ObjectName("impersonate")
+		Expect(validation.IsDNS1123Subdomain(name)).To(BeEmpty())
+		Expect(name).NotTo(Equal(NewBuilder(kubeconfig).ObjectName()))
+	})
+	It("should expand wildcards into the recorded resources until the permissions change", func() {
+		discover := func(resources ...metav1.APIResource) *permissions.APIResources {
+			apiResources, err := permissions.Discover(&fakediscovery.FakeDiscovery{Fake: &clienttesting.Fake{
+				Resources: []*metav1.APIResourceList{{GroupVersion: "v1", APIResources: resources}},
+			}})
+			Expect(err).NotTo(HaveOccurred())
+			return apiResources
+		}
+		clusterRules := func(builder *builder) []rbacv1.PolicyRule {
+			for _, obj := range builder.Build() {
+				if clusterRole, ok := obj.(*rbacv1.ClusterRole); ok {
+					return clusterRole.Rules
+				}
+			}
+			return nil
+		}
+		kubeconfig.Spec.ClusterPermissions = &v1alpha1.ClusterPermissions{
+			Rules:            []rbacv1.PolicyRule{{APIGroups: []string{""}, Resources: []string{"*"}, Verbs: []string{"get"}}},
+			ExcludeResources: []string{"secrets"},
+		}
+
+		builder := NewBuilder(kubeconfig).WithAPIResources(discover(metav1.APIResource{Name: "pods"}, metav1.APIResource{Name: "secrets"}))
+		kubeconfig.Status.ExpandedResources = builder.ExpandedResources()
+		Expect(kubeconfig.Status.ExpandedResources.Resources).To(Equal([]string{"pods", "secrets"}))
+
+		By("not granting resources served later")
+		served := discover(metav1.APIResource{Name: "pods"}, metav1.APIResource{Name: "secrets"}, metav1.APIResource{Name: "configmaps"})
+		builder = NewBuilder(kubeconfig).WithAPIResources(served)
+		Expect(builder.ExpandedResources()).To(Equal(kubeconfig.Status.ExpandedResources))
+		Expect(clusterRules(builder)).To(Equal([]rbacv1.PolicyRule{
+			{APIGroups: []string{""}, Resources: []string{"pods"}, Verbs: []string{"get"}},
+		}))
+
+		By("granting resources served later if they are included")
+		kubeconfig.Spec.ClusterPermissions.IncludeNewResources = true
+		builder = NewBuilder(kubeconfig).WithAPIResources(served)
+		Expect(builder.ExpandedResources()).To(BeNil())
+		Expect(clusterRules(builder)).To(Equal([]rbacv1.PolicyRule{
+			{APIGroups: []string{""}, Resources: []string{"configmaps", "pods"}, Verbs: []string{"get"}},
+		}))
+
+		By("recording the served resources once the permissions change")
+		kubeconfig.Spec.ClusterPermissions.IncludeNewResources = false
+		kubeconfig.Spec.ClusterPermissions.Rules[0].Verbs = []string{"get", "list"}
+		Expect(NewBuilder(kubeconfig).WithAPIResources(served).ExpandedResources().Resources).To(Equal([]string{"configmaps", "pods", "secrets"}))
+	})
+	It("should expand permissions including new resources next to permissions without exclusions", func() {
+		apiResources, err := permissions.Discover(&fakediscovery.FakeDiscovery{Fake: &clienttesting.Fake{
+			Resources: []*metav1.APIResourceList{{
+				GroupVersion: "v1",
+				APIResources: []metav1.APIResource{{Name: "pods"}, {Name: "secrets"}},
+			}},
+		}})
+		Expect(err).NotTo(HaveOccurred())
+		kubeconfig.Spec.NamespacedPermissions = []v1alpha1.NamespacedPermissions{
+			{
+				Namespace:           "default",
+				Rules:               []rbacv1.PolicyRule{{APIGroups: []string{""}, Resources: []string{"*"}, Verbs: []string{"get"}}},
+				ExcludeResources:    []string{"secrets"},
+				IncludeNewResources: true,
+			},
+			{
+				Namespace: "kube-public",
+				Rules:     []rbacv1.PolicyRule{{APIGroups: []string{""}, Resources: []string{"configmaps"}, Verbs: []string{"get"}}},
+			},
+		}
+		kubeconfig.Spec.ClusterPermissions = &v1alpha1.ClusterPermissions{
+			Rules: []rbacv1.PolicyRule{{APIGroups: []string{""}, Resources: []string{"namespaces"}, Verbs: []string{"list"}}},
+		}
+
+		builder := NewBuilder(kubeconfig).WithAPIResources(apiResources)
+		Expect(builder.ValidateExclusions()).To(Succeed())
+		Expect(builder.ExpandedResources()).To(BeNil())
+
+		var roleRules [][]rbacv1.PolicyRule
+		for _, obj := range builder.Build() {
+			if role, ok := obj.(*rbacv1.Role); ok && role.Namespace == "default" {
+				roleRules = append(roleRules, role.Rules)
+			}
+		}
+		Expect(roleRules).To(Equal([][]rbacv1.PolicyRule{{
+			{APIGroups: []string{""}, Resources: []string{"pods"}, Verbs: []string{"get"}},
+		}}))
+	})
+
+	It("should report namespaces matched by a selector and a literal name as merged", func() {
+		preview := func(name string) corev1.Namespace {
+			return corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{"env": "preview"}}}
+		}
+		rules := []rbacv1.PolicyRule{{APIGroups: []string{""}, Resources: []string{"pods"}, Verbs: []string{"get"}}}
+		kubeconfig.Spec.NamespacedPermissions = []v1alpha1.NamespacedPermissions{
+			{NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"env": "preview"}}, Rules: rules},
+			{Namespace: "preview-b", Rules: rules},
+			{Namespace: "production", Rules: rules},
+		}
+
+		builder := NewBuilder(kubeconfig).WithNamespaces([]corev1.Namespace{preview("preview-a"), preview("preview-b")})
+		Expect(builder.CoveredNamespaces()).To(Equal([]string{"preview-a", "preview-b", "production"}))
+		Expect(builder.MergedNamespaces()).To(Equal([]string{"preview-b"}))
+	})
+})
@@ -18,3 +18,10 @@ func CRDPaths() []string {
 		filepath.Join(RootDir(), "manifests", "crd", "bases"),
 	}
 }
+
+// WebhookPaths returns the paths to this project's webhook configurations
+func WebhookPaths() []string {
+	return []string{
+		filepath.Join(RootDir(), "manifests", "webhook", "manifests.yaml"),
+	}
+}
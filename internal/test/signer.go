@@ -0,0 +1,139 @@
+package test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"time"
+
+	certificatesv1 "k8s.io/api/certificates/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// CertificateSigner signs approved CertificateSigningRequests of the kubernetes.io/kube-apiserver-client signer
+// similar to the kube-controller-manager which doesn't run in envtest.
+type CertificateSigner struct {
+	kubeClient kubernetes.Interface
+	caCert     *x509.Certificate
+	caKey      *ecdsa.PrivateKey
+
+	// MaxDuration caps the lifetime of issued certificates if set.
+	MaxDuration time.Duration
+}
+
+// NewCertificateSigner returns a signer with a new self-signed CA.
+func NewCertificateSigner(kubeClient kubernetes.Interface) (*CertificateSigner, error) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-signer"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, template, template, caKey.Public(), caKey)
+	if err != nil {
+		return nil, err
+	}
+	caCert, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CertificateSigner{
+		kubeClient: kubeClient,
+		caCert:     caCert,
+		caKey:      caKey,
+	}, nil
+}
+
+// Run signs approved requests until the context is done.
+func (s *CertificateSigner) Run(ctx context.Context) {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		csrs, err := s.kubeClient.CertificatesV1().CertificateSigningRequests().List(ctx, metav1.ListOptions{})
+		if err != nil {
+			continue
+		}
+		for i := range csrs.Items {
+			csr := &csrs.Items[i]
+			if csr.Spec.SignerName != certificatesv1.KubeAPIServerClientSignerName || len(csr.Status.Certificate) > 0 || !approved(csr) {
+				continue
+			}
+			// failed requests are retried on the next tick
+			_ = s.sign(ctx, csr)
+		}
+	}
+}
+
+// sign issues the certificate for the request. Like the kube-controller-manager, the NotBefore is backdated by five
+// minutes.
+func (s *CertificateSigner) sign(ctx context.Context, csr *certificatesv1.CertificateSigningRequest) error {
+	block, _ := pem.Decode(csr.Spec.Request)
+	if block == nil {
+		return fmt.Errorf("invalid request")
+	}
+	request, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return err
+	}
+
+	duration := 24 * time.Hour
+	if csr.Spec.ExpirationSeconds != nil {
+		duration = time.Duration(*csr.Spec.ExpirationSeconds) * time.Second
+	}
+	if s.MaxDuration > 0 && duration > s.MaxDuration {
+		duration = s.MaxDuration
+	}
+	now := time.Now().Truncate(time.Second)
+
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		return err
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      request.Subject,
+		NotBefore:    now.Add(-5 * time.Minute),
+		NotAfter:     now.Add(duration),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, s.caCert, request.PublicKey, s.caKey)
+	if err != nil {
+		return err
+	}
+
+	csr.Status.Certificate = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER})
+	_, err = s.kubeClient.CertificatesV1().CertificateSigningRequests().UpdateStatus(ctx, csr, metav1.UpdateOptions{})
+	return err
+}
+
+func approved(csr *certificatesv1.CertificateSigningRequest) bool {
+	for _, condition := range csr.Status.Conditions {
+		if condition.Type == certificatesv1.CertificateApproved && condition.Status == corev1.ConditionTrue {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,313 @@
+package token
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	certificatesv1 "k8s.io/api/certificates/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// certificateBackdate is the duration the kube-controller-manager signer backdates the NotBefore of certificates
+	// to tolerate clock skew.
+	certificateBackdate = 5 * time.Minute
+
+	// certificatePendingTimeout is how long the signer may take to issue a certificate before the request is given up.
+	certificatePendingTimeout = 30 * time.Second
+)
+
+// ClientCertificateProvider issues client certificates via the CertificateSigningRequest API using the
+// kubernetes.io/kube-apiserver-client signer. The provider approves its own requests.
+type ClientCertificateProvider struct {
+	kubeClient     kubernetes.Interface
+	pendingTimeout time.Duration
+
+	mu sync.Mutex
+	// pending are the requests waiting for their certificate by user. The private key of a request is only kept here,
+	// so requests pending during a restart are abandoned and cleaned up by the API server.
+	pending map[string]*pendingCertificate
+}
+
+// pendingCertificate is a signing request waiting for the signer to issue its certificate.
+type pendingCertificate struct {
+	name              string
+	keyDER            []byte
+	groups            []string
+	expirationSeconds int64
+	requestedAt       time.Time
+}
+
+var _ Provider = &ClientCertificateProvider{}
+
+// NewClientCertificateProvider returns a provider that requests certificates with the given client.
+func NewClientCertificateProvider(kubeClient kubernetes.Interface) *ClientCertificateProvider {
+	return &ClientCertificateProvider{
+		kubeClient:     kubeClient,
+		pendingTimeout: certificatePendingTimeout,
+		pending:        map[string]*pendingCertificate{},
+	}
+}
+
+// Ensure returns the existing certificate if it can be reused, otherwise it requests a new certificate. It returns
+// ErrCredentialPending until the signer issued the requested certificate.
+func (p *ClientCertificateProvider) Ensure(ctx context.Context, existing string, config EnsureConfig) (*TokenInfo, error) {
+	existingInfo, rotationReason := p.checkCertificate(ctx, existing, config)
+	if rotationReason == "" {
+		return existingInfo, nil
+	}
+
+	certInfo, err := p.requestCertificate(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+	certInfo.RotationReason = rotationReason
+	certInfo.Previous = existingInfo
+
+	return certInfo, nil
+}
+
+// Revoke is a no-op as the API server doesn't support revoking client certificates. Instead, the user a certificate
+// is issued for changes on revocation so the previous certificates lose their permissions once the role bindings
+// refer to the new user.
+func (p *ClientCertificateProvider) Revoke(context.Context, EnsureConfig) error {
+	return nil
+}
+
+// Parse decodes the PEM encoded certificate and private key and extracts the validity and subject of the certificate.
+// The certificate isn't verified against the cluster CA.
+func (p *ClientCertificateProvider) Parse(_ context.Context, credential string) (*TokenInfo, error) {
+	var certPEM, keyPEM []byte
+	rest := []byte(credential)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		switch block.Type {
+		case "CERTIFICATE":
+			certPEM = append(certPEM, pem.EncodeToMemory(block)...)
+		case "EC PRIVATE KEY", "RSA PRIVATE KEY", "PRIVATE KEY":
+			keyPEM = pem.EncodeToMemory(block)
+		}
+	}
+
+	keyPair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse client certificate: %w", err)
+	}
+	cert, err := x509.ParseCertificate(keyPair.Certificate[0])
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse client certificate: %w", err)
+	}
+
+	return &TokenInfo{
+		Token:             credential,
+		IssuedAt:          cert.NotBefore,
+		ExpiresAt:         cert.NotAfter,
+		ClientCertificate: certPEM,
+		ClientKey:         keyPEM,
+		User:              cert.Subject.CommonName,
+		Groups:            cert.Subject.Organization,
+	}, nil
+}
+
+// checkCertificate checks whether the existing certificate can be reused. It returns the parsed certificate and an
+// empty reason if the certificate was issued for the desired subject, it was requested with ExpirationSeconds
+// and it is not yet due for refresh. Otherwise, it returns the reason why a new certificate has to be requested.
+func (p *ClientCertificateProvider) checkCertificate(
+	ctx context.Context,
+	existing string,
+	config EnsureConfig,
+) (*TokenInfo, RotationReason) {
+	if existing == "" {
+		return nil, RotationReasonNoToken
+	}
+
+	certInfo, err := p.Parse(ctx, existing)
+	if err != nil {
+		return &TokenInfo{Token: existing}, RotationReasonTokenRejected
+	}
+
+	// NOTE: the signer may issue certificates with a shorter lifetime than requested. If the lifetime the certificate
+	// was requested with is unknown, only a decreased expirationTTL can be detected.
+	maxLifetime := time.Duration(config.ExpirationSeconds)*time.Second + certificateBackdate
+
+	switch {
+	case config.ForceRotation:
+		return certInfo, RotationReasonRotationRequested
+	case certInfo.User != config.User || !sets.New(certInfo.Groups...).Equal(sets.New(config.Groups...)):
+		return certInfo, RotationReasonSubjectChanged
+	case config.ExistingExpirationSeconds != 0 && config.ExistingExpirationSeconds != config.ExpirationSeconds,
+		certInfo.Lifetime() > maxLifetime:
+		return certInfo, RotationReasonExpirationTTLChanged
+	case !time.Now().Before(certInfo.RefreshTime(config.RefreshPolicy)):
+		return certInfo, RotationReasonRefreshDue
+	}
+
+	return certInfo, ""
+}
+
+// requestCertificate returns the certificate of the pending request of the user once the signer issued it. Without a
+// pending request for the desired subject and lifetime, it generates a private key and submits and approves a
+// CertificateSigningRequest for it. It returns ErrCredentialPending while the certificate isn't issued. Finished
+// requests are deleted as they aren't needed anymore.
+func (p *ClientCertificateProvider) requestCertificate(ctx context.Context, config EnsureConfig) (*TokenInfo, error) {
+	p.abandonExpired(ctx, config.User)
+
+	p.mu.Lock()
+	pending := p.pending[config.User]
+	p.mu.Unlock()
+
+	switch {
+	case pending == nil:
+	case time.Since(pending.requestedAt) > p.pendingTimeout:
+		p.abandon(ctx, config.User, pending)
+		return nil, fmt.Errorf("certificate signing request %s wasn't issued within %s", pending.name, p.pendingTimeout)
+	case pending.expirationSeconds != config.ExpirationSeconds || !sets.New(pending.groups...).Equal(sets.New(config.Groups...)):
+		p.abandon(ctx, config.User, pending)
+		pending = nil
+	}
+
+	if pending == nil {
+		var err error
+		pending, err = p.submitRequest(ctx, config)
+		if err != nil {
+			return nil, err
+		}
+		p.mu.Lock()
+		p.pending[config.User] = pending
+		p.mu.Unlock()
+	}
+
+	issued, err := p.kubeClient.CertificatesV1().CertificateSigningRequests().Get(ctx, pending.name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		p.abandon(ctx, config.User, pending)
+		return nil, fmt.Errorf("certificate signing request %s was deleted", pending.name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get certificate signing request %s: %w", pending.name, err)
+	}
+	for _, condition := range issued.Status.Conditions {
+		if condition.Status == corev1.ConditionTrue &&
+			(condition.Type == certificatesv1.CertificateDenied || condition.Type == certificatesv1.CertificateFailed) {
+			p.abandon(ctx, config.User, pending)
+			return nil, fmt.Errorf("certificate signing request %s %s: %s", pending.name, condition.Type, condition.Message)
+		}
+	}
+	if len(issued.Status.Certificate) == 0 {
+		return nil, ErrCredentialPending
+	}
+	// deleting the request doesn't affect the issued certificate
+	p.abandon(ctx, config.User, pending)
+
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: pending.keyDER})
+	certInfo, err := p.Parse(ctx, string(issued.Status.Certificate)+string(keyPEM))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse issued certificate: %w", err)
+	}
+
+	return certInfo, nil
+}
+
+// submitRequest generates a private key and submits and approves a CertificateSigningRequest for it.
+func (p *ClientCertificateProvider) submitRequest(ctx context.Context, config EnsureConfig) (*pendingCertificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate private key: %w", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode private key: %w", err)
+	}
+	csrDER, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{
+			CommonName:   config.User,
+			Organization: config.Groups,
+		},
+	}, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create certificate request: %w", err)
+	}
+
+	expirationSeconds := int32(min(config.ExpirationSeconds, math.MaxInt32))
+	csr, err := p.kubeClient.CertificatesV1().CertificateSigningRequests().Create(ctx, &certificatesv1.CertificateSigningRequest{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: "kubeconfig-operator-",
+		},
+		Spec: certificatesv1.CertificateSigningRequestSpec{
+			Request:           pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csrDER}),
+			SignerName:        certificatesv1.KubeAPIServerClientSignerName,
+			ExpirationSeconds: &expirationSeconds,
+			Usages: []certificatesv1.KeyUsage{
+				certificatesv1.UsageDigitalSignature,
+				certificatesv1.UsageClientAuth,
+			},
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create certificate signing request for user %q: %w", config.User, err)
+	}
+
+	csr.Status.Conditions = append(csr.Status.Conditions, certificatesv1.CertificateSigningRequestCondition{
+		Type:    certificatesv1.CertificateApproved,
+		Status:  corev1.ConditionTrue,
+		Reason:  "KubeconfigOperatorApproved",
+		Message: "Approved by the kubeconfig-operator for its own client certificate.",
+	})
+	if _, err := p.kubeClient.CertificatesV1().CertificateSigningRequests().UpdateApproval(ctx, csr.GetName(), csr, metav1.UpdateOptions{}); err != nil {
+		_ = p.kubeClient.CertificatesV1().CertificateSigningRequests().Delete(ctx, csr.GetName(), metav1.DeleteOptions{})
+		return nil, fmt.Errorf("failed to approve certificate signing request %s: %w", csr.GetName(), err)
+	}
+
+	return &pendingCertificate{
+		name:              csr.GetName(),
+		keyDER:            keyDER,
+		groups:            config.Groups,
+		expirationSeconds: config.ExpirationSeconds,
+		requestedAt:       time.Now(),
+	}, nil
+}
+
+// abandonExpired abandons the requests of other users that weren't issued in time e.g. because their Kubeconfig was
+// deleted or its user changed while the request was pending.
+func (p *ClientCertificateProvider) abandonExpired(ctx context.Context, user string) {
+	p.mu.Lock()
+	expired := map[string]*pendingCertificate{}
+	for pendingUser, pending := range p.pending {
+		if pendingUser != user && time.Since(pending.requestedAt) > p.pendingTimeout {
+			expired[pendingUser] = pending
+		}
+	}
+	p.mu.Unlock()
+
+	for pendingUser, pending := range expired {
+		p.abandon(ctx, pendingUser, pending)
+	}
+}
+
+// abandon forgets the pending request of the user and deletes it. Failures are cleaned up by the API server.
+func (p *ClientCertificateProvider) abandon(ctx context.Context, user string, pending *pendingCertificate) {
+	p.mu.Lock()
+	if p.pending[user] == pending {
+		delete(p.pending, user)
+	}
+	p.mu.Unlock()
+
+	_ = p.kubeClient.CertificatesV1().CertificateSigningRequests().Delete(ctx, pending.name, metav1.DeleteOptions{})
+}
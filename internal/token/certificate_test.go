@@ -0,0 +1,197 @@
+package token
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	certificatesv1 "k8s.io/api/certificates/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	"github.com/klaudworks/kubeconfig-operator/internal/test"
+)
+
+var _ = Describe("ClientCertificateProvider", func() {
+	var (
+		ctx        context.Context
+		kubeClient *fake.Clientset
+		signer     *test.CertificateSigner
+		stopSigner context.CancelFunc
+		provider   *ClientCertificateProvider
+		config     EnsureConfig
+	)
+
+	// ensure calls Ensure until the requested certificate is issued like the reconciler requeues pending requests
+	ensure := func(existing string) (*TokenInfo, error) {
+		var certInfo *TokenInfo
+		var err error
+		Eventually(func() bool {
+			certInfo, err = provider.Ensure(ctx, existing, config)
+			return errors.Is(err, ErrCredentialPending)
+		}, "5s", "50ms").Should(BeFalse())
+		return certInfo, err
+	}
+
+	BeforeEach(func() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithCancel(context.Background())
+		DeferCleanup(cancel)
+
+		kubeClient = fake.NewSimpleClientset()
+		// the fake client doesn't support generated names
+		var count atomic.Int32
+		kubeClient.PrependReactor("create", "certificatesigningrequests", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			csr := action.(k8stesting.CreateAction).GetObject().(*certificatesv1.CertificateSigningRequest)
+			if csr.Name == "" {
+				csr.Name = fmt.Sprintf("%s%d", csr.GenerateName, count.Add(1))
+			}
+			return false, nil, nil
+		})
+
+		var err error
+		signer, err = test.NewCertificateSigner(kubeClient)
+		Expect(err).NotTo(HaveOccurred())
+		var signerCtx context.Context
+		signerCtx, stopSigner = context.WithCancel(ctx)
+		go signer.Run(signerCtx)
+
+		provider = NewClientCertificateProvider(kubeClient)
+
+		config = EnsureConfig{
+			User:              "klaud.works:kubeconfig:default:test",
+			Groups:            []string{"klaud.works:kubeconfigs"},
+			ExpirationSeconds: 3600,
+			RefreshPolicy:     DefaultRefreshPolicy,
+		}
+	})
+
+	It("should issue a certificate for the user and groups", func() {
+		certInfo, err := ensure("")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(certInfo.RotationReason).To(Equal(RotationReasonNoToken))
+		Expect(certInfo.User).To(Equal(config.User))
+		Expect(certInfo.Groups).To(ConsistOf("klaud.works:kubeconfigs"))
+		Expect(certInfo.ClientCertificate).NotTo(BeEmpty())
+		Expect(certInfo.ClientKey).NotTo(BeEmpty())
+		Expect(certInfo.ExpiresAt).To(BeTemporally("~", time.Now().Add(time.Hour), 5*time.Second))
+
+		By("approving and deleting the signing request")
+		csrs, err := kubeClient.CertificatesV1().CertificateSigningRequests().List(ctx, metav1.ListOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(csrs.Items).To(BeEmpty())
+		Expect(kubeClient.Actions()).To(ContainElement(WithTransform(func(action k8stesting.Action) string {
+			return action.GetSubresource()
+		}, Equal("approval"))))
+	})
+
+	It("should reuse a valid certificate", func() {
+		issued, err := ensure("")
+		Expect(err).NotTo(HaveOccurred())
+
+		reused, err := ensure(issued.Token)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(reused.RotationReason).To(BeEmpty())
+		Expect(reused.Token).To(Equal(issued.Token))
+	})
+
+	It("should rotate the certificate when the subject changes", func() {
+		issued, err := ensure("")
+		Expect(err).NotTo(HaveOccurred())
+
+		config.User = "klaud.works:kubeconfig:default:test:1"
+		rotated, err := ensure(issued.Token)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(rotated.RotationReason).To(Equal(RotationReasonSubjectChanged))
+		Expect(rotated.User).To(Equal(config.User))
+		Expect(rotated.Previous.Token).To(Equal(issued.Token))
+	})
+
+	It("should rotate the certificate when the expirationTTL is decreased", func() {
+		issued, err := ensure("")
+		Expect(err).NotTo(HaveOccurred())
+
+		config.ExpirationSeconds = 600
+		rotated, err := ensure(issued.Token)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(rotated.RotationReason).To(Equal(RotationReasonExpirationTTLChanged))
+		Expect(rotated.ExpiresAt).To(BeTemporally("~", time.Now().Add(10*time.Minute), 5*time.Second))
+	})
+
+	It("should replace invalid certificates", func() {
+		rotated, err := ensure("not a certificate")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(rotated.RotationReason).To(Equal(RotationReasonTokenRejected))
+	})
+
+	It("should return instead of waiting for the signer to issue the certificate", func() {
+		stopSigner()
+
+		_, err := provider.Ensure(ctx, "", config)
+		Expect(err).To(MatchError(ErrCredentialPending))
+		csrs, err := kubeClient.CertificatesV1().CertificateSigningRequests().List(ctx, metav1.ListOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(csrs.Items).To(HaveLen(1))
+		pendingName := csrs.Items[0].Name
+
+		By("not submitting another request while the request is pending")
+		_, err = provider.Ensure(ctx, "", config)
+		Expect(err).To(MatchError(ErrCredentialPending))
+		csrs, err = kubeClient.CertificatesV1().CertificateSigningRequests().List(ctx, metav1.ListOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(csrs.Items).To(ConsistOf(HaveField("Name", pendingName)))
+
+		By("returning the certificate of the pending request once it's issued")
+		go signer.Run(ctx)
+		certInfo, err := ensure("")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(certInfo.User).To(Equal(config.User))
+		csrs, err = kubeClient.CertificatesV1().CertificateSigningRequests().List(ctx, metav1.ListOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(csrs.Items).To(BeEmpty())
+	})
+
+	It("should give up requests that aren't issued in time", func() {
+		stopSigner()
+		provider.pendingTimeout = 100 * time.Millisecond
+
+		_, err := provider.Ensure(ctx, "", config)
+		Expect(err).To(MatchError(ErrCredentialPending))
+
+		time.Sleep(150 * time.Millisecond)
+		_, err = provider.Ensure(ctx, "", config)
+		Expect(err).To(MatchError(ContainSubstring("wasn't issued within 100ms")))
+		csrs, err := kubeClient.CertificatesV1().CertificateSigningRequests().List(ctx, metav1.ListOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(csrs.Items).To(BeEmpty())
+
+		By("submitting a new request on the next attempt")
+		_, err = provider.Ensure(ctx, "", config)
+		Expect(err).To(MatchError(ErrCredentialPending))
+	})
+
+	It("should return an error if the request is denied", func() {
+		kubeClient.PrependReactor("update", "certificatesigningrequests", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			if action.GetSubresource() != "approval" {
+				return false, nil, nil
+			}
+			csr := action.(k8stesting.UpdateAction).GetObject().(*certificatesv1.CertificateSigningRequest)
+			csr.Status.Conditions = []certificatesv1.CertificateSigningRequestCondition{{
+				Type:    certificatesv1.CertificateDenied,
+				Status:  corev1.ConditionTrue,
+				Message: "denied by policy",
+			}}
+			return false, nil, nil
+		})
+
+		_, err := ensure("")
+		Expect(err).To(MatchError(ContainSubstring("denied by policy")))
+	})
+})
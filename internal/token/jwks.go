@@ -0,0 +1,246 @@
+package token
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/rest"
+)
+
+// JWKSPath is the path where the API server publishes the public keys used to sign service account tokens.
+const JWKSPath = "/openid/v1/jwks"
+
+const (
+	// keySetCacheTTL is the maximum age of the cached keys before they are fetched again.
+	keySetCacheTTL = time.Hour
+	// keySetMinRefreshInterval limits how often the keys are fetched when tokens reference an unknown key ID.
+	keySetMinRefreshInterval = 10 * time.Second
+)
+
+// ErrKeyNotFound is returned if a token was signed by a key the API server doesn't publish anymore.
+var ErrKeyNotFound = errors.New("signing key not found in JWKS")
+
+// validSigningMethods are the algorithms the API server supports for signing service account tokens.
+var validSigningMethods = []string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512"}
+
+// KeySet verifies service account tokens against the keys published by the API server at JWKSPath.
+// The keys are cached and fetched again once they are older than an hour or a token references an unknown key.
+type KeySet struct {
+	restClient         rest.Interface
+	cacheTTL           time.Duration
+	minRefreshInterval time.Duration
+
+	mu        sync.Mutex
+	keys      map[string]crypto.PublicKey
+	fetchedAt time.Time
+}
+
+// NewKeySet returns a KeySet that fetches the keys via the given REST client.
+func NewKeySet(restClient rest.Interface) *KeySet {
+	return &KeySet{
+		restClient:         restClient,
+		cacheTTL:           keySetCacheTTL,
+		minRefreshInterval: keySetMinRefreshInterval,
+	}
+}
+
+// Refresh fetches the keys from the API server and reports whether the published key IDs changed.
+func (k *KeySet) Refresh(ctx context.Context) (bool, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	return k.refresh(ctx)
+}
+
+// KeyIDs returns the IDs of the cached keys.
+func (k *KeySet) KeyIDs() sets.Set[string] {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	return sets.KeySet(k.keys)
+}
+
+// key returns the public key with the given ID. The keys are fetched if the cache expired or if the key is unknown
+// and the keys weren't fetched recently.
+func (k *KeySet) key(ctx context.Context, keyID string) (crypto.PublicKey, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if time.Since(k.fetchedAt) > k.cacheTTL {
+		if _, err := k.refresh(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	if key, ok := k.keys[keyID]; ok {
+		return key, nil
+	}
+
+	if time.Since(k.fetchedAt) > k.minRefreshInterval {
+		if _, err := k.refresh(ctx); err != nil {
+			return nil, err
+		}
+		if key, ok := k.keys[keyID]; ok {
+			return key, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w: %q", ErrKeyNotFound, keyID)
+}
+
+// refresh fetches the keys from the API server. The caller must hold the lock.
+func (k *KeySet) refresh(ctx context.Context) (bool, error) {
+	body, err := k.restClient.Get().AbsPath(JWKSPath).DoRaw(ctx)
+	if err != nil {
+		return false, fmt.Errorf("failed to fetch JWKS: %w", err)
+	}
+
+	keys, err := parseJWKS(body)
+	if err != nil {
+		return false, err
+	}
+
+	changed := !sets.KeySet(keys).Equal(sets.KeySet(k.keys))
+	k.keys = keys
+	k.fetchedAt = time.Now()
+
+	return changed, nil
+}
+
+// ParseToken verifies the signature of the token and extracts its claims.
+// Time based claims are not validated as expired tokens are handled by the refresh logic.
+func (k *KeySet) ParseToken(ctx context.Context, tokenStr string) (*TokenInfo, error) {
+	parser := jwt.NewParser(
+		jwt.WithValidMethods(validSigningMethods),
+		jwt.WithoutClaimsValidation(),
+	)
+
+	token, err := parser.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
+		keyID, _ := token.Header["kid"].(string)
+		return k.key(ctx, keyID)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify token: %w", err)
+	}
+
+	tokenInfo, err := parseClaims(tokenStr, token)
+	if err != nil {
+		return nil, err
+	}
+	tokenInfo.KeyID, _ = token.Header["kid"].(string)
+
+	return tokenInfo, nil
+}
+
+// jsonWebKey is a public key of the JWKS served by the API server.
+type jsonWebKey struct {
+	KeyType string `json:"kty"`
+	KeyID   string `json:"kid"`
+	Use     string `json:"use"`
+
+	// RSA keys
+	N string `json:"n"`
+	E string `json:"e"`
+
+	// EC keys
+	Curve string `json:"crv"`
+	X     string `json:"x"`
+	Y     string `json:"y"`
+}
+
+// parseJWKS parses the RSA and EC signing keys of a JWKS document. Keys of other types are ignored.
+func parseJWKS(data []byte) (map[string]crypto.PublicKey, error) {
+	var jwks struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &jwks); err != nil {
+		return nil, fmt.Errorf("failed to decode JWKS: %w", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(jwks.Keys))
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+
+		var (
+			key crypto.PublicKey
+			err error
+		)
+		switch jwk.KeyType {
+		case "RSA":
+			key, err = jwk.rsaPublicKey()
+		case "EC":
+			key, err = jwk.ecdsaPublicKey()
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode key %q: %w", jwk.KeyID, err)
+		}
+		keys[jwk.KeyID] = key
+	}
+
+	return keys, nil
+}
+
+func (jwk jsonWebKey) rsaPublicKey() (*rsa.PublicKey, error) {
+	n, err := decodeBase64URLInt(jwk.N)
+	if err != nil {
+		return nil, fmt.Errorf("invalid modulus: %w", err)
+	}
+	e, err := decodeBase64URLInt(jwk.E)
+	if err != nil {
+		return nil, fmt.Errorf("invalid exponent: %w", err)
+	}
+	if !e.IsInt64() || e.Int64() > 1<<31-1 {
+		return nil, fmt.Errorf("exponent out of range")
+	}
+
+	return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+}
+
+func (jwk jsonWebKey) ecdsaPublicKey() (*ecdsa.PublicKey, error) {
+	var curve elliptic.Curve
+	switch jwk.Curve {
+	case "P-256":
+		curve = elliptic.P256()
+	case "P-384":
+		curve = elliptic.P384()
+	case "P-521":
+		curve = elliptic.P521()
+	default:
+		return nil, fmt.Errorf("unsupported curve %q", jwk.Curve)
+	}
+
+	x, err := decodeBase64URLInt(jwk.X)
+	if err != nil {
+		return nil, fmt.Errorf("invalid x coordinate: %w", err)
+	}
+	y, err := decodeBase64URLInt(jwk.Y)
+	if err != nil {
+		return nil, fmt.Errorf("invalid y coordinate: %w", err)
+	}
+
+	return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+}
+
+func decodeBase64URLInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
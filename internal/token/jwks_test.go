@@ -0,0 +1,237 @@
+package token
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+var _ = Describe("KeySet", func() {
+	var (
+		ctx     = context.Background()
+		jwks    *fakeJWKS
+		server  *httptest.Server
+		keySet  *KeySet
+		rsaKey  *rsa.PrivateKey
+		ecKey   *ecdsa.PrivateKey
+		created time.Time
+	)
+
+	BeforeEach(func() {
+		var err error
+		rsaKey, err = rsa.GenerateKey(rand.Reader, 2048)
+		Expect(err).NotTo(HaveOccurred())
+		ecKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		Expect(err).NotTo(HaveOccurred())
+
+		jwks = &fakeJWKS{keys: map[string]crypto.PublicKey{
+			"rsa": rsaKey.Public(),
+			"ec":  ecKey.Public(),
+		}}
+		server = httptest.NewServer(jwks)
+		DeferCleanup(server.Close)
+
+		kubeClient, err := kubernetes.NewForConfig(&rest.Config{Host: server.URL})
+		Expect(err).NotTo(HaveOccurred())
+		keySet = NewKeySet(kubeClient.Discovery().RESTClient())
+
+		created = time.Now().Truncate(time.Second)
+	})
+
+	It("should verify tokens signed by a published key", func() {
+		for kid, signed := range map[string]string{
+			"rsa": signToken(jwt.SigningMethodRS256, "rsa", rsaKey, created),
+			"ec":  signToken(jwt.SigningMethodES256, "ec", ecKey, created),
+		} {
+			tokenInfo, err := keySet.ParseToken(ctx, signed)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(tokenInfo.KeyID).To(Equal(kid))
+			Expect(tokenInfo.IssuedAt).To(Equal(created))
+			Expect(tokenInfo.ExpiresAt).To(Equal(created.Add(10 * time.Minute)))
+			Expect(tokenInfo.ServiceAccountUID).To(Equal("sa-uid"))
+			Expect(tokenInfo.Audiences).To(ConsistOf("https://kubernetes.default.svc"))
+		}
+
+		By("serving subsequent verifications from the cache")
+		Expect(jwks.requestCount()).To(Equal(1))
+	})
+
+	It("should verify expired tokens", func() {
+		signed := signToken(jwt.SigningMethodRS256, "rsa", rsaKey, created.Add(-time.Hour))
+
+		_, err := keySet.ParseToken(ctx, signed)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("should reject tokens with an invalid signature", func() {
+		otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		Expect(err).NotTo(HaveOccurred())
+		signed := signToken(jwt.SigningMethodRS256, "rsa", otherKey, created)
+
+		_, err = keySet.ParseToken(ctx, signed)
+		Expect(err).To(MatchError(jwt.ErrTokenSignatureInvalid))
+	})
+
+	It("should reject unsigned tokens", func() {
+		signed := signToken(jwt.SigningMethodNone, "rsa", jwt.UnsafeAllowNoneSignatureType, created)
+
+		_, err := keySet.ParseToken(ctx, signed)
+		Expect(err).To(MatchError(jwt.ErrTokenSignatureInvalid))
+	})
+
+	It("should fetch the keys again if a token references an unknown key", func() {
+		keySet.minRefreshInterval = 0
+		_, err := keySet.Refresh(ctx)
+		Expect(err).NotTo(HaveOccurred())
+
+		newKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		Expect(err).NotTo(HaveOccurred())
+		jwks.setKey("new", newKey.Public())
+
+		tokenInfo, err := keySet.ParseToken(ctx, signToken(jwt.SigningMethodRS256, "new", newKey, created))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(tokenInfo.KeyID).To(Equal("new"))
+		Expect(jwks.requestCount()).To(Equal(2))
+	})
+
+	It("should not fetch the keys more often than the minimum refresh interval", func() {
+		_, err := keySet.Refresh(ctx)
+		Expect(err).NotTo(HaveOccurred())
+
+		newKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = keySet.ParseToken(ctx, signToken(jwt.SigningMethodRS256, "unknown", newKey, created))
+		Expect(err).To(MatchError(ErrKeyNotFound))
+		Expect(jwks.requestCount()).To(Equal(1))
+	})
+
+	It("should report rotated keys", func() {
+		changed, err := keySet.Refresh(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(changed).To(BeTrue())
+		Expect(keySet.KeyIDs().UnsortedList()).To(ConsistOf("rsa", "ec"))
+
+		changed, err = keySet.Refresh(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(changed).To(BeFalse())
+
+		jwks.deleteKey("rsa")
+		changed, err = keySet.Refresh(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(changed).To(BeTrue())
+		Expect(keySet.KeyIDs().UnsortedList()).To(ConsistOf("ec"))
+	})
+
+	It("should rotate tokens signed by a key that is no longer published", func() {
+		signed := signToken(jwt.SigningMethodRS256, "rsa", rsaKey, created)
+		jwks.deleteKey("rsa")
+
+		// the signing key check happens before the token review, so no API server is required
+		_, reason, err := NewServiceAccountTokenProvider(nil, keySet).checkToken(ctx, signed, EnsureConfig{ExpirationSeconds: 600})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(reason).To(Equal(RotationReasonSigningKeyRotated))
+	})
+
+	It("should return an error if the keys can't be fetched", func() {
+		server.Close()
+
+		_, err := keySet.ParseToken(ctx, signToken(jwt.SigningMethodRS256, "rsa", rsaKey, created))
+		Expect(err).To(HaveOccurred())
+		Expect(err).NotTo(MatchError(ErrKeyNotFound))
+		Expect(err).NotTo(MatchError(jwt.ErrTokenSignatureInvalid))
+	})
+})
+
+// fakeJWKS serves the given public keys as JWKS similar to the API server.
+type fakeJWKS struct {
+	mu       sync.Mutex
+	keys     map[string]crypto.PublicKey
+	requests int
+}
+
+func (f *fakeJWKS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != JWKSPath {
+		http.NotFound(w, r)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests++
+
+	var keys []map[string]string
+	for kid, key := range f.keys {
+		jwk := map[string]string{"kid": kid, "use": "sig"}
+		switch key := key.(type) {
+		case *rsa.PublicKey:
+			jwk["kty"] = "RSA"
+			jwk["alg"] = "RS256"
+			jwk["n"] = base64.RawURLEncoding.EncodeToString(key.N.Bytes())
+			jwk["e"] = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes())
+		case *ecdsa.PublicKey:
+			jwk["kty"] = "EC"
+			jwk["alg"] = "ES256"
+			jwk["crv"] = key.Curve.Params().Name
+			jwk["x"] = base64.RawURLEncoding.EncodeToString(key.X.Bytes())
+			jwk["y"] = base64.RawURLEncoding.EncodeToString(key.Y.Bytes())
+		}
+		keys = append(keys, jwk)
+	}
+
+	w.Header().Set("Content-Type", "application/jwk-set+json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})
+}
+
+func (f *fakeJWKS) setKey(kid string, key crypto.PublicKey) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.keys[kid] = key
+}
+
+func (f *fakeJWKS) deleteKey(kid string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.keys, kid)
+}
+
+func (f *fakeJWKS) requestCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.requests
+}
+
+// signToken returns a service account token issued at iat with a lifetime of 10 minutes.
+func signToken(method jwt.SigningMethod, kid string, key interface{}, iat time.Time) string {
+	token := jwt.NewWithClaims(method, jwt.MapClaims{
+		"iat": iat.Unix(),
+		"exp": iat.Add(10 * time.Minute).Unix(),
+		"aud": []string{"https://kubernetes.default.svc"},
+		"kubernetes.io": map[string]interface{}{
+			"serviceaccount": map[string]interface{}{
+				"name": "sa",
+				"uid":  "sa-uid",
+			},
+		},
+	})
+	token.Header["kid"] = kid
+
+	signed, err := token.SignedString(key)
+	Expect(err).NotTo(HaveOccurred())
+	return signed
+}
@@ -2,105 +2,162 @@ package token
 
 import (
 	"context"
-	"fmt"
+	"errors"
+	"hash/fnv"
 	"time"
 
-	"github.com/golang-jwt/jwt/v5"
-	authenticationv1 "k8s.io/api/authentication/v1"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/client-go/kubernetes"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Provider issues the credentials published in the kubeconfig secret.
+type Provider interface {
+	// Ensure returns the existing credential if it can be reused. Otherwise, it issues a new credential with the
+	// RotationReason set and the replaced credential attached as Previous.
+	Ensure(ctx context.Context, existing string, config EnsureConfig) (*TokenInfo, error)
+	// Revoke invalidates all outstanding credentials issued for the config.
+	Revoke(ctx context.Context, config EnsureConfig) error
+	// Parse verifies the credential and extracts its metadata.
+	Parse(ctx context.Context, credential string) (*TokenInfo, error)
+}
+
+// ErrBoundSecretRequired is returned by Ensure if a new credential bound to the kubeconfig secret is required but the
+// secret doesn't exist yet or still holds the replaced credential. The secret has to be (re)created without a credential
+// before calling Ensure again.
+var ErrBoundSecretRequired = errors.New("kubeconfig secret without credential required to bind a new credential")
+
+// ErrCredentialPending is returned by Ensure if a new credential was requested but isn't issued yet. Ensure has to be
+// called again later to retrieve the credential.
+var ErrCredentialPending = errors.New("requested credential not issued yet")
+
+// RotationReason describes why a new token was requested instead of reusing the existing one.
+type RotationReason string
+
+const (
+	RotationReasonNoToken               RotationReason = "NoToken"
+	RotationReasonRefreshDue            RotationReason = "RefreshDue"
+	RotationReasonExpirationTTLChanged  RotationReason = "ExpirationTTLChanged"
+	RotationReasonRotationRequested     RotationReason = "RotationRequested"
+	RotationReasonServiceAccountChanged RotationReason = "ServiceAccountChanged"
+	RotationReasonBindingChanged        RotationReason = "BindingChanged"
+	RotationReasonAudiencesChanged      RotationReason = "AudiencesChanged"
+	RotationReasonTokenRejected         RotationReason = "TokenRejected"
+	RotationReasonSigningKeyRotated     RotationReason = "SigningKeyRotated"
+	RotationReasonSubjectChanged        RotationReason = "SubjectChanged"
 )
 
 type TokenInfo struct {
 	Token     string
 	IssuedAt  time.Time
 	ExpiresAt time.Time
-}
 
-// RefreshTime returns the time when the token should be refreshed based on an 80% lifetime.
-// If the token lifetime is invalid or the prepared time is in the past, it returns the expiration time.
-func (t *TokenInfo) RefreshTime() time.Time {
-	ttlDuration := t.ExpiresAt.Sub(t.IssuedAt)
-	if ttlDuration <= 0 {
-		// Fallback to expiration time if times are invalid.
-		return t.ExpiresAt
-	}
-	return t.IssuedAt.Add(time.Duration(float64(ttlDuration) * 0.8))
-}
+	// ServiceAccountUID is the UID of the service account the token was issued for. Empty if the claim is missing.
+	ServiceAccountUID string
 
-// parseToken decodes the JWT token and extracts the "iat" and "exp" claims.
-// Note: ParseUnverified is used here since we assume the token comes from a trusted source.
-func parseToken(tokenStr string) (tokenInfo *TokenInfo, err error) {
-	parser := jwt.NewParser()
-	// Parse the token without validating the signature.
-	token, _, err := parser.ParseUnverified(tokenStr, jwt.MapClaims{})
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse token: %w", err)
-	}
+	// BoundSecretUID is the UID of the secret the token is bound to. Empty if the token isn't bound to a secret.
+	BoundSecretUID string
 
-	claims, ok := token.Claims.(jwt.MapClaims)
-	if !ok {
-		return nil, fmt.Errorf("failed to cast claims to MapClaims")
-	}
+	// Audiences are the audiences from the "aud" claim of the token.
+	Audiences []string
 
-	expVal, ok := claims["exp"].(float64)
-	if !ok {
-		return nil, fmt.Errorf(`"exp" claim missing or invalid`)
-	}
-	iatVal, ok := claims["iat"].(float64)
-	if !ok {
-		return nil, fmt.Errorf(`"iat" claim missing or invalid`)
-	}
+	// KeyID is the ID of the key that signed the token.
+	KeyID string
+
+	// ClientCertificate and ClientKey are the PEM encoded certificate and private key of client certificate credentials.
+	ClientCertificate []byte
+	ClientKey         []byte
+
+	// User and Groups are the subject a client certificate was issued for. Empty for service account tokens.
+	User   string
+	Groups []string
+
+	// RejectionMessage explains why the API server rejected the token during the TokenReview. Empty if it wasn't rejected.
+	RejectionMessage string
 
-	exp := time.Unix(int64(expVal), 0)
-	iat := time.Unix(int64(iatVal), 0)
+	// RotationReason is set if the token was newly requested. It is empty if an existing token was reused.
+	RotationReason RotationReason
 
-	return &TokenInfo{
-		Token:     tokenStr,
-		IssuedAt:  iat,
-		ExpiresAt: exp,
-	}, nil
+	// Previous is the existing token replaced by this token. Nil if the existing token was reused, missing or malformed.
+	Previous *TokenInfo
 }
 
-// EnsureToken checks whether the current token (if available via secretName) is still valid by reading its "iat" and "exp" claims.
-// It calculates the token's TTL and determines a refresh time at 80% of its lifetime.
-// If the token is not yet due for refresh, it returns the token from the secret.
-// Otherwise, it requests a new token using the Kubernetes API.
-func EnsureToken(
-	ctx context.Context,
-	kubeClient *kubernetes.Clientset,
-	existingToken string,
-	expirationSeconds int64,
-	serviceAccountName string,
-	namespace string,
-) (*TokenInfo, error) {
-
-	if existingToken != "" {
-		tokenInfo, err := parseToken(existingToken)
-		if err == nil && time.Now().Before(tokenInfo.RefreshTime()) {
-			return tokenInfo, nil
-		} else if err != nil {
-			return nil, err
-		}
-	}
+// Lifetime returns the duration between the issue and expiration time of the token.
+func (t *TokenInfo) Lifetime() time.Duration {
+	return t.ExpiresAt.Sub(t.IssuedAt)
+}
+
+// RefreshPolicy determines when a token should be refreshed.
+type RefreshPolicy struct {
+	// LifetimeFraction refreshes the token after the given fraction of its lifetime passed. Ignored if RefreshBefore is set.
+	LifetimeFraction float64
+	// RefreshBefore refreshes the token the given duration before it expires.
+	RefreshBefore time.Duration
+	// Jitter moves the refresh time up to the given duration earlier. The offset is derived from the token itself
+	// so it is stable across reconciles but differs between tokens issued at the same time.
+	Jitter time.Duration
+}
 
-	// Request a new token.
-	tokenRequest := &authenticationv1.TokenRequest{
-		Spec: authenticationv1.TokenRequestSpec{
-			ExpirationSeconds: &expirationSeconds,
-		},
+// minRefreshLifetimeFraction is the earliest fraction of the issued lifetime after which a token is refreshed. It
+// matches the smallest lifetimePercentage, so only schedules that don't fit the issued lifetime are affected.
+const minRefreshLifetimeFraction = 0.01
+
+// DefaultRefreshPolicy refreshes tokens after 80% of their lifetime.
+var DefaultRefreshPolicy = RefreshPolicy{
+	LifetimeFraction: 0.8,
+}
+
+// RefreshTime returns the time when the token should be refreshed based on the given policy. The schedule is based on
+// the lifetime of the issued token, which may be shorter than requested. If the policy doesn't fit that lifetime e.g.
+// because refreshBefore exceeds it or leaves less than 1% of it, the token is refreshed according to
+// DefaultRefreshPolicy instead of almost right away.
+// If the token lifetime is invalid, it returns the expiration time.
+func (t *TokenInfo) RefreshTime(policy RefreshPolicy) time.Time {
+	ttlDuration := t.Lifetime()
+	if ttlDuration <= 0 {
+		// Fallback to expiration time if times are invalid.
+		return t.ExpiresAt
 	}
 
-	tokenResp, err := kubeClient.CoreV1().ServiceAccounts(namespace).CreateToken(ctx, serviceAccountName, tokenRequest, metav1.CreateOptions{})
-	if err != nil {
-		return nil, fmt.Errorf("failed to request token for service account %s/%s: %w", namespace, serviceAccountName, err)
+	refreshTime := t.IssuedAt.Add(time.Duration(float64(ttlDuration) * policy.LifetimeFraction))
+	if policy.RefreshBefore > 0 {
+		refreshTime = t.ExpiresAt.Add(-policy.RefreshBefore)
+	}
+	if policy.Jitter > 0 {
+		hash := fnv.New64a()
+		_, _ = hash.Write([]byte(t.Token))
+		refreshTime = refreshTime.Add(-time.Duration(hash.Sum64() % uint64(policy.Jitter)))
 	}
 
-	tokenData := tokenResp.Status.Token
-	tokenInfo, err := parseToken(tokenData)
-	if err != nil {
-		return nil, fmt.Errorf("failed to decode token claims: %w", err)
+	if refreshTime.Before(t.IssuedAt.Add(time.Duration(float64(ttlDuration) * minRefreshLifetimeFraction))) {
+		return t.IssuedAt.Add(time.Duration(float64(ttlDuration) * DefaultRefreshPolicy.LifetimeFraction))
 	}
+	return refreshTime
+}
 
-	return tokenInfo, nil
+// EnsureConfig describes the desired credential.
+type EnsureConfig struct {
+	// ServiceAccount is the service account the token is issued for. Required for service account tokens
+	ServiceAccount *corev1.ServiceAccount
+	// User and Groups are the subject of client certificates. Required for client certificates
+	User   string
+	Groups []string
+	// ExpirationSeconds is the requested lifetime of the token. Required
+	ExpirationSeconds int64
+	// ExistingExpirationSeconds is the lifetime the existing token was requested with. The existing token is rotated if
+	// it differs from ExpirationSeconds. If unknown, the lifetime of the existing token is compared instead which
+	// rotates tokens whose lifetime was capped by the API server once.
+	ExistingExpirationSeconds int64
+	// RefreshPolicy determines when an existing token is refreshed.
+	RefreshPolicy RefreshPolicy
+	// ForceRotation requests a new token even if the existing token is still valid.
+	ForceRotation bool
+	// Audiences are the intended audiences of the token. The API server uses its own audiences if empty.
+	Audiences []string
+	// ExistingTokenAudiences are the audiences the existing token was requested with.
+	// The existing token is rotated if they differ from Audiences.
+	ExistingTokenAudiences []string
+	// BindToSecret binds the token to BoundSecret. The API server rejects the token once the secret is deleted.
+	BindToSecret bool
+	// BoundSecret is the secret the token is bound to if BindToSecret is set. Existing tokens that are not bound to this
+	// secret are rotated.
+	BoundSecret *corev1.Secret
 }
@@ -0,0 +1,54 @@
+package token
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("TokenInfo", func() {
+	var (
+		issuedAt  = time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+		tokenInfo *TokenInfo
+	)
+
+	BeforeEach(func() {
+		tokenInfo = &TokenInfo{
+			Token:     "token",
+			IssuedAt:  issuedAt,
+			ExpiresAt: issuedAt.Add(365 * 24 * time.Hour),
+		}
+	})
+
+	It("should refresh the given duration before the token expires", func() {
+		refreshTime := tokenInfo.RefreshTime(RefreshPolicy{RefreshBefore: 7 * 24 * time.Hour, Jitter: time.Hour})
+		Expect(refreshTime).To(BeTemporally("<=", tokenInfo.ExpiresAt.Add(-7*24*time.Hour)))
+		Expect(refreshTime).To(BeTemporally(">", tokenInfo.ExpiresAt.Add(-7*24*time.Hour-time.Hour)))
+	})
+
+	It("should fall back to the default policy if the lifetime was capped below refreshBefore", func() {
+		tokenInfo.ExpiresAt = issuedAt.Add(time.Hour)
+
+		refreshTime := tokenInfo.RefreshTime(RefreshPolicy{RefreshBefore: 7 * 24 * time.Hour})
+		Expect(refreshTime).To(Equal(issuedAt.Add(48 * time.Minute)))
+	})
+
+	It("should fall back to the default policy if refreshBefore leaves less than 1% of the capped lifetime", func() {
+		tokenInfo.ExpiresAt = issuedAt.Add(time.Hour)
+
+		refreshTime := tokenInfo.RefreshTime(RefreshPolicy{RefreshBefore: time.Hour - time.Second})
+		Expect(refreshTime).To(Equal(issuedAt.Add(48 * time.Minute)))
+
+		refreshTime = tokenInfo.RefreshTime(RefreshPolicy{RefreshBefore: time.Hour - time.Minute})
+		Expect(refreshTime).To(Equal(issuedAt.Add(time.Minute)))
+	})
+
+	It("should fall back to the default policy if the jitter exceeds the capped lifetime", func() {
+		tokenInfo.ExpiresAt = issuedAt.Add(time.Hour)
+
+		refreshTime := tokenInfo.RefreshTime(RefreshPolicy{LifetimeFraction: 0.5, Jitter: 24 * time.Hour})
+		Expect(refreshTime).To(BeTemporally(">=", issuedAt.Add(36*time.Second)))
+		Expect(refreshTime).To(BeTemporally("<=", issuedAt.Add(48*time.Minute)))
+	})
+})
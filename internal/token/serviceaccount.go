@@ -0,0 +1,243 @@
+package token
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/kubernetes"
+)
+
+// ServiceAccountTokenProvider issues service account tokens via the TokenRequest API.
+type ServiceAccountTokenProvider struct {
+	kubeClient kubernetes.Interface
+	keySet     *KeySet
+}
+
+var _ Provider = &ServiceAccountTokenProvider{}
+
+// NewServiceAccountTokenProvider returns a provider that verifies tokens against the keys of the given KeySet.
+func NewServiceAccountTokenProvider(kubeClient kubernetes.Interface, keySet *KeySet) *ServiceAccountTokenProvider {
+	return &ServiceAccountTokenProvider{
+		kubeClient: kubeClient,
+		keySet:     keySet,
+	}
+}
+
+// Ensure returns the existing token if it can be reused, otherwise it requests a new token.
+func (p *ServiceAccountTokenProvider) Ensure(ctx context.Context, existing string, config EnsureConfig) (*TokenInfo, error) {
+	existingInfo, rotationReason, err := p.checkToken(ctx, existing, config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check existing token: %w", err)
+	}
+	if rotationReason == "" {
+		return existingInfo, nil
+	}
+
+	if config.BindToSecret && (config.BoundSecret == nil || existing != "") {
+		return &TokenInfo{RotationReason: rotationReason, Previous: existingInfo}, ErrBoundSecretRequired
+	}
+
+	tokenInfo, err := p.requestToken(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+	tokenInfo.RotationReason = rotationReason
+	tokenInfo.Previous = existingInfo
+
+	return tokenInfo, nil
+}
+
+// Revoke deletes the service account which invalidates all of its tokens. Tokens of a recreated service account with
+// the same name are rejected by the API server as they reference the UID of the deleted service account.
+func (p *ServiceAccountTokenProvider) Revoke(ctx context.Context, config EnsureConfig) error {
+	sa := config.ServiceAccount
+	err := p.kubeClient.CoreV1().ServiceAccounts(sa.GetNamespace()).Delete(ctx, sa.GetName(), metav1.DeleteOptions{
+		Preconditions: &metav1.Preconditions{UID: &sa.UID},
+	})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete service account %s/%s: %w", sa.GetNamespace(), sa.GetName(), err)
+	}
+
+	return nil
+}
+
+// Parse verifies the signature of the token and extracts its claims.
+func (p *ServiceAccountTokenProvider) Parse(ctx context.Context, credential string) (*TokenInfo, error) {
+	return p.keySet.ParseToken(ctx, credential)
+}
+
+// checkToken checks whether the existing token can be reused by verifying its signature and reading its claims.
+// It returns the parsed token and an empty reason if the token is not yet due for refresh, it was requested
+// with ExpirationSeconds and it was issued for the current incarnation of the service account and bound secret.
+// Tokens signed by a key the API server no longer publishes are rotated. As the signature doesn't tell whether the
+// token was revoked, the token is finally validated via a TokenReview.
+// Otherwise, it returns the reason why a new token has to be requested.
+func (p *ServiceAccountTokenProvider) checkToken(
+	ctx context.Context,
+	existingToken string,
+	config EnsureConfig,
+) (*TokenInfo, RotationReason, error) {
+	if existingToken == "" {
+		return nil, RotationReasonNoToken, nil
+	}
+
+	tokenInfo, err := p.Parse(ctx, existingToken)
+	switch {
+	case errors.Is(err, ErrKeyNotFound):
+		return &TokenInfo{Token: existingToken}, RotationReasonSigningKeyRotated, nil
+	case errors.Is(err, jwt.ErrTokenMalformed), errors.Is(err, jwt.ErrTokenSignatureInvalid):
+		return &TokenInfo{Token: existingToken, RejectionMessage: err.Error()}, RotationReasonTokenRejected, nil
+	case err != nil:
+		return nil, "", err
+	}
+
+	boundSecretUID := ""
+	if config.BindToSecret && config.BoundSecret != nil {
+		boundSecretUID = string(config.BoundSecret.GetUID())
+	}
+
+	switch {
+	case config.ForceRotation:
+		return tokenInfo, RotationReasonRotationRequested, nil
+	case tokenInfo.ServiceAccountUID != "" && tokenInfo.ServiceAccountUID != string(config.ServiceAccount.GetUID()):
+		// tokens of a deleted service account are rejected by the API server even if the service account is recreated
+		return tokenInfo, RotationReasonServiceAccountChanged, nil
+	case tokenInfo.BoundSecretUID != boundSecretUID:
+		return tokenInfo, RotationReasonBindingChanged, nil
+	case !sets.New(config.Audiences...).Equal(sets.New(config.ExistingTokenAudiences...)),
+		len(config.Audiences) > 0 && !sets.New(config.Audiences...).Equal(sets.New(tokenInfo.Audiences...)):
+		return tokenInfo, RotationReasonAudiencesChanged, nil
+	case config.ExistingExpirationSeconds != 0 && config.ExistingExpirationSeconds != config.ExpirationSeconds,
+		config.ExistingExpirationSeconds == 0 && tokenInfo.Lifetime() != time.Duration(config.ExpirationSeconds)*time.Second:
+		// the API server may issue tokens with a shorter lifetime than requested, so compare the requested lifetimes
+		return tokenInfo, RotationReasonExpirationTTLChanged, nil
+	case !time.Now().Before(tokenInfo.RefreshTime(config.RefreshPolicy)):
+		return tokenInfo, RotationReasonRefreshDue, nil
+	}
+
+	rejectionMessage, err := p.reviewToken(ctx, tokenInfo)
+	if err != nil {
+		return nil, "", err
+	}
+	if rejectionMessage != "" {
+		tokenInfo.RejectionMessage = rejectionMessage
+		return tokenInfo, RotationReasonTokenRejected, nil
+	}
+
+	return tokenInfo, "", nil
+}
+
+// reviewToken validates the token via a TokenReview.
+// It returns a message explaining why the token was rejected or an empty string if the token is valid.
+func (p *ServiceAccountTokenProvider) reviewToken(ctx context.Context, tokenInfo *TokenInfo) (string, error) {
+	review := &authenticationv1.TokenReview{
+		Spec: authenticationv1.TokenReviewSpec{
+			Token: tokenInfo.Token,
+			// review against the audiences of the token, otherwise tokens with custom audiences would be rejected
+			Audiences: tokenInfo.Audiences,
+		},
+	}
+
+	reviewResp, err := p.kubeClient.AuthenticationV1().TokenReviews().Create(ctx, review, metav1.CreateOptions{})
+	if err != nil {
+		return "", fmt.Errorf("failed to review token: %w", err)
+	}
+	if !reviewResp.Status.Authenticated {
+		if reviewResp.Status.Error != "" {
+			return reviewResp.Status.Error, nil
+		}
+		return "token is not authenticated", nil
+	}
+
+	return "", nil
+}
+
+// requestToken requests a new token using the Kubernetes API and verifies its signature.
+func (p *ServiceAccountTokenProvider) requestToken(ctx context.Context, config EnsureConfig) (*TokenInfo, error) {
+	serviceAccountName := config.ServiceAccount.GetName()
+	namespace := config.ServiceAccount.GetNamespace()
+
+	tokenRequest := &authenticationv1.TokenRequest{
+		Spec: authenticationv1.TokenRequestSpec{
+			ExpirationSeconds: &config.ExpirationSeconds,
+			Audiences:         config.Audiences,
+		},
+	}
+	if config.BindToSecret {
+		tokenRequest.Spec.BoundObjectRef = &authenticationv1.BoundObjectReference{
+			Kind:       "Secret",
+			APIVersion: corev1.SchemeGroupVersion.String(),
+			Name:       config.BoundSecret.GetName(),
+			UID:        config.BoundSecret.GetUID(),
+		}
+	}
+
+	tokenResp, err := p.kubeClient.CoreV1().ServiceAccounts(namespace).CreateToken(ctx, serviceAccountName, tokenRequest, metav1.CreateOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to request token for service account %s/%s: %w", namespace, serviceAccountName, err)
+	}
+
+	tokenInfo, err := p.Parse(ctx, tokenResp.Status.Token)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse issued token: %w", err)
+	}
+
+	return tokenInfo, nil
+}
+
+// parseClaims extracts the "iat", "exp", "aud", service account and bound secret UID claims of the verified token.
+func parseClaims(tokenStr string, token *jwt.Token) (*TokenInfo, error) {
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, fmt.Errorf("failed to cast claims to MapClaims")
+	}
+
+	expVal, ok := claims["exp"].(float64)
+	if !ok {
+		return nil, fmt.Errorf(`"exp" claim missing or invalid`)
+	}
+	iatVal, ok := claims["iat"].(float64)
+	if !ok {
+		return nil, fmt.Errorf(`"iat" claim missing or invalid`)
+	}
+
+	exp := time.Unix(int64(expVal), 0)
+	iat := time.Unix(int64(iatVal), 0)
+
+	aud, err := claims.GetAudience()
+	if err != nil {
+		return nil, fmt.Errorf(`"aud" claim invalid: %w`, err)
+	}
+
+	return &TokenInfo{
+		Token:             tokenStr,
+		IssuedAt:          iat,
+		ExpiresAt:         exp,
+		ServiceAccountUID: kubernetesClaimUID(claims, "serviceaccount"),
+		BoundSecretUID:    kubernetesClaimUID(claims, "secret"),
+		Audiences:         aud,
+	}, nil
+}
+
+// kubernetesClaimUID extracts the UID of the given object kind from the "kubernetes.io" claim of a service account token
+// e.g. the service account or the object the token is bound to.
+func kubernetesClaimUID(claims jwt.MapClaims, kind string) string {
+	k8sClaims, ok := claims["kubernetes.io"].(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	objClaims, ok := k8sClaims[kind].(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	uid, _ := objClaims["uid"].(string)
+	return uid
+}
@@ -0,0 +1,84 @@
+package token
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"net/http/httptest"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+var _ = Describe("ServiceAccountTokenProvider", func() {
+	var (
+		ctx      = context.Background()
+		provider *ServiceAccountTokenProvider
+		signed   string
+		config   EnsureConfig
+	)
+
+	BeforeEach(func() {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		Expect(err).NotTo(HaveOccurred())
+		server := httptest.NewServer(&fakeJWKS{keys: map[string]crypto.PublicKey{"rsa": key.Public()}})
+		DeferCleanup(server.Close)
+
+		jwksClient, err := kubernetes.NewForConfig(&rest.Config{Host: server.URL})
+		Expect(err).NotTo(HaveOccurred())
+
+		kubeClient := fake.NewSimpleClientset()
+		kubeClient.PrependReactor("create", "tokenreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			review := action.(k8stesting.CreateAction).GetObject().(*authenticationv1.TokenReview)
+			review.Status.Authenticated = true
+			return true, review, nil
+		})
+		provider = NewServiceAccountTokenProvider(kubeClient, NewKeySet(jwksClient.Discovery().RESTClient()))
+
+		// signToken issues tokens with a lifetime of 10 minutes
+		signed = signToken(jwt.SigningMethodRS256, "rsa", key, time.Now())
+		config = EnsureConfig{
+			ServiceAccount:    &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{UID: "sa-uid"}},
+			ExpirationSeconds: 3600,
+			RefreshPolicy:     DefaultRefreshPolicy,
+		}
+	})
+
+	It("should reuse tokens whose lifetime was capped by the API server", func() {
+		config.ExistingExpirationSeconds = 3600
+
+		_, reason, err := provider.checkToken(ctx, signed, config)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(reason).To(BeEmpty())
+	})
+
+	It("should rotate tokens if the requested lifetime changed", func() {
+		config.ExistingExpirationSeconds = 1800
+
+		_, reason, err := provider.checkToken(ctx, signed, config)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(reason).To(Equal(RotationReasonExpirationTTLChanged))
+	})
+
+	It("should compare the lifetime of the token if the requested lifetime is unknown", func() {
+		_, reason, err := provider.checkToken(ctx, signed, config)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(reason).To(Equal(RotationReasonExpirationTTLChanged))
+
+		config.ExpirationSeconds = 600
+		_, reason, err = provider.checkToken(ctx, signed, config)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(reason).To(BeEmpty())
+	})
+})
@@ -2,32 +2,90 @@ package util
 
 import (
 	"fmt"
+	"math"
+	"regexp"
 	"strconv"
+	"time"
 )
 
-// ParseExpirationTTL converts a TTL string (like "365d", "12h", or "30m") to seconds.
-func ParseExpirationTTL(ttl string) (int64, error) {
-	if len(ttl) < 2 {
-		return 0, fmt.Errorf("invalid TTL format, expected format like '365d', '12h', or '30m'")
+// MinExpirationSeconds is the minimum lifetime the API server accepts for service account tokens and certificates.
+const MinExpirationSeconds = 600
+
+var (
+	// compoundDurationPattern matches durations like "365d", "1w", "1d12h" or "1h30m". Each unit may appear at most
+	// once and in descending order.
+	compoundDurationPattern = regexp.MustCompile(`^(?:(\d+)w)?(?:(\d+)d)?(?:(\d+)h)?(?:(\d+)m)?(?:(\d+)s)?$`)
+
+	// isoDurationPattern matches ISO 8601 durations without years and months like "P30D", "P1W" or "PT12H".
+	isoDurationPattern = regexp.MustCompile(`^P(?:(\d+)W)?(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+)S)?)?$`)
+	// isoYearsMonthsPattern matches ISO 8601 durations starting with years or months like "P1Y" or "P6M".
+	isoYearsMonthsPattern = regexp.MustCompile(`^P\d+[YM]`)
+)
+
+var unitSeconds = map[string]int64{
+	"w": 7 * 24 * 60 * 60,
+	"d": 24 * 60 * 60,
+	"h": 60 * 60,
+	"m": 60,
+	"s": 1,
+}
+
+// ParseDuration converts a duration string to a time.Duration. It accepts integers followed by a unit of weeks (w),
+// days (d), hours (h), minutes (m) or seconds (s) like "365d", "1w" or "1d12h", and ISO 8601 durations like "P30D"
+// or "PT12H". Each unit may appear at most once and in descending order. ISO 8601 years and months are rejected as
+// their length varies.
+func ParseDuration(s string) (time.Duration, error) {
+	switch {
+	case compoundDurationPattern.MatchString(s) && s != "":
+		return sumDurationParts(s, compoundDurationPattern.FindStringSubmatch(s))
+	case isoDurationPattern.MatchString(s) && s != "P" && s[len(s)-1] != 'T':
+		return sumDurationParts(s, isoDurationPattern.FindStringSubmatch(s))
+	case isoYearsMonthsPattern.MatchString(s):
+		return 0, fmt.Errorf("invalid duration %q: ISO 8601 years and months are not supported, use weeks or days", s)
+	default:
+		return 0, fmt.Errorf("invalid duration %q, expected format like '365d', '1w', '1d12h', '30m' or 'P30D'", s)
+	}
+}
+
+// sumDurationParts adds up the submatches of a duration pattern which capture weeks, days, hours, minutes and
+// seconds in this order.
+func sumDurationParts(s string, parts []string) (time.Duration, error) {
+	var seconds int64
+	for i, unit := range []string{"w", "d", "h", "m", "s"} {
+		if parts[i+1] == "" {
+			continue
+		}
+		var err error
+		if seconds, err = addDurationPart(seconds, parts[i+1], unit); err != nil {
+			return 0, fmt.Errorf("invalid duration %q: %w", s, err)
+		}
 	}
+	return time.Duration(seconds) * time.Second, nil
+}
 
-	unit := ttl[len(ttl)-1]
-	valueStr := ttl[:len(ttl)-1]
-	value, err := strconv.Atoi(valueStr)
+// addDurationPart adds value units to seconds and guards against overflows.
+func addDurationPart(seconds int64, value, unit string) (int64, error) {
+	// time.Duration counts nanoseconds
+	const maxSeconds = math.MaxInt64 / int64(time.Second)
+
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n > (maxSeconds-seconds)/unitSeconds[unit] {
+		return 0, fmt.Errorf("value %s%s is too large", value, unit)
+	}
+	return seconds + n*unitSeconds[unit], nil
+}
+
+// ParseExpirationTTL converts a TTL string (like "365d", "1d12h", or "P30D") to seconds. See ParseDuration for the
+// supported formats. TTLs shorter than MinExpirationSeconds are rejected.
+func ParseExpirationTTL(ttl string) (int64, error) {
+	duration, err := ParseDuration(ttl)
 	if err != nil {
-		return 0, fmt.Errorf("invalid number: %v", err)
+		return 0, err
 	}
 
-	switch unit {
-	case 'd':
-		return int64(value * 24 * 60 * 60), nil
-	case 'h':
-		return int64(value * 60 * 60), nil
-	case 'm':
-		return int64(value * 60), nil
-	case 's':
-		return int64(value), nil
-	default:
-		return 0, fmt.Errorf("invalid TTL unit %c, expected 'd', 'h', or 'm'", unit)
+	seconds := int64(duration / time.Second)
+	if seconds < MinExpirationSeconds {
+		return 0, fmt.Errorf("TTL %q is shorter than the minimum of %s", ttl, time.Duration(MinExpirationSeconds)*time.Second)
 	}
+	return seconds, nil
 }
@@ -0,0 +1,59 @@
+package util
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ParseDuration", func() {
+	DescribeTable("valid durations",
+		func(duration string, expected time.Duration) {
+			actual, err := ParseDuration(duration)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(actual).To(Equal(expected))
+		},
+		Entry("days", "365d", 365*24*time.Hour),
+		Entry("weeks", "2w", 14*24*time.Hour),
+		Entry("seconds", "590s", 590*time.Second),
+		Entry("compound", "1d12h", 36*time.Hour),
+		Entry("compound with minutes and seconds", "1h30m15s", time.Hour+30*time.Minute+15*time.Second),
+		Entry("ISO 8601 days", "P30D", 30*24*time.Hour),
+		Entry("ISO 8601 weeks", "P1W", 7*24*time.Hour),
+		Entry("ISO 8601 time", "PT12H30M", 12*time.Hour+30*time.Minute),
+		Entry("ISO 8601 date and time", "P1DT12H", 36*time.Hour),
+	)
+
+	DescribeTable("invalid durations",
+		func(duration string, message string) {
+			_, err := ParseDuration(duration)
+			Expect(err).To(MatchError(ContainSubstring(message)))
+		},
+		Entry("empty", "", "expected format like"),
+		Entry("unknown unit", "10x", "expected format like"),
+		Entry("missing unit", "10", "expected format like"),
+		Entry("negative", "-1d", "expected format like"),
+		Entry("fraction", "1.5d", "expected format like"),
+		Entry("empty ISO 8601", "P", "expected format like"),
+		Entry("repeated unit", "1d1d", "expected format like"),
+		Entry("repeated unit with another unit in between", "1h30m1h", "expected format like"),
+		Entry("ascending units", "5m1h", "expected format like"),
+		Entry("ISO 8601 without time", "P1DT", "expected format like"),
+		Entry("ISO 8601 years", "P1Y", "years and months are not supported"),
+		Entry("ISO 8601 months", "P6M", "years and months are not supported"),
+		Entry("overflow", "9999999999999w", "too large"),
+	)
+})
+
+var _ = Describe("ParseExpirationTTL", func() {
+	It("should return the TTL in seconds", func() {
+		Expect(ParseExpirationTTL("1d12h")).To(Equal(int64(36 * 60 * 60)))
+		Expect(ParseExpirationTTL("10m")).To(Equal(int64(MinExpirationSeconds)))
+	})
+
+	It("should reject TTLs below the minimum of the API server", func() {
+		_, err := ParseExpirationTTL("9m59s")
+		Expect(err).To(MatchError(ContainSubstring("shorter than the minimum of 10m0s")))
+	})
+})
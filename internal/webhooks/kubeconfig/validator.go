@@ -0,0 +1,377 @@
+package kubeconfig
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/discovery"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	"github.com/klaudworks/kubeconfig-operator/api/klaud.works/v1alpha1"
+	"github.com/klaudworks/kubeconfig-operator/internal/permissions"
+	"github.com/klaudworks/kubeconfig-operator/internal/serviceaccount"
+)
+
+// +kubebuilder:webhook:path=/validate-klaud-works-v1alpha1-kubeconfig,mutating=false,failurePolicy=fail,sideEffects=None,groups=klaud.works,resources=kubeconfigs,verbs=create;update,versions=v1alpha1,name=vkubeconfig.klaud.works,admissionReviewVersions=v1
+
+// +kubebuilder:rbac:groups=authorization.k8s.io,resources=subjectaccessreviews,verbs=create
+
+// validator rejects Kubeconfigs that grant permissions the requesting user doesn't hold. Without it, anyone who can
+// create a Kubeconfig could grant any permission the operator holds. The checks mirror the escalation prevention of
+// the RBAC API: the user either holds every granted permission, may escalate roles in the granted scope, or for role
+// references may bind the referenced role.
+type validator struct {
+	c         client.Client
+	reader    client.Reader
+	discovery discovery.DiscoveryInterface
+}
+
+var _ admission.CustomValidator = &validator{}
+
+// SetupWebhook registers the validating webhook for Kubeconfigs with the webhook server of the manager.
+func SetupWebhook(mgr manager.Manager) error {
+	discoveryClient, err := discovery.NewDiscoveryClientForConfig(mgr.GetConfig())
+	if err != nil {
+		return err
+	}
+
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(&v1alpha1.Kubeconfig{}).
+		WithValidator(&validator{
+			c:         mgr.GetClient(),
+			reader:    mgr.GetAPIReader(),
+			discovery: discoveryClient,
+		}).
+		Complete()
+}
+
+func (v *validator) ValidateCreate(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	kubeconfig := obj.(*v1alpha1.Kubeconfig)
+	return mergeWarnings(kubeconfig), v.validate(ctx, kubeconfig)
+}
+
+// ValidateUpdate only checks updates that change the permissions or let the Kubeconfig adopt existing roles and
+// bindings. Other updates e.g. removing finalizers don't grant anything and must not be blocked for users whose
+// permissions were reduced since.
+func (v *validator) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) (admission.Warnings, error) {
+	oldKubeconfig, newKubeconfig := oldObj.(*v1alpha1.Kubeconfig), newObj.(*v1alpha1.Kubeconfig)
+	if equality.Semantic.DeepEqual(oldKubeconfig.Spec.NamespacedPermissions, newKubeconfig.Spec.NamespacedPermissions) &&
+		equality.Semantic.DeepEqual(oldKubeconfig.Spec.ClusterPermissions, newKubeconfig.Spec.ClusterPermissions) &&
+		equality.Semantic.DeepEqual(oldKubeconfig.Spec.RoleRefs, newKubeconfig.Spec.RoleRefs) &&
+		equality.Semantic.DeepEqual(oldKubeconfig.Spec.Presets, newKubeconfig.Spec.Presets) &&
+		equality.Semantic.DeepEqual(oldKubeconfig.Spec.Impersonate, newKubeconfig.Spec.Impersonate) &&
+		oldKubeconfig.Spec.AdoptExisting == newKubeconfig.Spec.AdoptExisting {
+		return nil, nil
+	}
+	return mergeWarnings(newKubeconfig), v.validate(ctx, newKubeconfig)
+}
+
+func (v *validator) ValidateDelete(context.Context, runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
+
+// mergeWarnings warns about namespacedPermissions entries that are listed for the same namespace multiple times. They
+// are merged into a single Role which is easily overlooked when reading the spec. Only literal namespace names are
+// compared as selectors depend on the namespaces at reconcile time, overlaps involving selectors are reported by the
+// NamespacesMerged condition instead.
+func mergeWarnings(kubeconfig *v1alpha1.Kubeconfig) admission.Warnings {
+	var namespaces []string
+	entries := map[string]int{}
+	for _, namespacedRole := range kubeconfig.Spec.NamespacedPermissions {
+		if namespacedRole.Namespace == "" {
+			continue
+		}
+		if entries[namespacedRole.Namespace] == 0 {
+			namespaces = append(namespaces, namespacedRole.Namespace)
+		}
+		entries[namespacedRole.Namespace]++
+	}
+
+	var warnings admission.Warnings
+	for _, namespace := range namespaces {
+		if entries[namespace] > 1 {
+			warnings = append(warnings, fmt.Sprintf(
+				"spec.namespacedPermissions: %d entries for namespace %q are merged into a single Role", entries[namespace], namespace,
+			))
+		}
+	}
+	return warnings
+}
+
+// validate returns a forbidden error listing all permissions of the kubeconfig the requesting user doesn't hold.
+func (v *validator) validate(ctx context.Context, kubeconfig *v1alpha1.Kubeconfig) error {
+	req, err := admission.RequestFromContext(ctx)
+	if err != nil {
+		return fmt.Errorf("getting admission request: %w", err)
+	}
+	user := req.UserInfo
+
+	var missing []string
+	for _, namespacedRole := range kubeconfig.Spec.NamespacedPermissions {
+		// selectors match namespaces that may be created later, so the rules have to be held cluster-wide
+		m, err := v.missingExpandedPermissions(ctx, user, "roles", namespacedRole.Namespace, namespacedRole.Rules, namespacedRole.ExcludeResources, namespacedRole.IncludeNewResources)
+		if err != nil {
+			return err
+		}
+		missing = append(missing, m...)
+	}
+
+	if clusterPermissions := kubeconfig.Spec.ClusterPermissions; clusterPermissions != nil {
+		m, err := v.missingExpandedPermissions(ctx, user, "clusterroles", "", clusterPermissions.Rules, clusterPermissions.ExcludeResources, clusterPermissions.IncludeNewResources)
+		if err != nil {
+			return err
+		}
+		missing = append(missing, m...)
+	}
+
+	builder := serviceaccount.NewBuilder(kubeconfig)
+	for _, preset := range builder.ExpandedPresets() {
+		resource := "roles"
+		if preset.Namespace == "" {
+			resource = "clusterroles"
+		}
+		m, err := v.missingPermissions(ctx, user, resource, preset.Namespace, preset.Rules)
+		if err != nil {
+			return err
+		}
+		missing = append(missing, m...)
+	}
+
+	for _, roleRef := range kubeconfig.Spec.RoleRefs {
+		m, err := v.missingRoleRef(ctx, user, roleRef)
+		if err != nil {
+			return err
+		}
+		missing = append(missing, m...)
+	}
+
+	// only users that may impersonate the identity themselves may hand out a kubeconfig impersonating it
+	clusterRules, serviceAccountNamespace, serviceAccountRules := builder.ImpersonationRules()
+	if len(clusterRules) > 0 {
+		m, err := v.missingPermissions(ctx, user, "clusterroles", "", clusterRules)
+		if err != nil {
+			return err
+		}
+		missing = append(missing, m...)
+	}
+	if len(serviceAccountRules) > 0 {
+		m, err := v.missingPermissions(ctx, user, "roles", serviceAccountNamespace, serviceAccountRules)
+		if err != nil {
+			return err
+		}
+		missing = append(missing, m...)
+	}
+
+	if len(missing) == 0 {
+		return nil
+	}
+	return apierrors.NewForbidden(
+		v1alpha1.GroupVersion.WithResource("kubeconfigs").GroupResource(),
+		kubeconfig.Name,
+		fmt.Errorf("user %q is not allowed to grant permissions it doesn't hold: %s", user.Username, strings.Join(missing, ", ")),
+	)
+}
+
+// missingPermissions returns the permissions granted by the rules that the user doesn't hold in the namespace or
+// cluster-wide if the namespace is empty. Users that may escalate the given role resource don't need to hold them.
+func (v *validator) missingPermissions(
+	ctx context.Context,
+	user authenticationv1.UserInfo,
+	roleResource string,
+	namespace string,
+	rules []rbacv1.PolicyRule,
+) ([]string, error) {
+	escalate, err := v.allowed(ctx, user, escalateAttributes(roleResource, namespace))
+	if err != nil || escalate {
+		return nil, err
+	}
+	return v.missingRules(ctx, user, namespace, rules)
+}
+
+// missingExpandedPermissions returns the permissions missing to grant the rules after removing the excluded resources.
+// Users holding the rules as they are also hold the expanded rules. Otherwise, the rules are expanded into the served
+// resources like the controller does, so users may grant e.g. everything but secrets without holding access to them.
+// Rules including new resources are expanded into resources served later as well, so they have to be held as they are.
+func (v *validator) missingExpandedPermissions(
+	ctx context.Context,
+	user authenticationv1.UserInfo,
+	roleResource string,
+	namespace string,
+	rules []rbacv1.PolicyRule,
+	exclude []string,
+	includeNew bool,
+) ([]string, error) {
+	missing, err := v.missingPermissions(ctx, user, roleResource, namespace, rules)
+	if err != nil || len(missing) == 0 || len(exclude) == 0 || includeNew {
+		return missing, err
+	}
+
+	resources, err := permissions.Discover(v.discovery)
+	if err != nil {
+		return nil, err
+	}
+	expanded, err := resources.Expand(rules, exclude)
+	if err != nil {
+		return nil, err
+	}
+	return v.missingRules(ctx, user, namespace, expanded)
+}
+
+// missingRoleRef returns the permissions missing to bind the referenced role. Like for role bindings, users that may
+// bind the role don't need to hold its permissions.
+func (v *validator) missingRoleRef(ctx context.Context, user authenticationv1.UserInfo, roleRef v1alpha1.RoleRef) ([]string, error) {
+	resource := "clusterroles"
+	if roleRef.Kind == "Role" {
+		resource = "roles"
+	}
+	bind, err := v.allowed(ctx, user, authorizationv1.SubjectAccessReviewSpec{
+		ResourceAttributes: &authorizationv1.ResourceAttributes{
+			Namespace: roleRef.Namespace,
+			Verb:      "bind",
+			Group:     rbacv1.GroupName,
+			Resource:  resource,
+			Name:      roleRef.Name,
+		},
+	})
+	if err != nil || bind {
+		return nil, err
+	}
+
+	var rules []rbacv1.PolicyRule
+	if roleRef.Kind == "Role" {
+		role := &rbacv1.Role{}
+		err = v.reader.Get(ctx, client.ObjectKey{Namespace: roleRef.Namespace, Name: roleRef.Name}, role)
+		rules = role.Rules
+	} else {
+		clusterRole := &rbacv1.ClusterRole{}
+		err = v.reader.Get(ctx, client.ObjectKey{Name: roleRef.Name}, clusterRole)
+		rules = clusterRole.Rules
+	}
+	if apierrors.IsNotFound(err) {
+		// the permissions of a missing role are unknown, only users that may bind it are allowed to refer to it
+		return []string{fmt.Sprintf("bind %s %s", roleRef.Kind, roleRef.Name)}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("getting %s %s: %w", roleRef.Kind, roleRef.Name, err)
+	}
+
+	return v.missingRules(ctx, user, roleRef.Namespace, rules)
+}
+
+// missingRules returns a description of each permission granted by the rules that the user doesn't hold in the
+// namespace or cluster-wide if the namespace is empty.
+func (v *validator) missingRules(ctx context.Context, user authenticationv1.UserInfo, namespace string, rules []rbacv1.PolicyRule) ([]string, error) {
+	var missing []string
+	for _, rule := range rules {
+		for _, spec := range ruleAttributes(rule, namespace) {
+			allowed, err := v.allowed(ctx, user, spec)
+			if err != nil {
+				return nil, err
+			}
+			if !allowed {
+				missing = append(missing, describeAttributes(spec))
+			}
+		}
+	}
+	return missing, nil
+}
+
+// allowed checks via a SubjectAccessReview whether the user may perform the action described by the spec.
+func (v *validator) allowed(ctx context.Context, user authenticationv1.UserInfo, spec authorizationv1.SubjectAccessReviewSpec) (bool, error) {
+	spec.User = user.Username
+	spec.UID = user.UID
+	spec.Groups = user.Groups
+	for key, value := range user.Extra {
+		if spec.Extra == nil {
+			spec.Extra = map[string]authorizationv1.ExtraValue{}
+		}
+		spec.Extra[key] = authorizationv1.ExtraValue(value)
+	}
+
+	review := &authorizationv1.SubjectAccessReview{Spec: spec}
+	if err := v.c.Create(ctx, review); err != nil {
+		return false, fmt.Errorf("creating subject access review: %w", err)
+	}
+	return review.Status.Allowed, nil
+}
+
+// escalateAttributes returns the attributes to check whether a user may create roles with permissions it doesn't hold.
+func escalateAttributes(resource, namespace string) authorizationv1.SubjectAccessReviewSpec {
+	return authorizationv1.SubjectAccessReviewSpec{
+		ResourceAttributes: &authorizationv1.ResourceAttributes{
+			Namespace: namespace,
+			Verb:      "escalate",
+			Group:     rbacv1.GroupName,
+			Resource:  resource,
+		},
+	}
+}
+
+// ruleAttributes expands the rule into the attributes of every single permission it grants. Wildcards are kept as
+// they are, so a user needs a wildcard permission to grant one.
+func ruleAttributes(rule rbacv1.PolicyRule, namespace string) []authorizationv1.SubjectAccessReviewSpec {
+	var specs []authorizationv1.SubjectAccessReviewSpec
+	for _, verb := range rule.Verbs {
+		for _, url := range rule.NonResourceURLs {
+			specs = append(specs, authorizationv1.SubjectAccessReviewSpec{
+				NonResourceAttributes: &authorizationv1.NonResourceAttributes{Path: url, Verb: verb},
+			})
+		}
+
+		resourceNames := rule.ResourceNames
+		if len(resourceNames) == 0 {
+			resourceNames = []string{""}
+		}
+		for _, group := range rule.APIGroups {
+			for _, resource := range rule.Resources {
+				resource, subresource, _ := strings.Cut(resource, "/")
+				for _, name := range resourceNames {
+					specs = append(specs, authorizationv1.SubjectAccessReviewSpec{
+						ResourceAttributes: &authorizationv1.ResourceAttributes{
+							Namespace:   namespace,
+							Verb:        verb,
+							Group:       group,
+							Resource:    resource,
+							Subresource: subresource,
+							Name:        name,
+						},
+					})
+				}
+			}
+		}
+	}
+	return specs
+}
+
+// describeAttributes returns a human-readable description of the permission e.g. "get secrets in namespace default".
+func describeAttributes(spec authorizationv1.SubjectAccessReviewSpec) string {
+	if spec.NonResourceAttributes != nil {
+		return fmt.Sprintf("%s %s", spec.NonResourceAttributes.Verb, spec.NonResourceAttributes.Path)
+	}
+
+	attributes := spec.ResourceAttributes
+	resource := attributes.Resource
+	if attributes.Group != "" {
+		resource += "." + attributes.Group
+	}
+	if attributes.Subresource != "" {
+		resource += "/" + attributes.Subresource
+	}
+	if attributes.Name != "" {
+		resource += " " + attributes.Name
+	}
+	if attributes.Namespace == "" {
+		return fmt.Sprintf("%s %s cluster-wide", attributes.Verb, resource)
+	}
+	return fmt.Sprintf("%s %s in namespace %s", attributes.Verb, resource, attributes.Namespace)
+}
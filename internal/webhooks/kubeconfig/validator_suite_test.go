@@ -0,0 +1,75 @@
+package kubeconfig_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/fgrosse/zaptest"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/reddit/achilles-sdk/pkg/logging"
+	sdktest "github.com/reddit/achilles-sdk/pkg/test"
+	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+	ctrlzap "sigs.k8s.io/controller-runtime/pkg/log/zap"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+
+	intscheme "github.com/klaudworks/kubeconfig-operator/internal/scheme"
+	"github.com/klaudworks/kubeconfig-operator/internal/test"
+	"github.com/klaudworks/kubeconfig-operator/internal/webhooks/kubeconfig"
+)
+
+var (
+	ctx     context.Context
+	testEnv *sdktest.TestEnv
+	c       client.Client
+	scheme  *runtime.Scheme
+	log     *zap.SugaredLogger
+)
+
+func TestKubeconfigWebhook(t *testing.T) {
+	RegisterFailHandler(Fail)
+	ctrllog.SetLogger(ctrlzap.New(ctrlzap.WriteTo(GinkgoWriter), ctrlzap.UseDevMode(true)))
+	RunSpecs(t, "Kubeconfig Webhook Suite")
+}
+
+var _ = BeforeSuite(func() {
+	SetDefaultEventuallyTimeout(15 * time.Second)
+	SetDefaultEventuallyPollingInterval(200 * time.Millisecond)
+
+	log = zaptest.LoggerWriter(GinkgoWriter).Sugar()
+	ctx = logging.NewContext(context.Background(), log)
+
+	scheme = intscheme.MustNewScheme()
+
+	var err error
+	testEnv, err = sdktest.NewEnvTestBuilder(ctx).
+		WithCRDDirectoryPaths(
+			test.CRDPaths(),
+		).
+		WithWebhookConfigs(
+			test.WebhookPaths()...,
+		).
+		WithScheme(scheme).
+		WithLog(log.Desugar()).
+		WithManagerSetupFns(
+			func(mgr manager.Manager) error {
+				// only the webhook is tested, Kubeconfigs aren't reconciled
+				return kubeconfig.SetupWebhook(mgr)
+			},
+		).
+		Start()
+
+	Expect(err).ToNot(HaveOccurred())
+
+	c = testEnv.Client
+})
+
+var _ = AfterSuite(func() {
+	By("tearing down the test environment")
+	err := testEnv.Stop()
+	Expect(err).NotTo(HaveOccurred())
+})
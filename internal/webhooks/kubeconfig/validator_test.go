@@ -0,0 +1,313 @@
+package kubeconfig_test
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+
+	"github.com/klaudworks/kubeconfig-operator/api/klaud.works/v1alpha1"
+)
+
+var _ = Describe("Kubeconfig escalation webhook", func() {
+	var (
+		userClient client.Client
+		kubeconfig *v1alpha1.Kubeconfig
+	)
+
+	// grant creates the object as cluster admin and removes it after the spec.
+	grant := func(obj client.Object) {
+		Expect(c.Create(ctx, obj)).To(Succeed())
+		DeferCleanup(func() {
+			Expect(client.IgnoreNotFound(c.Delete(ctx, obj))).To(Succeed())
+		})
+	}
+
+	BeforeEach(func() {
+		user, err := testEnv.TestEnv.ControlPlane.AddUser(envtest.User{Name: "jane", Groups: []string{"developers"}}, nil)
+		Expect(err).NotTo(HaveOccurred())
+		userClient, err = client.New(user.Config(), client.Options{Scheme: scheme})
+		Expect(err).NotTo(HaveOccurred())
+
+		By("allowing the user to manage Kubeconfigs and read pods in the default namespace")
+		grant(&rbacv1.Role{
+			ObjectMeta: metav1.ObjectMeta{Name: "jane", Namespace: "default"},
+			Rules: []rbacv1.PolicyRule{
+				{
+					APIGroups: []string{v1alpha1.GroupVersion.Group},
+					Resources: []string{"kubeconfigs"},
+					Verbs:     []string{"get", "create", "update"},
+				},
+				{
+					APIGroups: []string{""},
+					Resources: []string{"pods"},
+					Verbs:     []string{"get", "list"},
+				},
+			},
+		})
+		grant(&rbacv1.RoleBinding{
+			ObjectMeta: metav1.ObjectMeta{Name: "jane", Namespace: "default"},
+			Subjects:   []rbacv1.Subject{{Kind: rbacv1.UserKind, APIGroup: rbacv1.GroupName, Name: "jane"}},
+			RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "Role", Name: "jane"},
+		})
+		Eventually(func(g Gomega) {
+			err := userClient.Get(ctx, client.ObjectKey{Namespace: "default", Name: "escalation"}, &v1alpha1.Kubeconfig{})
+			g.Expect(errors.IsNotFound(err)).To(BeTrue(), "unexpected error: %v", err)
+		}).Should(Succeed())
+
+		kubeconfig = &v1alpha1.Kubeconfig{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "escalation",
+				Namespace: "default",
+			},
+			Spec: v1alpha1.KubeconfigSpec{
+				Server: "https://kubernetes.example.com",
+				NamespacedPermissions: []v1alpha1.NamespacedPermissions{
+					{
+						Namespace: "default",
+						Rules: []rbacv1.PolicyRule{
+							{
+								APIGroups: []string{""},
+								Resources: []string{"pods"},
+								Verbs:     []string{"get", "list"},
+							},
+						},
+					},
+				},
+			},
+		}
+	})
+
+	AfterEach(func() {
+		Expect(client.IgnoreNotFound(c.Delete(ctx, kubeconfig))).To(Succeed())
+	})
+
+	It("should allow granting permissions the user holds", func() {
+		Eventually(func() error {
+			return userClient.Create(ctx, kubeconfig)
+		}).Should(Succeed())
+	})
+
+	It("should reject granting namespaced permissions the user doesn't hold", func() {
+		kubeconfig.Spec.NamespacedPermissions[0].Rules = append(kubeconfig.Spec.NamespacedPermissions[0].Rules, rbacv1.PolicyRule{
+			APIGroups: []string{""},
+			Resources: []string{"secrets", "pods/exec"},
+			Verbs:     []string{"get"},
+		})
+
+		err := userClient.Create(ctx, kubeconfig)
+		Expect(errors.IsForbidden(err)).To(BeTrue(), "unexpected error: %v", err)
+		Expect(err).To(MatchError(ContainSubstring("get secrets in namespace default, get pods/exec in namespace default")))
+		Expect(err).NotTo(MatchError(ContainSubstring("list pods")))
+	})
+
+	It("should reject granting cluster permissions and wildcards the user only holds in a namespace", func() {
+		kubeconfig.Spec.NamespacedPermissions[0].Rules[0].Verbs = []string{"*"}
+		kubeconfig.Spec.ClusterPermissions = &v1alpha1.ClusterPermissions{
+			Rules: []rbacv1.PolicyRule{
+				{
+					APIGroups: []string{""},
+					Resources: []string{"pods"},
+					Verbs:     []string{"get"},
+				},
+			},
+		}
+
+		err := userClient.Create(ctx, kubeconfig)
+		Expect(errors.IsForbidden(err)).To(BeTrue(), "unexpected error: %v", err)
+		Expect(err).To(MatchError(ContainSubstring("* pods in namespace default, get pods cluster-wide")))
+	})
+
+	It("should require cluster-wide permissions for namespace selectors", func() {
+		kubeconfig.Spec.NamespacedPermissions[0].Namespace = ""
+		kubeconfig.Spec.NamespacedPermissions[0].NamespaceSelector = &metav1.LabelSelector{
+			MatchLabels: map[string]string{"team": "jane"},
+		}
+
+		err := userClient.Create(ctx, kubeconfig)
+		Expect(errors.IsForbidden(err)).To(BeTrue(), "unexpected error: %v", err)
+		Expect(err).To(MatchError(ContainSubstring("get pods cluster-wide")))
+	})
+
+	It("should require the user to impersonate the identity themselves", func() {
+		kubeconfig.Spec.Impersonate = &v1alpha1.Impersonation{User: "admin", Groups: []string{"ops"}}
+
+		err := userClient.Create(ctx, kubeconfig)
+		Expect(errors.IsForbidden(err)).To(BeTrue(), "unexpected error: %v", err)
+		Expect(err).To(MatchError(ContainSubstring("impersonate users admin cluster-wide, impersonate groups ops cluster-wide")))
+	})
+
+	It("should check the expanded rules of permissions excluding resources", func() {
+		grant(&rbacv1.Role{
+			ObjectMeta: metav1.ObjectMeta{Name: "jane-pdbs", Namespace: "default"},
+			Rules: []rbacv1.PolicyRule{
+				{
+					APIGroups: []string{"policy"},
+					Resources: []string{"poddisruptionbudgets"},
+					Verbs:     []string{"get"},
+				},
+			},
+		})
+		grant(&rbacv1.RoleBinding{
+			ObjectMeta: metav1.ObjectMeta{Name: "jane-pdbs", Namespace: "default"},
+			Subjects:   []rbacv1.Subject{{Kind: rbacv1.UserKind, APIGroup: rbacv1.GroupName, Name: "jane"}},
+			RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "Role", Name: "jane-pdbs"},
+		})
+		kubeconfig.Spec.NamespacedPermissions[0].Rules = []rbacv1.PolicyRule{
+			{
+				APIGroups: []string{"policy"},
+				Resources: []string{"*"},
+				Verbs:     []string{"get"},
+			},
+		}
+		kubeconfig.Spec.NamespacedPermissions[0].ExcludeResources = []string{"secrets"}
+
+		Eventually(func(g Gomega) {
+			err := userClient.Create(ctx, kubeconfig.DeepCopy())
+			g.Expect(errors.IsForbidden(err)).To(BeTrue(), "unexpected error: %v", err)
+			g.Expect(err).To(MatchError(ContainSubstring("get poddisruptionbudgets.policy/status in namespace default")))
+			g.Expect(err).NotTo(MatchError(ContainSubstring("get poddisruptionbudgets.policy in namespace default")))
+		}).Should(Succeed())
+
+		kubeconfig.Spec.NamespacedPermissions[0].ExcludeResources = []string{"poddisruptionbudgets/status"}
+		By("requiring the rules as they are to include resources served later")
+		kubeconfig.Spec.NamespacedPermissions[0].IncludeNewResources = true
+		err := userClient.Create(ctx, kubeconfig.DeepCopy())
+		Expect(errors.IsForbidden(err)).To(BeTrue(), "unexpected error: %v", err)
+		Expect(err).To(MatchError(ContainSubstring("get *.policy in namespace default")))
+
+		kubeconfig.Spec.NamespacedPermissions[0].IncludeNewResources = false
+		Expect(userClient.Create(ctx, kubeconfig)).To(Succeed())
+	})
+
+	It("should check the rules of presets", func() {
+		kubeconfig.Spec.Presets = []v1alpha1.Preset{
+			{Name: v1alpha1.PresetNamespaceEditor, Namespace: "default"},
+			{Name: v1alpha1.PresetSecretsDenied},
+		}
+
+		err := userClient.Create(ctx, kubeconfig)
+		Expect(errors.IsForbidden(err)).To(BeTrue(), "unexpected error: %v", err)
+		Expect(err).To(MatchError(ContainSubstring("create deployments.apps in namespace default")))
+		Expect(err).NotTo(MatchError(ContainSubstring("secrets")))
+	})
+
+	It("should allow users that may escalate roles to grant any permission in the namespace", func() {
+		kubeconfig.Spec.NamespacedPermissions[0].Rules[0].Resources = []string{"secrets"}
+		Expect(userClient.Create(ctx, kubeconfig)).NotTo(Succeed())
+
+		grant(&rbacv1.Role{
+			ObjectMeta: metav1.ObjectMeta{Name: "jane-escalate", Namespace: "default"},
+			Rules: []rbacv1.PolicyRule{{
+				APIGroups: []string{rbacv1.GroupName},
+				Resources: []string{"roles"},
+				Verbs:     []string{"escalate"},
+			}},
+		})
+		grant(&rbacv1.RoleBinding{
+			ObjectMeta: metav1.ObjectMeta{Name: "jane-escalate", Namespace: "default"},
+			Subjects:   []rbacv1.Subject{{Kind: rbacv1.GroupKind, APIGroup: rbacv1.GroupName, Name: "developers"}},
+			RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "Role", Name: "jane-escalate"},
+		})
+
+		Eventually(func() error {
+			return userClient.Create(ctx, kubeconfig)
+		}).Should(Succeed())
+	})
+
+	It("should allow referencing roles only if the user holds their permissions or may bind them", func() {
+		// envtest doesn't aggregate the rules of the bootstrap ClusterRoles e.g. view, so a dedicated role is used
+		grant(&rbacv1.ClusterRole{
+			ObjectMeta: metav1.ObjectMeta{Name: "configmap-reader"},
+			Rules: []rbacv1.PolicyRule{{
+				APIGroups: []string{""},
+				Resources: []string{"configmaps"},
+				Verbs:     []string{"get"},
+			}},
+		})
+		kubeconfig.Spec.RoleRefs = []v1alpha1.RoleRef{{Kind: "ClusterRole", Name: "configmap-reader", Namespace: "default"}}
+
+		err := userClient.Create(ctx, kubeconfig)
+		Expect(errors.IsForbidden(err)).To(BeTrue(), "unexpected error: %v", err)
+		Expect(err).To(MatchError(ContainSubstring("get configmaps in namespace default")))
+
+		grant(&rbacv1.Role{
+			ObjectMeta: metav1.ObjectMeta{Name: "jane-bind", Namespace: "default"},
+			Rules: []rbacv1.PolicyRule{{
+				APIGroups:     []string{rbacv1.GroupName},
+				Resources:     []string{"clusterroles"},
+				ResourceNames: []string{"configmap-reader"},
+				Verbs:         []string{"bind"},
+			}},
+		})
+		grant(&rbacv1.RoleBinding{
+			ObjectMeta: metav1.ObjectMeta{Name: "jane-bind", Namespace: "default"},
+			Subjects:   []rbacv1.Subject{{Kind: rbacv1.UserKind, APIGroup: rbacv1.GroupName, Name: "jane"}},
+			RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "Role", Name: "jane-bind"},
+		})
+
+		Eventually(func() error {
+			return userClient.Create(ctx, kubeconfig)
+		}).Should(Succeed())
+	})
+
+	It("should warn about entries that are merged into the same Role", func() {
+		warnings := &warningRecorder{}
+		cfg := rest.CopyConfig(testEnv.Cfg)
+		cfg.WarningHandler = warnings
+		warningClient, err := client.New(cfg, client.Options{Scheme: scheme, WarningHandler: client.WarningHandlerOptions{SuppressWarnings: true}})
+		Expect(err).NotTo(HaveOccurred())
+
+		kubeconfig.Spec.NamespacedPermissions = append(kubeconfig.Spec.NamespacedPermissions, v1alpha1.NamespacedPermissions{
+			Namespace: "default",
+			Rules: []rbacv1.PolicyRule{{
+				APIGroups: []string{""},
+				Resources: []string{"configmaps"},
+				Verbs:     []string{"get"},
+			}},
+		})
+		Expect(warningClient.Create(ctx, kubeconfig)).To(Succeed())
+		Expect(warnings.messages).To(ConsistOf(`spec.namespacedPermissions: 2 entries for namespace "default" are merged into a single Role`))
+	})
+
+	It("should only check updates that change the permissions", func() {
+		By("creating a Kubeconfig with permissions the user doesn't hold as cluster admin")
+		kubeconfig.Spec.NamespacedPermissions[0].Rules[0].Resources = []string{"secrets"}
+		Eventually(func() error {
+			return c.Create(ctx, kubeconfig)
+		}).Should(Succeed())
+
+		By("allowing the user to update other fields")
+		Eventually(func(g Gomega) {
+			g.Expect(userClient.Get(ctx, client.ObjectKeyFromObject(kubeconfig), kubeconfig)).To(Succeed())
+			kubeconfig.Spec.ExpirationTTL = "30d"
+			g.Expect(userClient.Update(ctx, kubeconfig)).To(Succeed())
+		}).Should(Succeed())
+
+		By("rejecting changes to the permissions")
+		Expect(userClient.Get(ctx, client.ObjectKeyFromObject(kubeconfig), kubeconfig)).To(Succeed())
+		kubeconfig.Spec.NamespacedPermissions[0].Rules[0].Verbs = []string{"get"}
+		err := userClient.Update(ctx, kubeconfig)
+		Expect(errors.IsForbidden(err)).To(BeTrue(), "unexpected error: %v", err)
+		Expect(err).To(MatchError(ContainSubstring("get secrets in namespace default")))
+
+		By("rejecting adopting existing roles and bindings")
+		Expect(userClient.Get(ctx, client.ObjectKeyFromObject(kubeconfig), kubeconfig)).To(Succeed())
+		kubeconfig.Spec.AdoptExisting = true
+		err = userClient.Update(ctx, kubeconfig)
+		Expect(errors.IsForbidden(err)).To(BeTrue(), "unexpected error: %v", err)
+	})
+})
+
+// warningRecorder records the warnings returned by the API server.
+type warningRecorder struct {
+	messages []string
+}
+
+func (w *warningRecorder) HandleWarningHeader(_ int, _ string, message string) {
+	w.messages = append(w.messages, message)
+}